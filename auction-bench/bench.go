@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionclient"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	"github.com/Alex-itu/A_Distributed_Auction_System/logging"
+
+	"google.golang.org/grpc/status"
+)
+
+// auction-bench puts load on the auction with many simulated bidders and reports how it held up.
+// Run against running servers with:
+// go run ./auction-bench -serverPorts ":8080 :8081 :8082" -bidders 2000 -duration 30s
+// or start the servers in this process and kill the leader half way through:
+// go run ./auction-bench -local 3 -kill 15s -duration 30s -strategy mixed
+
+var serverPorts = flag.String("serverPorts", ":8080 :8081 :8082", "The addresses of the servers separated by spaces")
+var local = flag.Int("local", 0, "Start this many servers in this process instead of using -serverPorts")
+var kill = flag.Duration("kill", 0, "With -local, kill the leader this long into the run (0 kills nobody)")
+var bidders = flag.Int("bidders", 1000, "How many bidders bid at the same time")
+var conns = flag.Int("conns", 8, "How many connections to each server the bidders share")
+var duration = flag.Duration("duration", 30*time.Second, "How long to run")
+var strategyName = flag.String("strategy", "mixed", "How the bidders bid: increment, random, sniper or mixed")
+var resultRatio = flag.Float64("results", 0.2, "The share of the calls that ask for the result instead of bidding")
+var think = flag.Duration("think", 100*time.Millisecond, "How long a bidder waits between calls on average")
+var timeout = flag.Duration("timeout", 5*time.Second, "The deadline of each call")
+var seed = flag.Int64("seed", 1, "The seed for the strategies and the waits")
+
+// out is where the report goes, with -local the servers log to a file instead
+var out = os.Stdout
+
+// a sample is one call a bidder made
+type sample struct {
+	bid      bool
+	returned time.Time
+	latency  time.Duration
+	err      error
+	accepted bool
+	reason   string // why a bid was rejected
+}
+
+// stats collects the samples of every bidder
+type stats struct {
+	mutex   sync.Mutex
+	samples []sample
+	highest float32 // the highest bid any bidder has heard of
+}
+
+func (s *stats) add(smp sample) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.samples = append(s.samples, smp)
+}
+
+func (s *stats) seen(amount float32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if amount > s.highest {
+		s.highest = amount
+	}
+}
+
+func (s *stats) highestBid() float32 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.highest
+}
+
+// a strategy decides what a bidder bids. It returns false if the bidder should ask for the result instead.
+type strategy func(r *rand.Rand, highest float32, elapsed time.Duration) (float32, bool)
+
+var strategies = map[string]strategy{
+	// always a little over the highest bid
+	"increment": func(r *rand.Rand, highest float32, elapsed time.Duration) (float32, bool) {
+		return highest + float32(1+r.Intn(10)), true
+	},
+	// somewhere around the highest bid, so most of them are too low
+	"random": func(r *rand.Rand, highest float32, elapsed time.Duration) (float32, bool) {
+		return highest - 50 + float32(r.Intn(60)), true
+	},
+	// watch until the last tenth of the run, then bid hard
+	"sniper": func(r *rand.Rand, highest float32, elapsed time.Duration) (float32, bool) {
+		if elapsed < *duration*9/10 {
+			return 0, false
+		}
+		return highest + float32(1+r.Intn(50)), true
+	},
+}
+
+func main() {
+	flag.Parse()
+	if _, found := strategies[*strategyName]; !found && *strategyName != "mixed" {
+		fmt.Printf("unknown strategy %s \n", *strategyName)
+		os.Exit(2)
+	}
+
+	servers := strings.Split(*serverPorts, " ")
+	cfg := auctionclient.Config{}
+	var cluster *auctiontest.Cluster
+	if *local > 0 {
+		dir, err := os.MkdirTemp("", "auction-bench")
+		if err != nil {
+			log.Fatal(err)
+		}
+		// the servers log everything they do, which would drown the report
+		logFile, err := os.Create(filepath.Join(dir, "servers.log"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer logFile.Close()
+		slog.SetDefault(slog.New(slog.NewJSONHandler(logFile, nil)))
+
+		end := time.Now().Add(*duration + time.Minute)
+		cluster, err = auctiontest.Start(dir, *local, func(id int, cfg *auctionserver.Config) {
+			cfg.EndTime = end
+		})
+		if err != nil {
+			fmt.Fprintf(out, "failed to start the servers: %v \n", err)
+			os.Exit(1)
+		}
+		defer cluster.StopAll()
+		cluster.WaitForLeader()
+		servers = cluster.Addrs
+		cfg.DialOptions = cluster.DialOptions()
+		fmt.Fprintf(out, "started %d servers, their logs are in %s \n", *local, dir)
+	} else {
+		// the client logs every failed try, there are far too many of those here
+		cfg.Logger = logging.Discard()
+	}
+
+	// the bidders share a few connections, each of them bids as its own client over one of them
+	var pool []*auctionclient.Client
+	for i := 0; i < *conns; i++ {
+		cfg.Servers = servers
+		c, err := auctionclient.New(cfg)
+		if err != nil {
+			fmt.Fprintf(out, "failed to connect: %v \n", err)
+			os.Exit(1)
+		}
+		defer c.Close()
+		pool = append(pool, c)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	err := pool[0].WaitReady(ctx)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(out, "no server is up: %v \n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(out, "%d bidders, strategy %s, for %v \n", *bidders, *strategyName, *duration)
+	var s stats
+	start := time.Now()
+	end := start.Add(*duration)
+
+	type killing struct {
+		server int
+		at     time.Time
+	}
+	kills := make(chan killing, 1)
+	if cluster != nil && *kill > 0 {
+		go func() {
+			time.Sleep(*kill)
+			k := killing{server: cluster.Leader(), at: time.Now()}
+			if k.server >= 0 {
+				cluster.Stop(k.server)
+			}
+			kills <- k
+		}()
+	}
+
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *bidders; i++ {
+		r := rand.New(rand.NewSource(*seed + int64(i)))
+		name := *strategyName
+		if name == "mixed" {
+			name = names[r.Intn(len(names))]
+		}
+		client := pool[i%len(pool)].As(int32(i+1), fmt.Sprintf("bidder%d", i+1))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bidder(client, strategies[name], r, &s, start, end)
+		}()
+	}
+	wg.Wait()
+
+	k := killing{server: -1}
+	select {
+	case k = <-kills:
+	default:
+	}
+	report(&s, time.Since(start), start, k.server, k.at)
+}
+
+// bidder bids or asks for the result until the end of the run, with a random wait in between.
+func bidder(client *auctionclient.Client, bid strategy, r *rand.Rand, s *stats, start, end time.Time) {
+	// spread the first calls out, so they don't all come at once
+	time.Sleep(time.Duration(r.Int63n(int64(*think) + 1)))
+	for time.Now().Before(end) {
+		amount, bidding := bid(r, s.highestBid(), time.Since(start))
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		called := time.Now()
+		if bidding && r.Float64() >= *resultRatio {
+			ack, err := client.Bid(ctx, amount)
+			smp := sample{bid: true, returned: time.Now(), latency: time.Since(called), err: err}
+			if err == nil {
+				smp.accepted = ack.Accepted
+				if ack.Accepted {
+					s.seen(amount)
+				} else {
+					smp.reason = reason(ack.Message)
+				}
+			}
+			s.add(smp)
+		} else {
+			outcome, err := client.Result(ctx)
+			s.add(sample{returned: time.Now(), latency: time.Since(called), err: err})
+			if err == nil {
+				s.seen(outcome.Amount)
+			}
+		}
+		cancel()
+		time.Sleep(time.Duration(r.Int63n(2*int64(*think) + 1)))
+	}
+}
+
+// reason sorts the rejections by the message the server sent back.
+func reason(message string) string {
+	switch {
+	case strings.HasPrefix(message, "Bid is lower"):
+		return "too low"
+	case strings.HasPrefix(message, "The auction is over"):
+		return "over"
+	case strings.Contains(message, "cancelled"):
+		return "cancelled"
+	case strings.Contains(message, "banned"):
+		return "banned"
+	case strings.Contains(message, "budget"):
+		return "over budget"
+	}
+	return "other"
+}
+
+func report(s *stats, elapsed time.Duration, start time.Time, killed int, killedAt time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var bidLatency, resultLatency []time.Duration
+	var answered []time.Time // when bids were answered, to find the gaps
+	accepted, results, resultErrors := 0, 0, 0
+	rejected := make(map[string]int)
+	bidErrors := make(map[string]int)
+	for _, smp := range s.samples {
+		if !smp.bid {
+			results++
+			if smp.err != nil {
+				resultErrors++
+			} else {
+				resultLatency = append(resultLatency, smp.latency)
+			}
+			continue
+		}
+		if smp.err != nil {
+			bidErrors[status.Code(smp.err).String()]++
+			continue
+		}
+		bidLatency = append(bidLatency, smp.latency)
+		answered = append(answered, smp.returned)
+		if smp.accepted {
+			accepted++
+		} else {
+			rejected[smp.reason]++
+		}
+	}
+	bids := len(s.samples) - results
+
+	fmt.Fprintf(out, "ran for %.1fs \n", elapsed.Seconds())
+	fmt.Fprintf(out, "Bid:    %d calls, %.1f/s, %s \n", bids, float64(bids)/elapsed.Seconds(), percentiles(bidLatency))
+	fmt.Fprintf(out, "        accepted %d (%s) \n", accepted, share(accepted, bids))
+	for _, r := range sortedKeys(rejected) {
+		fmt.Fprintf(out, "        rejected as %s %d (%s) \n", r, rejected[r], share(rejected[r], bids))
+	}
+	for _, code := range sortedKeys(bidErrors) {
+		fmt.Fprintf(out, "        failed with %s %d (%s) \n", code, bidErrors[code], share(bidErrors[code], bids))
+	}
+	fmt.Fprintf(out, "Result: %d calls, %.1f/s, %s, failed %d (%s) \n", results, float64(results)/elapsed.Seconds(), percentiles(resultLatency), resultErrors, share(resultErrors, results))
+	fmt.Fprintf(out, "highest bid %v \n", s.highest)
+
+	// the failover time is how long no bid was answered after the leader was killed.
+	// Without -kill the longest such gap is reported, which is the failover time if a server was killed by hand.
+	sort.Slice(answered, func(i, j int) bool { return answered[i].Before(answered[j]) })
+	if killed >= 0 && !killedAt.IsZero() {
+		for _, t := range answered {
+			if t.After(killedAt) {
+				fmt.Fprintf(out, "failover: server %d was killed at %.1fs, the next bid was answered %v later \n", killed, killedAt.Sub(start).Seconds(), t.Sub(killedAt).Round(time.Millisecond))
+				return
+			}
+		}
+		fmt.Fprintf(out, "failover: server %d was killed at %.1fs and no bid was answered after that \n", killed, killedAt.Sub(start).Seconds())
+		return
+	}
+	var gap time.Duration
+	var gapAt time.Time
+	for i := 1; i < len(answered); i++ {
+		if d := answered[i].Sub(answered[i-1]); d > gap {
+			gap = d
+			gapAt = answered[i-1]
+		}
+	}
+	if gap > 0 {
+		fmt.Fprintf(out, "longest time without an answered bid: %v at %.1fs \n", gap.Round(time.Millisecond), gapAt.Sub(start).Seconds())
+	}
+}
+
+// percentiles returns the p50 and p99 of the latencies.
+func percentiles(latencies []time.Duration) string {
+	if len(latencies) == 0 {
+		return "no answers"
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	at := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))].Round(10 * time.Microsecond)
+	}
+	return fmt.Sprintf("p50 %v, p99 %v, max %v", at(0.5), at(0.99), latencies[len(latencies)-1].Round(10*time.Microsecond))
+}
+
+func share(n, of int) string {
+	if of == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(of))
+}
+
+func sortedKeys(m map[string]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,552 @@
+// Package auctionclient is a Go client for the replicated auction service.
+//
+// A Client talks to every server it is given. It watches the gRPC health service of each server
+// in the background and only sends requests to the healthy ones. Bids and retractions go to the
+// leader: a server that is not the leader answers Unavailable and the client tries the next one,
+// remembering which server took the request so it is tried first next time. When no server
+// could take a request the whole round is retried a few times, waiting longer every time, before
+// giving up. Every bid carries an idempotency key that stays the same over the retries, so a bid that
+// went through but whose answer was lost is answered again instead of being made twice.
+// Each try has its own deadline, so a server that hangs is given up on, and bids and results can
+// be hedged: sent to a second server too when the first is slow to answer.
+//
+// The servers given are only where the client starts. It asks them for the members of the cluster
+// now and then, and connects to the replicas that were added and drops the ones that were removed.
+// Instead of addresses, Servers can be a cluster registered with the discovery package
+// (auction:///name), then the replicas come from its source, e.g. a file or DNS, through the
+// resolver the package registers with gRPC.
+//
+//	c, err := auctionclient.New(auctionclient.Config{Servers: []string{":8080", ":8081", ":8082"}, ClientID: 1, Name: "alice"})
+//	if err != nil { ... }
+//	defer c.Close()
+//	ack, err := c.Bid(ctx, 100)
+package auctionclient
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/discovery"
+	"github.com/Alex-itu/A_Distributed_Auction_System/logging"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+	"github.com/Alex-itu/A_Distributed_Auction_System/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoServer is returned when none of the servers is healthy.
+var ErrNoServer = errors.New("auctionclient: no server is up")
+
+// Config is what New needs to make a Client. Only Servers is required.
+type Config struct {
+	Servers  []string // the addresses of the servers to start with, the index is the server id. Or one auction:/// target
+	ClientID int32
+	Name     string
+
+	// DialOptions are used to dial every server. The default is a plain connection without TLS.
+	DialOptions []grpc.DialOption
+
+	Retries       int           // how many more rounds over the servers to try when none took a request. Default 5
+	RetryDelay    time.Duration // how long to wait before the first retry, doubled every round. Default 100ms
+	MaxRetryDelay time.Duration // the longest wait between two rounds. Default 2s
+
+	// Timeout is how long a call may take with all its retries when ctx has no deadline.
+	// Default 10s, a negative timeout waits as long as the retries take.
+	Timeout time.Duration
+
+	// TryTimeout is how long one try on one server may take before the next server is tried,
+	// so a server that hangs does not hold the call up. Default 3s, a negative timeout turns it off.
+	TryTimeout time.Duration
+
+	// HedgeAfter sends a Bid or Result to the next server as well when the first one has not
+	// answered after this long, and the first answer is used. Bids can be hedged because a server
+	// answers a bid it has already taken with its first answer. Default 0, which turns it off.
+	HedgeAfter time.Duration
+
+	// OnHealthChange is called when a server goes up or down. It is optional.
+	OnHealthChange func(server int, healthy bool)
+
+	// DiscoverInterval is how often the servers (or the source of an auction:/// target) are asked
+	// which replicas there are. Default 2s, a negative interval keeps the client on the servers it started with.
+	DiscoverInterval time.Duration
+
+	// Logger is where the client logs to. Default slog.Default()
+	Logger *slog.Logger
+
+	// TracerProvider makes a span for every Bid, Retract and Result, with a span for every server
+	// that was tried in it. Default otel.GetTracerProvider(), which does nothing unless one has been set.
+	TracerProvider trace.TracerProvider
+}
+
+// Client is safe to use from several goroutines.
+type Client struct {
+	cfg      Config
+	tracer   trace.Tracer
+	set      *replicaSet
+	source   discovery.Source  // where the replicas come from, nil for an auction:/// target
+	resolver resolver.Resolver // looks up the replicas of an auction:/// target, see resolve.go
+	ctx      context.Context   // cancelled by Close, stops the health watchers and the discovery
+	cancel   context.CancelFunc
+
+	mutex     sync.Mutex
+	preferred int // the server that last took a bid or answered a result, most likely the leader
+
+	shared bool // made by As, the connections belong to another Client
+}
+
+// New dials every server and starts watching their health. The dials don't block,
+// so New succeeds even if the servers are down, they are picked up when they come up.
+func New(cfg Config) (*Client, error) {
+	if len(cfg.Servers) == 0 {
+		return nil, errors.New("auctionclient: no servers given")
+	}
+	if cfg.DialOptions == nil {
+		cfg.DialOptions = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = 5
+	}
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = 100 * time.Millisecond
+	}
+	if cfg.MaxRetryDelay == 0 {
+		cfg.MaxRetryDelay = 2 * time.Second
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.TryTimeout == 0 {
+		cfg.TryTimeout = 3 * time.Second
+	}
+	if cfg.DiscoverInterval == 0 {
+		cfg.DiscoverInterval = 2 * time.Second
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.TracerProvider == nil {
+		cfg.TracerProvider = otel.GetTracerProvider()
+	}
+	// every call carries a request id, so the servers log it with the same id as the client,
+	// and the trace context, so the spans of the servers end up in the trace of the client.
+	// A call that was rate limited gets the wait the server asked for in its error
+	opts := append([]grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(logging.UnaryClientInterceptor, tracing.UnaryClientInterceptor(cfg.TracerProvider), retryAfterInterceptor),
+		grpc.WithChainStreamInterceptor(logging.StreamClientInterceptor, tracing.StreamClientInterceptor(cfg.TracerProvider)),
+	}, cfg.DialOptions...)
+
+	c := &Client{
+		cfg:    cfg,
+		tracer: cfg.TracerProvider.Tracer(tracing.InstrumentationName + "/auctionclient"),
+		set:    &replicaSet{opts: opts, onChange: cfg.OnHealthChange, logger: cfg.Logger},
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if _, ok := discovery.ClusterName(cfg.Servers[0]); ok && len(cfg.Servers) == 1 {
+		ctx, cancel := context.WithTimeout(c.ctx, discoverTimeout)
+		err := c.resolve(ctx, cfg.Servers[0])
+		cancel()
+		if err != nil {
+			c.cancel()
+			return nil, err
+		}
+	} else {
+		c.source = discovery.SourceFunc(c.askMembers)
+		for id, addr := range cfg.Servers {
+			r, err := c.set.dial(c.ctx, id, addr)
+			if err != nil {
+				c.Close()
+				return nil, err
+			}
+			c.set.replicas = append(c.set.replicas, r)
+		}
+	}
+	if cfg.DiscoverInterval > 0 {
+		go c.discover()
+	}
+	return c, nil
+}
+
+// As returns a Client that bids as another client over the same connections, so many bidders
+// don't need a connection each. Closing it does nothing, the connections are closed with c.
+func (c *Client) As(clientID int32, name string) *Client {
+	cfg := c.cfg
+	cfg.ClientID = clientID
+	cfg.Name = name
+	c.mutex.Lock()
+	preferred := c.preferred
+	c.mutex.Unlock()
+	return &Client{cfg: cfg, tracer: c.tracer, set: c.set, ctx: c.ctx, cancel: c.cancel, preferred: preferred, shared: true}
+}
+
+// Close stops the health watchers and closes the connections.
+func (c *Client) Close() error {
+	if c.shared {
+		return nil
+	}
+	c.cancel()
+	if c.resolver != nil {
+		c.resolver.Close()
+	}
+	return c.set.closeAll()
+}
+
+// discoverTimeout is how long a lookup of the replicas may take.
+const discoverTimeout = 5 * time.Second
+
+// discover looks up the replicas every DiscoverInterval, so bids find their way to replicas
+// that were added after the client started.
+func (c *Client) discover() {
+	// the discovery runs as long as the client does, which is no use as a trace
+	ctx := tracing.Untraced(c.ctx)
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(c.cfg.DiscoverInterval):
+		}
+		if c.resolver != nil {
+			c.resolver.ResolveNow(resolver.ResolveNowOptions{})
+			continue
+		}
+		callCtx, cancel := context.WithTimeout(ctx, discoverTimeout)
+		members, err := c.source.Members(callCtx)
+		cancel()
+		if status.Code(err) == codes.Unimplemented {
+			return // the servers are older than the discovery, stay with the ones we have
+		}
+		if err != nil {
+			c.cfg.Logger.Debug("could not look up the servers", "err", err)
+			continue
+		}
+		c.set.update(c.ctx, members)
+	}
+}
+
+// askMembers asks the healthy servers for the members of the cluster, the leader first.
+// It is the source of a client that was given addresses.
+func (c *Client) askMembers(ctx context.Context) ([]discovery.Member, error) {
+	err := ErrNoServer
+	for _, r := range c.ordered() {
+		var list *gRPC.MemberList
+		list, err = r.client.Members(ctx, &gRPC.Void{})
+		if status.Code(err) == codes.Unimplemented {
+			return nil, err
+		}
+		if err != nil {
+			continue
+		}
+		// a replica that is still joining knows of no members yet
+		var members []discovery.Member
+		if members, err = discovery.FromProto(list.Members); err == nil {
+			return members, nil
+		}
+	}
+	return nil, err
+}
+
+// WaitReady blocks until at least one server is healthy or ctx is done.
+func (c *Client) WaitReady(ctx context.Context) error {
+	for len(c.healthy()) == 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// Healthy returns the ids of the servers that are healthy right now.
+func (c *Client) Healthy() []int {
+	var ids []int
+	for _, r := range c.healthy() {
+		ids = append(ids, r.id)
+	}
+	return ids
+}
+
+// Bid makes a bid for the client. Check Ack.Accepted to see if it went through,
+// Ack.Message says why not (e.g. it was lower than the highest bid).
+// Every call makes a new idempotency key for the bid and sends it with every try, so a try whose
+// answer got lost is answered the same again instead of being made twice. Two calls are two bids,
+// also when they share a ctx.
+func (c *Client) Bid(ctx context.Context, amount float32) (*gRPC.Ack, error) {
+	bid := &gRPC.BidAmount{ClientID: c.cfg.ClientID, ClientName: c.cfg.Name, Amount: amount, IdempotencyKey: newIdempotencyKey()}
+	return c.write(ctx, "Bid", true, func(ctx context.Context, s gRPC.AuctionServiceClient) (*gRPC.Ack, error) {
+		return s.Bid(ctx, bid)
+	})
+}
+
+// newIdempotencyKey makes a random key for a bid.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Retract takes back the client's current bid, if the server's rules allow it.
+// It is never hedged, a second try would find no bid to take back.
+func (c *Client) Retract(ctx context.Context, reason string) (*gRPC.Ack, error) {
+	retraction := &gRPC.Retraction{ClientID: c.cfg.ClientID, ClientName: c.cfg.Name, Reason: reason}
+	return c.write(ctx, "Retract", false, func(ctx context.Context, s gRPC.AuctionServiceClient) (*gRPC.Ack, error) {
+		return s.RetractBid(ctx, retraction)
+	})
+}
+
+// ConfirmPayment tells the servers the client paid for what it won, reference is what the payment
+// provider gave it. Check Ack.Accepted to see if the payment was confirmed. The servers only take
+// it from the buyer, so the client has to be dialed with a client certificate whose common name is
+// its Name. It is not hedged, so the payment provider is asked once per try.
+func (c *Client) ConfirmPayment(ctx context.Context, reference string) (*gRPC.Ack, error) {
+	confirmation := &gRPC.PaymentConfirmation{ClientID: c.cfg.ClientID, ClientName: c.cfg.Name, Reference: reference}
+	return c.write(ctx, "ConfirmPayment", false, func(ctx context.Context, s gRPC.AuctionServiceClient) (*gRPC.Ack, error) {
+		return s.ConfirmPayment(ctx, confirmation)
+	})
+}
+
+// Result returns the highest bid, or the winner if the auction is over.
+// Only the leader answers, after it has checked with a majority that it still is the leader,
+// so the result includes every bid that was accepted before it was asked for.
+func (c *Client) Result(ctx context.Context) (*gRPC.Outcome, error) {
+	return retry(c, ctx, "Result", true, func(ctx context.Context, r *replica) (*gRPC.Outcome, error) {
+		outcome, err := r.client.Result(ctx, &gRPC.Void{})
+		if err == nil {
+			c.mutex.Lock()
+			c.preferred = r.id
+			c.mutex.Unlock()
+		}
+		return outcome, err
+	})
+}
+
+// Watch sends the outcome on the returned channel every time it changes, until ctx is done.
+// If the server it watches goes down it carries on with another one, so the same outcome
+// can be sent more than once. The channel is closed when ctx is done.
+// Any server can be watched, and one that follows the leader can be a little behind it,
+// use Result for an outcome that is up to date.
+func (c *Client) Watch(ctx context.Context) (<-chan *gRPC.Outcome, error) {
+	if err := c.WaitReady(ctx); err != nil {
+		return nil, err
+	}
+	out := make(chan *gRPC.Outcome)
+	go func() {
+		defer close(out)
+		for ctx.Err() == nil {
+			for _, r := range c.ordered() {
+				stream, err := r.client.Watch(ctx, &gRPC.Void{})
+				if err != nil {
+					continue
+				}
+				for {
+					outcome, err := stream.Recv()
+					if err != nil {
+						c.cfg.Logger.Warn("lost the watch", "server", r.id, "err", err)
+						break
+					}
+					select {
+					case out <- outcome:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(c.cfg.RetryDelay):
+			}
+		}
+	}()
+	return out, nil
+}
+
+// write sends a bid or retraction to the leader. hedge says if it may go to two servers at once.
+func (c *Client) write(ctx context.Context, name string, hedge bool, call func(context.Context, gRPC.AuctionServiceClient) (*gRPC.Ack, error)) (*gRPC.Ack, error) {
+	return retry(c, ctx, name, hedge, func(ctx context.Context, r *replica) (*gRPC.Ack, error) {
+		ack, err := call(ctx, r.client)
+		if err == nil {
+			c.mutex.Lock()
+			c.preferred = r.id
+			c.mutex.Unlock()
+		}
+		return ack, err
+	})
+}
+
+// retry calls call on the healthy servers, the preferred one first, until one of them succeeds.
+// A server that is down or not the leader answers Unavailable, then the next server is tried,
+// and so it is for the other transient errors. Any other error is returned straight away.
+// A server that rate limited the call is not asked again before the time it said.
+// name is the name of the span all the tries are in. With hedge, the tries of a round
+// can overlap, see tryRound.
+func retry[T any](c *Client, ctx context.Context, name string, hedge bool, call func(ctx context.Context, r *replica) (T, error)) (result T, err error) {
+	// every try gets the same request id, so they can be found together in the logs of the servers
+	if logging.RequestID(ctx) == "" {
+		ctx = logging.WithRequestID(ctx, logging.NewRequestID())
+	}
+	if _, ok := ctx.Deadline(); !ok && c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+	ctx, span := c.tracer.Start(ctx, "auctionclient."+name, trace.WithAttributes(
+		attribute.Int("auction.client", int(c.cfg.ClientID)),
+		attribute.String("request_id", logging.RequestID(ctx)),
+	))
+	defer func() {
+		if err != nil {
+			span.SetStatus(otelcodes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	lastErr := ErrNoServer
+	for round := 0; round <= c.cfg.Retries; round++ {
+		if round > 0 {
+			select {
+			case <-ctx.Done():
+				return result, ctx.Err()
+			case <-time.After(max(c.backoff(round), RetryAfter(lastErr))):
+			}
+		}
+		servers := c.ordered()
+		if len(servers) == 0 {
+			continue
+		}
+		result, err, done := tryRound(c, ctx, servers, hedge, call)
+		if done {
+			return result, err
+		}
+		lastErr = err
+	}
+	return result, lastErr
+}
+
+// tryRound tries the servers one after the other until one answers with something other than a
+// transient error, and done is true. Each try gets TryTimeout. With hedge, the next server is
+// also tried when a try has not answered after HedgeAfter, and the first answer is used,
+// the tries that are still running are cancelled.
+func tryRound[T any](c *Client, ctx context.Context, servers []*replica, hedge bool, call func(ctx context.Context, r *replica) (T, error)) (result T, err error, done bool) {
+	type answer struct {
+		r      *replica
+		result T
+		err    error
+	}
+	roundCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	answers := make(chan answer, len(servers))
+	next, running := 0, 0
+	var hedgeAfter <-chan time.Time
+	start := func() {
+		r := servers[next]
+		next++
+		running++
+		go func() {
+			tryCtx, cancelTry := roundCtx, context.CancelFunc(func() {})
+			if c.cfg.TryTimeout > 0 {
+				tryCtx, cancelTry = context.WithTimeout(roundCtx, c.cfg.TryTimeout)
+			}
+			defer cancelTry()
+			result, err := call(tryCtx, r)
+			answers <- answer{r, result, err}
+		}()
+		hedgeAfter = nil
+		if hedge && c.cfg.HedgeAfter > 0 && next < len(servers) {
+			hedgeAfter = time.After(c.cfg.HedgeAfter)
+		}
+	}
+
+	logger := logging.FromContext(ctx, c.cfg.Logger)
+	err = ErrNoServer
+	start()
+	for running > 0 {
+		select {
+		case a := <-answers:
+			running--
+			if a.err == nil {
+				return a.result, nil, true
+			}
+			if ctx.Err() != nil {
+				return result, ctx.Err(), true
+			}
+			if !transient(a.err) {
+				return a.result, a.err, true
+			}
+			logger.Info("server could not take the request", "server", a.r.id, "err", a.err)
+			// being rate limited says more than the others not being the leader, and how long to wait
+			if RetryAfter(err) == 0 || RetryAfter(a.err) > 0 {
+				err = a.err
+			}
+			if running == 0 && next < len(servers) {
+				start()
+			}
+		case <-hedgeAfter:
+			logger.Debug("hedging the request", "server", servers[next].id, "after", c.cfg.HedgeAfter)
+			trace.SpanFromContext(ctx).AddEvent("hedged", trace.WithAttributes(attribute.Int("auction.server", servers[next].id)))
+			start()
+		}
+	}
+	return result, err, false
+}
+
+// transient reports if another try (on this or another server) could go through where err did not.
+func transient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// backoff is how long to wait before a round of retries: RetryDelay doubled every round up to
+// MaxRetryDelay, with up to half of it taken off at random, so clients that failed together
+// don't all come back at the same moment.
+func (c *Client) backoff(round int) time.Duration {
+	delay := c.cfg.RetryDelay
+	for i := 1; i < round && delay < c.cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.cfg.MaxRetryDelay {
+		delay = c.cfg.MaxRetryDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// ordered returns the healthy servers with the preferred one first.
+func (c *Client) ordered() []*replica {
+	c.mutex.Lock()
+	preferred := c.preferred
+	c.mutex.Unlock()
+
+	healthy := c.healthy()
+	for i, r := range healthy {
+		if r.id == preferred {
+			healthy[0], healthy[i] = healthy[i], healthy[0]
+			break
+		}
+	}
+	return healthy
+}
+
+func (c *Client) healthy() []*replica {
+	var healthy []*replica
+	for _, r := range c.set.list() {
+		if r.isHealthy() {
+			healthy = append(healthy, r)
+		}
+	}
+	return healthy
+}
@@ -0,0 +1,205 @@
+package auctionclient_test
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionclient"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	"github.com/Alex-itu/A_Distributed_Auction_System/discovery"
+	"github.com/Alex-itu/A_Distributed_Auction_System/events"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func withAdmin(id int, cfg *auctionserver.Config) { cfg.AdminToken = "secret" }
+
+// placed returns the bids the cluster took, as the leader replays them.
+func placed(t *testing.T, c *auctiontest.Cluster) []events.Event {
+	t.Helper()
+	conn, err := grpc.Dial(c.Addrs[c.WaitForLeader()], c.DialOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "admin-token", "secret")
+	stream, err := gRPC.NewAuctionAdminClient(conn).ReplayEvents(ctx, &gRPC.ReplayRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bids []events.Event
+	for {
+		pe, err := stream.Recv()
+		if err == io.EOF {
+			return bids
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := events.FromProto(pe); e.Type == events.BidPlaced {
+			bids = append(bids, e)
+		}
+	}
+}
+
+// onFirstBid calls f with the first try of the first bid, send sends it on to the server.
+func onFirstBid(f func(send func() error) error) grpc.DialOption {
+	var once sync.Once
+	return grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		send := func() error { return invoker(ctx, method, req, reply, cc, opts...) }
+		first := false
+		if method == gRPC.AuctionService_Bid_FullMethodName {
+			once.Do(func() { first = true })
+		}
+		if first {
+			return f(send)
+		}
+		return send()
+	})
+}
+
+func TestLeaderGoesAwayMidBid(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		reaching bool // if the bid gets to the leader before it goes away
+	}{
+		{"before the bid gets there", false},
+		{"before the answer gets back", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := auctiontest.NewCluster(t, 3, withAdmin)
+			leader := c.WaitForLeader()
+			alice := c.Client(1, "alice", onFirstBid(func(send func() error) error {
+				if tt.reaching {
+					if err := send(); err != nil {
+						t.Errorf("the bid did not get to the leader: %v", err)
+					}
+				}
+				c.Stop(leader)
+				return status.Error(codes.Unavailable, "the leader went away")
+			}))
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := alice.WaitReady(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			ack, err := alice.Bid(ctx, 10)
+			if err != nil || !ack.Accepted {
+				t.Fatalf("the bid: %v, %v", ack, err)
+			}
+			if next := c.WaitForLeader(); next == leader {
+				t.Fatalf("replica %d still leads after it was stopped", leader)
+			}
+			if bids := placed(t, c); len(bids) != 1 || bids[0].Amount != 10 {
+				t.Fatalf("the cluster took the bids %+v, want the one of 10", bids)
+			}
+		})
+	}
+}
+
+func TestRetriedBidIsTakenOnce(t *testing.T) {
+	c := auctiontest.NewCluster(t, 3, withAdmin)
+	c.WaitForLeader()
+
+	// the first tries get to the servers, but their answers are lost
+	var mutex sync.Mutex
+	var keys []string
+	lost := 0
+	loseAnswers := grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if method != gRPC.AuctionService_Bid_FullMethodName {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		mutex.Lock()
+		defer mutex.Unlock()
+		keys = append(keys, req.(*gRPC.BidAmount).IdempotencyKey)
+		if lost < 4 {
+			lost++
+			time.Sleep(30 * time.Millisecond)
+			return status.Error(codes.Unavailable, "the answer was lost")
+		}
+		return err
+	})
+	alice, err := auctionclient.New(auctionclient.Config{
+		Servers:     c.Addrs,
+		ClientID:    1,
+		Name:        "alice",
+		DialOptions: append(c.DialOptions(), loseAnswers),
+		RetryDelay:  20 * time.Millisecond,
+		HedgeAfter:  10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := alice.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ack, err := alice.Bid(ctx, 10)
+	if err != nil || !ack.Accepted {
+		t.Fatalf("the bid: %v, %v", ack, err)
+	}
+
+	// another call is another bid, with a key of its own
+	if ack, err := alice.Bid(ctx, 20); err != nil || !ack.Accepted {
+		t.Fatalf("the second bid: %v, %v", ack, err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	tries := make(map[string]int)
+	for _, key := range keys {
+		tries[key]++
+	}
+	if len(tries) != 2 || tries[""] != 0 {
+		t.Fatalf("two bids were sent with the keys %v, want two keys", tries)
+	}
+	if total := tries[keys[0]]; total <= lost {
+		t.Fatalf("the first bid was tried %d times, want more than the %d that were lost", total, lost)
+	}
+	if bids := placed(t, c); len(bids) != 2 || bids[0].Amount != 10 || bids[1].Amount != 20 {
+		t.Fatalf("the cluster took the bids %+v, want one of 10 and one of 20", bids)
+	}
+}
+
+func TestClusterTarget(t *testing.T) {
+	c := auctiontest.NewCluster(t, 3)
+	c.WaitForLeader()
+	discovery.Register("client-test", discovery.Servers(c.Addrs[:1], c.DialOptions()...))
+
+	alice, err := auctionclient.New(auctionclient.Config{Servers: []string{discovery.Target("client-test")}, ClientID: 1, Name: "alice", DialOptions: c.DialOptions()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := alice.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the resolver found every replica, not only the one it asked
+	if ack, err := alice.Bid(ctx, 10); err != nil || !ack.Accepted {
+		t.Fatalf("the bid: %v, %v", ack, err)
+	}
+	for len(alice.Healthy()) < 3 {
+		if ctx.Err() != nil {
+			t.Fatalf("the client only has the servers %v", alice.Healthy())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if _, err := auctionclient.New(auctionclient.Config{Servers: []string{discovery.Target("nobody")}}); err == nil {
+		t.Fatal("New() with a cluster that is not registered did not fail")
+	}
+}
@@ -0,0 +1,51 @@
+package auctionclient
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// retryAfterKey is the trailer a server sends with a rate limited call, the same as auctionserver.RetryAfterKey.
+const retryAfterKey = "retry-after-ms"
+
+// rateLimitedError is a RESOURCE_EXHAUSTED error that says how long to wait before trying again.
+type rateLimitedError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *rateLimitedError) Error() string              { return e.err.Error() }
+func (e *rateLimitedError) Unwrap() error              { return e.err }
+func (e *rateLimitedError) GRPCStatus() *status.Status { return status.Convert(e.err) }
+
+// RetryAfter returns how long a server asked to wait before err's call is made again,
+// or 0 if err is not from a call that was rate limited.
+func RetryAfter(err error) time.Duration {
+	var limited *rateLimitedError
+	if errors.As(err, &limited) {
+		return limited.after
+	}
+	return 0
+}
+
+// retryAfterInterceptor reads the retry-after trailer of a call that was rate limited into its error.
+func retryAfterInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	var trailer metadata.MD
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...)
+	if status.Code(err) != codes.ResourceExhausted {
+		return err
+	}
+	if values := trailer.Get(retryAfterKey); len(values) > 0 {
+		if ms, perr := strconv.ParseInt(values[0], 10, 64); perr == nil && ms > 0 {
+			return &rateLimitedError{err, time.Duration(ms) * time.Millisecond}
+		}
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package auctionclient
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/discovery"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+	"github.com/Alex-itu/A_Distributed_Auction_System/tracing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// replica is one of the servers. The connection is made lazily and gRPC reconnects it by itself,
+// so a server that is down when the client starts (or dies and comes back) is picked up again.
+// healthy is kept up to date by watchHealth in the background.
+type replica struct {
+	id       int
+	addr     string
+	conn     *grpc.ClientConn
+	client   gRPC.AuctionServiceClient
+	onChange func(server int, healthy bool)
+	logger   *slog.Logger
+	stop     context.CancelFunc // ends watchHealth
+
+	mutex   sync.Mutex
+	healthy bool
+}
+
+// replicaSet is the servers a Client, and the clients made from it with As, talk to.
+// It changes when the servers say a replica was added or removed, see Client.discover.
+type replicaSet struct {
+	opts     []grpc.DialOption
+	onChange func(server int, healthy bool)
+	logger   *slog.Logger
+
+	mutex    sync.Mutex
+	replicas []*replica // sorted by id, replaced as a whole and never changed in place
+}
+
+// list returns the servers right now.
+func (set *replicaSet) list() []*replica {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	return set.replicas
+}
+
+// dial connects to a server and starts watching its health until ctx is done or it is removed.
+func (set *replicaSet) dial(ctx context.Context, id int, addr string) (*replica, error) {
+	conn, err := grpc.Dial(addr, set.opts...)
+	if err != nil {
+		return nil, err
+	}
+	r := &replica{id: id, addr: addr, conn: conn, client: gRPC.NewAuctionServiceClient(conn), onChange: set.onChange, logger: set.logger}
+	ctx, r.stop = context.WithCancel(ctx)
+	go r.watchHealth(ctx)
+	return r, nil
+}
+
+// update makes the set the given members. A server the client knows under another id or address
+// (the list it was started with does not have to be in the order of the ids) is dialed again.
+func (set *replicaSet) update(ctx context.Context, members []discovery.Member) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	want := make(map[int]string)
+	for _, m := range members {
+		want[m.ID] = m.Addr
+	}
+	var replicas []*replica
+	for _, r := range set.replicas {
+		if addr, ok := want[r.id]; ok && addr == r.addr {
+			replicas = append(replicas, r)
+			delete(want, r.id)
+			continue
+		}
+		set.logger.Info("server is not in the cluster under this id and address", "server", r.id, "addr", r.addr)
+		r.close()
+	}
+	for id, addr := range want {
+		r, err := set.dial(ctx, id, addr)
+		if err != nil {
+			set.logger.Warn("could not dial a new server", "server", id, "addr", addr, "err", err)
+			continue
+		}
+		set.logger.Info("server found in the cluster", "server", id, "addr", addr)
+		replicas = append(replicas, r)
+	}
+	sort.Slice(replicas, func(i, j int) bool { return replicas[i].id < replicas[j].id })
+	set.replicas = replicas
+}
+
+// closeAll closes every connection.
+func (set *replicaSet) closeAll() error {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	var err error
+	for _, r := range set.replicas {
+		if cerr := r.close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// close stops watching the health and closes the connection.
+func (r *replica) close() error {
+	r.stop()
+	return r.conn.Close()
+}
+
+const healthRetry = 1 * time.Second // how long to wait before watching a server's health again
+
+func (r *replica) isHealthy() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.healthy
+}
+
+// setHealthy updates the health and reports it when it changes.
+func (r *replica) setHealthy(healthy bool) {
+	r.mutex.Lock()
+	changed := r.healthy != healthy
+	r.healthy = healthy
+	r.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+	r.logger.Info("server health changed", "server", r.id, "addr", r.addr, "healthy", healthy)
+	if r.onChange != nil {
+		r.onChange(r.id, healthy)
+	}
+}
+
+// watchHealth follows the standard gRPC health service of the server until ctx is done.
+// If the stream breaks (e.g. the server died) it is opened again after healthRetry.
+func (r *replica) watchHealth(ctx context.Context) {
+	health := healthpb.NewHealthClient(r.conn)
+	// the watch runs as long as the client does, which is no use as a span
+	ctx = tracing.Untraced(ctx)
+	for ctx.Err() == nil {
+		stream, err := health.Watch(ctx, &healthpb.HealthCheckRequest{Service: ""})
+		if err == nil {
+			for {
+				resp, err := stream.Recv()
+				if err != nil {
+					if status.Code(err) == codes.Unimplemented {
+						// the server has no health service, so the best we can do is look at the connection
+						r.watchConnection(ctx)
+						return
+					}
+					break
+				}
+				r.setHealthy(resp.Status == healthpb.HealthCheckResponse_SERVING)
+			}
+		}
+		r.setHealthy(false)
+		select {
+		case <-ctx.Done():
+		case <-time.After(healthRetry):
+		}
+	}
+}
+
+// watchConnection counts the server as healthy whenever the connection to it is ready.
+func (r *replica) watchConnection(ctx context.Context) {
+	for {
+		state := r.conn.GetState()
+		r.setHealthy(state == connectivity.Ready)
+		if state == connectivity.Idle {
+			r.conn.Connect()
+		}
+		if !r.conn.WaitForStateChange(ctx, state) {
+			return
+		}
+	}
+}
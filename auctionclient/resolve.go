@@ -0,0 +1,75 @@
+package auctionclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/discovery"
+
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// resolve follows the replicas of an auction:/// target with the resolver the discovery package
+// registers with gRPC, the same one a grpc.Dial of the target uses. The client still dials every
+// replica on its own, it has to pick the leader itself. It waits for the first lookup until ctx is done.
+func (c *Client) resolve(ctx context.Context, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("auctionclient: %q is not a target: %v", target, err)
+	}
+	builder := resolver.Get(discovery.Scheme)
+	if builder == nil {
+		return fmt.Errorf("auctionclient: no resolver is registered for %s:///", discovery.Scheme)
+	}
+	cc := &resolverConn{c: c, first: make(chan struct{})}
+	if c.resolver, err = builder.Build(resolver.Target{URL: *u}, cc, resolver.BuildOptions{}); err != nil {
+		return fmt.Errorf("auctionclient: %v", err)
+	}
+	// the servers may not be up yet, then the resolver keeps trying in the background
+	select {
+	case <-cc.first:
+	case <-ctx.Done():
+	}
+	if len(c.set.list()) == 0 {
+		c.cfg.Logger.Warn("could not find the servers yet", "target", target)
+	}
+	return nil
+}
+
+// resolverConn is where the resolver sends the replicas it found, instead of a grpc.ClientConn.
+type resolverConn struct {
+	c     *Client
+	once  sync.Once
+	first chan struct{} // closed after the first lookup, whether it worked or not
+}
+
+func (cc *resolverConn) UpdateState(state resolver.State) error {
+	defer cc.once.Do(func() { close(cc.first) })
+	var members []discovery.Member
+	for _, addr := range state.Addresses {
+		id, ok := discovery.ID(addr)
+		if !ok {
+			return fmt.Errorf("auctionclient: the resolver gave %s without the id of its replica", addr.Addr)
+		}
+		members = append(members, discovery.Member{ID: id, Addr: addr.Addr})
+	}
+	cc.c.set.update(cc.c.ctx, members)
+	return nil
+}
+
+func (cc *resolverConn) ReportError(err error) {
+	defer cc.once.Do(func() { close(cc.first) })
+	cc.c.cfg.Logger.Debug("could not look up the servers", "err", err)
+}
+
+// NewAddress and NewServiceConfig are not used by resolvers any more, and a cluster has no service config.
+func (cc *resolverConn) NewAddress([]resolver.Address) {}
+func (cc *resolverConn) NewServiceConfig(string)       {}
+
+func (cc *resolverConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{Err: errors.New("auctionclient: a cluster has no service config")}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/config"
+	"github.com/Alex-itu/A_Distributed_Auction_System/discovery"
+	"github.com/Alex-itu/A_Distributed_Auction_System/events"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// auctionctl talks to the admin service of the servers.
+// Run with:
+// go run ./auctionctl -token secret close "sold out"
+// go run ./auctionctl -token secret extend 18:30:00
+// go run ./auctionctl -token secret extend +10m
+// go run ./auctionctl -token secret cancel "item damaged"
+// go run ./auctionctl -token secret ban 3 "fake bids"
+// go run ./auctionctl -token secret budget 3 500 "credit check"
+// go run ./auctionctl -token secret state
+// go run ./auctionctl -token secret replicas
+// go run ./auctionctl -token secret events 1
+// go run ./auctionctl -token secret -follow events
+// go run ./auctionctl -token secret leaderboard 10
+// go run ./auctionctl -token secret history 3
+// go run ./auctionctl -token secret add-replica 3 localhost:8083
+// go run ./auctionctl -token secret remove-replica 1 "disk failing"
+
+var configFile = flag.String("config", "", "The YAML file the servers were started with, for their addresses, the token and the CA")
+var serverPorts = flag.String("serverPorts", ":8080 :8081 :8082", "The addresses of the servers separated by spaces")
+var discover = flag.String("discover", "", "Find the servers with file:<cluster.yaml>, dns:<SRV name> or members:<addresses> instead of -serverPorts")
+var token = flag.String("token", "", "The admin token the servers were started with (defaults to $AUCTION_ADMIN_TOKEN)")
+var timeout = flag.Duration("timeout", 5*time.Second, "How long to wait for each server")
+var tlsCA = flag.String("tlsCA", "", "The CA the certificates of the servers are signed by (empty dials without TLS)")
+var tlsCert = flag.String("tlsCert", "", "A certificate to show the servers, if they want one")
+var tlsKey = flag.String("tlsKey", "", "The key of -tlsCert")
+var follow = flag.Bool("follow", false, "Keep printing the new events after the replay of events, until it is stopped with Ctrl+C")
+
+var addrs []string // the servers, from -serverPorts or -discover
+
+func main() {
+	flag.Usage = func() {
+		fmt.Println("usage: auctionctl [flags] close|extend|cancel|ban|budget|state|replicas|events|leaderboard|history|add-replica|remove-replica [arguments]")
+		fmt.Println("  close [reason]                   closes the auction now")
+		fmt.Println("  extend HH:MM:SS|+duration        moves the end of the auction")
+		fmt.Println("  cancel [reason]                  calls the auction off, nobody wins")
+		fmt.Println("  ban clientID [reason]            bans a bidder and drops their bid")
+		fmt.Println("  budget clientID amount [reason]  sets how much a bidder can spend, 0 takes the limit away")
+		fmt.Println("  state                            prints the state of every server")
+		fmt.Println("  replicas                         prints the replicas as the first server that answers sees them")
+		fmt.Println("  events [fromSeq]                 prints the events of the auction from the audit log")
+		fmt.Println("  leaderboard [n]                  prints the n highest bids (all of them if n is left out), built from the events")
+		fmt.Println("  history clientID                 prints everything a bidder did, built from the events")
+		fmt.Println("  add-replica id address [reason]  adds a server started with -join to the replicas")
+		fmt.Println("  remove-replica id [reason]       takes a server out of the replicas")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := loadConfig(); err != nil {
+		fmt.Printf("%v \n", err)
+		os.Exit(2)
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	addrs = strings.Split(*serverPorts, " ")
+	if *discover != "" {
+		addrs = discoverServers()
+	}
+	clients := connect(addrs)
+	args := flag.Args()
+	reason := strings.Join(args[1:], " ")
+
+	var err error
+	switch args[0] {
+	case "close":
+		err = onLeader(clients, func(ctx context.Context, c gRPC.AuctionAdminClient) (*gRPC.AdminReply, error) {
+			return c.CloseNow(ctx, &gRPC.AdminRequest{Reason: reason})
+		})
+	case "extend":
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		var end time.Time
+		end, err = parseEndTime(args[1])
+		if err != nil {
+			break
+		}
+		err = onLeader(clients, func(ctx context.Context, c gRPC.AuctionAdminClient) (*gRPC.AdminReply, error) {
+			return c.ExtendEndTime(ctx, &gRPC.ExtendRequest{EndTime: end.Unix(), Reason: strings.Join(args[2:], " ")})
+		})
+	case "cancel":
+		err = onLeader(clients, func(ctx context.Context, c gRPC.AuctionAdminClient) (*gRPC.AdminReply, error) {
+			return c.CancelAuction(ctx, &gRPC.AdminRequest{Reason: reason})
+		})
+	case "ban":
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		var id int
+		id, err = strconv.Atoi(args[1])
+		if err != nil {
+			break
+		}
+		err = onLeader(clients, func(ctx context.Context, c gRPC.AuctionAdminClient) (*gRPC.AdminReply, error) {
+			return c.BanBidder(ctx, &gRPC.BanRequest{ClientID: int32(id), Reason: strings.Join(args[2:], " ")})
+		})
+	case "budget":
+		if len(args) < 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		var id int
+		id, err = strconv.Atoi(args[1])
+		if err != nil {
+			break
+		}
+		var budget float64
+		budget, err = strconv.ParseFloat(args[2], 32)
+		if err != nil {
+			break
+		}
+		err = onLeader(clients, func(ctx context.Context, c gRPC.AuctionAdminClient) (*gRPC.AdminReply, error) {
+			return c.SetBudget(ctx, &gRPC.BudgetRequest{ClientID: int32(id), Budget: float32(budget), Reason: strings.Join(args[3:], " ")})
+		})
+	case "add-replica":
+		if len(args) < 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		var id int
+		id, err = strconv.Atoi(args[1])
+		if err != nil {
+			break
+		}
+		err = onLeader(clients, func(ctx context.Context, c gRPC.AuctionAdminClient) (*gRPC.AdminReply, error) {
+			return c.AddReplica(ctx, &gRPC.MembershipRequest{ServerID: int32(id), Address: args[2], Reason: strings.Join(args[3:], " ")})
+		})
+	case "remove-replica":
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		var id int
+		id, err = strconv.Atoi(args[1])
+		if err != nil {
+			break
+		}
+		err = onLeader(clients, func(ctx context.Context, c gRPC.AuctionAdminClient) (*gRPC.AdminReply, error) {
+			return c.RemoveReplica(ctx, &gRPC.MembershipRequest{ServerID: int32(id), Reason: strings.Join(args[2:], " ")})
+		})
+	case "state":
+		err = printState(clients)
+	case "replicas":
+		err = printReplicas(clients)
+	case "events":
+		req := &gRPC.ReplayRequest{Follow: *follow}
+		if len(args) > 1 {
+			req.FromSeq, err = strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				break
+			}
+		}
+		err = replay(clients, req, printEvent)
+	case "leaderboard":
+		n := 0
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil {
+				break
+			}
+		}
+		board := events.NewLeaderboard()
+		err = replay(clients, &gRPC.ReplayRequest{}, board.Apply)
+		for i, e := range board.Top(n) {
+			fmt.Printf("%d. %d %s: %v (%d bids) \n", i+1, e.ClientID, e.ClientName, e.Amount, e.Bids)
+		}
+	case "history":
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		var id int
+		id, err = strconv.Atoi(args[1])
+		if err != nil {
+			break
+		}
+		history := events.NewBidderHistory()
+		err = replay(clients, &gRPC.ReplayRequest{ClientIDs: []int32{int32(id)}}, history.Apply)
+		for _, e := range history.Of(int32(id)) {
+			printEvent(e)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Printf("%v \n", err)
+		os.Exit(1)
+	}
+}
+
+// loadConfig sets the flags that were not given from the environment and then from the -config file.
+func loadConfig() error {
+	// the token is read from AUCTION_ADMIN_TOKEN, the same as -adminToken of the servers
+	if value, ok := os.LookupEnv("AUCTION_ADMIN_TOKEN"); ok {
+		given := false
+		flag.Visit(func(f *flag.Flag) { given = given || f.Name == "token" })
+		if !given {
+			flag.Set("token", value)
+		}
+	}
+	if err := config.ApplyEnv(flag.CommandLine); err != nil {
+		return err
+	}
+	if *configFile == "" {
+		return nil
+	}
+	file, err := config.Load(*configFile)
+	if err != nil {
+		return err
+	}
+	return config.ApplyFile(flag.CommandLine, file.CtlFlags())
+}
+
+// discoverServers looks up the addresses of the servers with -discover.
+func discoverServers() []string {
+	source, err := discovery.Parse(*discover, grpc.WithTransportCredentials(transportCreds()))
+	if err != nil {
+		fmt.Printf("%v \n", err)
+		os.Exit(2)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	members, err := source.Members(ctx)
+	if err != nil {
+		fmt.Printf("could not find the servers: %v \n", err)
+		os.Exit(1)
+	}
+	var found []string
+	for _, m := range members {
+		found = append(found, m.Addr)
+	}
+	return found
+}
+
+// connect dials every server without waiting, so servers that are down just fail their calls.
+func connect(addrs []string) []gRPC.AuctionAdminClient {
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(transportCreds()),
+	}
+	var clients []gRPC.AuctionAdminClient
+	for _, addr := range addrs {
+		conn, err := grpc.Dial(addr, opts...)
+		if err != nil {
+			fmt.Printf("Fail to Dial %s: %v \n", addr, err)
+			continue
+		}
+		clients = append(clients, gRPC.NewAuctionAdminClient(conn))
+	}
+	return clients
+}
+
+// transportCreds are TLS with -tlsCA, or none.
+func transportCreds() credentials.TransportCredentials {
+	tlsConfig, err := config.ClientTLS(*tlsCert, *tlsKey, *tlsCA)
+	if err != nil {
+		fmt.Printf("%v \n", err)
+		os.Exit(2)
+	}
+	if tlsConfig != nil {
+		return credentials.NewTLS(tlsConfig)
+	}
+	return insecure.NewCredentials()
+}
+
+// withToken returns a context that carries the admin token and times out after -timeout.
+func withToken() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	return metadata.AppendToOutgoingContext(ctx, "admin-token", *token), cancel
+}
+
+// onLeader tries the call on every server until the leader takes it.
+func onLeader(clients []gRPC.AuctionAdminClient, call func(context.Context, gRPC.AuctionAdminClient) (*gRPC.AdminReply, error)) error {
+	var lastErr error
+	for _, c := range clients {
+		ctx, cancel := withToken()
+		reply, err := call(ctx, c)
+		cancel()
+		if err == nil {
+			fmt.Printf("%s (audit log record %d) \n", reply.Message, reply.Seq)
+			return nil
+		}
+		lastErr = err
+		// only try the next server if this one is not the leader or is down
+		if code := status.Code(err); code != codes.Unavailable && code != codes.DeadlineExceeded {
+			return err
+		}
+	}
+	return fmt.Errorf("no leader took the call: %v", lastErr)
+}
+
+// parseEndTime reads either a time of day today (HH:MM:SS) or a duration from now (+10m).
+func parseEndTime(s string) (time.Time, error) {
+	if strings.HasPrefix(s, "+") {
+		d, err := time.ParseDuration(s[1:])
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Now().Add(d), nil
+	}
+	return time.ParseInLocation(time.DateTime, time.Now().Format(time.DateOnly)+" "+s, time.Local)
+}
+
+func printState(clients []gRPC.AuctionAdminClient) error {
+	answered := false
+	for i, c := range clients {
+		ctx, cancel := withToken()
+		dump, err := c.DumpState(ctx, &gRPC.Void{})
+		cancel()
+		if err != nil {
+			fmt.Printf("server at %s: %v \n", addrs[i], err)
+			continue
+		}
+		answered = true
+		fmt.Printf("server %d: term %d, leader %d, committed %d of %d records \n", dump.ServerID, dump.Term, dump.LeaderID, dump.CommitSeq, dump.LastSeq)
+		fmt.Printf("  ends at %s, over: %v, cancelled: %v, banned: %v \n", time.Unix(dump.EndTime, 0).Format(time.DateTime), dump.AuctionOver, dump.Cancelled, dump.Banned)
+		for _, b := range dump.Bids {
+			fmt.Printf("  %d %s: %v at %s \n", b.ClientID, b.ClientName, b.Amount, time.Unix(0, b.Time).Format(time.DateTime))
+		}
+		if st := dump.Settlement; st != nil {
+			fmt.Printf("  settlement: %s, buyer %d %s, price %v, pay by %s \n", st.State, st.ClientID, st.ClientName, st.Price, payBy(st.PayBy))
+		}
+		for _, b := range dump.Budgets {
+			fmt.Printf("  %d can spend %v \n", b.ClientID, b.Budget)
+		}
+	}
+	if !answered {
+		return fmt.Errorf("no server answered")
+	}
+	return nil
+}
+
+// payBy is the deadline of a settlement, which can be 0 for none.
+func payBy(unix int64) string {
+	if unix == 0 {
+		return "no deadline"
+	}
+	return time.Unix(unix, 0).Format(time.DateTime)
+}
+
+// replay gives the events from the first server that answers to apply. Every replica has the
+// committed events, so it does not have to be the leader.
+func replay(clients []gRPC.AuctionAdminClient, req *gRPC.ReplayRequest, apply func(events.Event)) error {
+	var lastErr error
+	for _, c := range clients {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		if req.Follow {
+			// a replay that follows runs until it is stopped
+			cancel()
+			ctx, cancel = context.WithCancel(context.Background())
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, "admin-token", *token)
+		stream, err := c.ReplayEvents(ctx, req)
+		for err == nil {
+			var e *gRPC.DomainEvent
+			e, err = stream.Recv()
+			if err == nil {
+				apply(events.FromProto(e))
+			}
+		}
+		cancel()
+		if err == io.EOF {
+			return nil
+		}
+		lastErr = err
+		// a server that is down or shutting down is skipped, anything else is the answer
+		if code := status.Code(err); code != codes.Unavailable && code != codes.DeadlineExceeded {
+			return err
+		}
+	}
+	return fmt.Errorf("no server answered: %v", lastErr)
+}
+
+func printEvent(e events.Event) {
+	line := fmt.Sprintf("%d %s %s", e.Seq, e.Time.Format(time.DateTime), e.Type)
+	switch e.Type {
+	case events.AuctionCreated, events.AuctionExtended:
+		line += " ends at " + e.EndTime.Format(time.DateTime)
+	case events.AuctionClosed, events.AuctionCancelled:
+	default:
+		line += fmt.Sprintf(" %d %s %v", e.ClientID, e.ClientName, e.Amount)
+	}
+	if e.Reason != "" {
+		line += ": " + e.Reason
+	}
+	fmt.Printf("%s \n", line)
+}
+
+func printReplicas(clients []gRPC.AuctionAdminClient) error {
+	var lastErr error
+	for _, c := range clients {
+		ctx, cancel := withToken()
+		list, err := c.ListReplicas(ctx, &gRPC.Void{})
+		cancel()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, r := range list.Replicas {
+			state := "down"
+			if r.Alive {
+				state = "up"
+			}
+			if r.Leader {
+				state += ", leader"
+			}
+			if !r.Member {
+				state += ", not a member"
+			}
+			fmt.Printf("server %d at %s: %s, term %d, %d records \n", r.ServerID, r.Address, state, r.Term, r.LastSeq)
+		}
+		return nil
+	}
+	return fmt.Errorf("no server answered: %v", lastErr)
+}
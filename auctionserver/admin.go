@@ -0,0 +1,212 @@
+package auctionserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+	Auction "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The admin service lets operators manage a running auction with auctionctl.
+// Every change goes through the leader and is written to the audit log like a bid,
+// so the other replicas get it too and it can be checked afterwards with verify.
+
+// checkAdmin makes sure the caller sent the admin token.
+func (s *RMserver) checkAdmin(ctx context.Context) error {
+	if s.cfg.AdminToken == "" {
+		return status.Error(codes.PermissionDenied, "the admin service is turned off on this server (start it with -adminToken)")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get("admin-token")
+	if len(tokens) == 0 || tokens[0] == "" {
+		return status.Error(codes.Unauthenticated, "missing admin token")
+	}
+	if subtle.ConstantTimeCompare([]byte(tokens[0]), []byte(s.cfg.AdminToken)) != 1 {
+		return status.Error(codes.PermissionDenied, "wrong admin token")
+	}
+	return nil
+}
+
+// lockAdmin checks the token and then takes s.commitMutex for an admin call, like lockCommit does
+// for a bid. The token is checked first, so a caller without it learns nothing about the auction
+// and does not wait behind a commit.
+func (s *RMserver) lockAdmin(ctx context.Context) error {
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+	return s.lockCommit(ctx)
+}
+
+// adminCommit checks that we lead and that the auction is still running, and then commits the record.
+// The caller must have taken s.commitMutex with lockAdmin.
+func (s *RMserver) adminCommit(ctx context.Context, r audit.Record) (*Auction.AdminReply, error) {
+	s.mutex.Lock()
+	leading := s.isLeader && s.ready
+	leader := s.leaderID
+	over := s.auction.Over
+	ends := s.auction.EndTime
+	s.mutex.Unlock()
+
+	if !leading {
+		return nil, status.Errorf(codes.Unavailable, "server %d is not the leader, the leader is server %d", s.Id, leader)
+	}
+	if over {
+		return nil, status.Error(codes.FailedPrecondition, "the auction is already over")
+	}
+	// the close may not be committed yet, but an extension must not bring the auction back
+	if time.Now().After(ends) {
+		return nil, status.Errorf(codes.FailedPrecondition, "the auction ended at %s", ends.Format(time.DateTime))
+	}
+
+	appended, err := s.commit(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	s.logFor(ctx).Info("admin command committed", "kind", r.Kind, "detail", r.Detail, "seq", appended.Seq)
+	return &Auction.AdminReply{Message: "done", Seq: appended.Seq}, nil
+}
+
+func (s *RMserver) CloseNow(ctx context.Context, msg *Auction.AdminRequest) (*Auction.AdminReply, error) {
+	if err := s.lockAdmin(ctx); err != nil {
+		return nil, err
+	}
+	defer s.commitMutex.Unlock()
+
+	reply, err := s.adminCommit(ctx, audit.Record{Kind: audit.KindClose, Detail: msg.Reason})
+	if err != nil {
+		return nil, err
+	}
+	reply.Message = "The auction is closed"
+	return reply, nil
+}
+
+func (s *RMserver) ExtendEndTime(ctx context.Context, msg *Auction.ExtendRequest) (*Auction.AdminReply, error) {
+	if err := s.lockAdmin(ctx); err != nil {
+		return nil, err
+	}
+	defer s.commitMutex.Unlock()
+
+	newEnd := time.Unix(msg.EndTime, 0)
+	s.mutex.Lock()
+	ends := s.auction.EndTime
+	s.mutex.Unlock()
+	if !newEnd.After(ends) {
+		return nil, status.Errorf(codes.InvalidArgument, "the new end time %s is not after the current end time %s", newEnd.Format(time.DateTime), ends.Format(time.DateTime))
+	}
+
+	reply, err := s.adminCommit(ctx, audit.Record{Kind: audit.KindExtend, Detail: newEnd.Format(time.RFC3339)})
+	if err != nil {
+		return nil, err
+	}
+	reply.Message = "The auction now ends at " + newEnd.Format(time.DateTime)
+	return reply, nil
+}
+
+func (s *RMserver) CancelAuction(ctx context.Context, msg *Auction.AdminRequest) (*Auction.AdminReply, error) {
+	if err := s.lockAdmin(ctx); err != nil {
+		return nil, err
+	}
+	defer s.commitMutex.Unlock()
+
+	reply, err := s.adminCommit(ctx, audit.Record{Kind: audit.KindCancel, Detail: msg.Reason})
+	if err != nil {
+		return nil, err
+	}
+	reply.Message = "The auction is cancelled"
+	return reply, nil
+}
+
+func (s *RMserver) BanBidder(ctx context.Context, msg *Auction.BanRequest) (*Auction.AdminReply, error) {
+	if err := s.lockAdmin(ctx); err != nil {
+		return nil, err
+	}
+	defer s.commitMutex.Unlock()
+
+	s.mutex.Lock()
+	name := s.auction.Names[msg.ClientID]
+	s.mutex.Unlock()
+
+	reply, err := s.adminCommit(ctx, audit.Record{Kind: audit.KindBan, ClientID: msg.ClientID, ClientName: name, Detail: msg.Reason})
+	if err != nil {
+		return nil, err
+	}
+	reply.Message = "Client " + fmt.Sprint(msg.ClientID) + " is banned and their bid no longer counts"
+	return reply, nil
+}
+
+// DumpState returns the auction state as this replica sees it.
+func (s *RMserver) DumpState(ctx context.Context, msg *Auction.Void) (*Auction.StateDump, error) {
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dump := &Auction.StateDump{
+		ServerID:    int32(s.Id),
+		Term:        s.term,
+		LeaderID:    int32(s.leaderID),
+		CommitSeq:   s.commitSeq,
+		LastSeq:     s.log.Len(),
+		EndTime:     s.auction.EndTime.Unix(),
+		AuctionOver: s.auction.Over,
+		Cancelled:   s.auction.Cancelled,
+	}
+	for id, bid := range s.auction.Bids {
+		dump.Bids = append(dump.Bids, &Auction.BidState{ClientID: id, ClientName: s.auction.Names[id], Amount: bid.Amount, Time: bid.Time.UnixNano()})
+	}
+	sort.Slice(dump.Bids, func(i, j int) bool { return dump.Bids[i].Amount > dump.Bids[j].Amount })
+	for id := range s.auction.Banned {
+		dump.Banned = append(dump.Banned, id)
+	}
+	sort.Slice(dump.Banned, func(i, j int) bool { return dump.Banned[i] < dump.Banned[j] })
+	dump.Settlement = s.settlementState()
+	dump.Budgets = s.budgetStates()
+	sort.Slice(dump.Budgets, func(i, j int) bool { return dump.Budgets[i].ClientID < dump.Budgets[j].ClientID })
+	return dump, nil
+}
+
+// ListReplicas returns every replica as this replica sees it, including itself and the replicas
+// that are being added or removed.
+func (s *RMserver) ListReplicas(ctx context.Context, msg *Auction.Void) (*Auction.ReplicaList, error) {
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	self := &Auction.ReplicaInfo{
+		ServerID: int32(s.Id),
+		Address:  s.ownAddr(),
+		Alive:    true,
+		Leader:   s.isLeader,
+		Term:     s.term,
+		LastSeq:  s.log.Len(),
+		Member:   s.isMember(s.Id),
+	}
+	list := &Auction.ReplicaList{Replicas: []*Auction.ReplicaInfo{self}}
+	for _, p := range s.peers {
+		info := &Auction.ReplicaInfo{
+			ServerID: int32(p.id),
+			Address:  p.addr,
+			Alive:    time.Since(p.lastSeen) < peerTimeout,
+			Leader:   p.id == s.leaderID,
+			Member:   s.isMember(p.id),
+		}
+		if p.info != nil {
+			info.Term = p.info.Term
+			info.LastSeq = p.info.LastSeq
+		}
+		list.Replicas = append(list.Replicas, info)
+	}
+	sort.Slice(list.Replicas, func(i, j int) bool { return list.Replicas[i].ServerID < list.Replicas[j].ServerID })
+	return list, nil
+}
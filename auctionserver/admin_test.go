@@ -0,0 +1,43 @@
+package auctionserver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNoExtensionAfterTheEnd(t *testing.T) {
+	end := time.Now().Add(500 * time.Millisecond)
+	c := auctiontest.NewCluster(t, 1, func(id int, cfg *auctionserver.Config) {
+		cfg.EndTime = end
+		cfg.AdminToken = "secret"
+	})
+	leader := c.WaitForLeader()
+	admin, service, ctx := adminConn(t, c, leader)
+
+	// right after the end time, before the leader got to commit the close
+	time.Sleep(time.Until(end) + 5*time.Millisecond)
+	_, err := admin.ExtendEndTime(ctx, &gRPC.ExtendRequest{EndTime: time.Now().Add(time.Hour).Unix()})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("extending an auction that has ended: %v, want FailedPrecondition", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		outcome, err := service.Result(context.Background(), &gRPC.Void{})
+		if err == nil && outcome.BidDone {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the auction did not close: %v, %v", outcome, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
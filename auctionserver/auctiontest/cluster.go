@@ -0,0 +1,250 @@
+// Package auctiontest runs a whole cluster of auction servers inside one process, for tests.
+// The replicas talk to each other over in-memory bufconn listeners, so no ports are used
+// and the audit logs are kept in a temporary directory.
+//
+//	func TestBid(t *testing.T) {
+//		c := auctiontest.NewCluster(t, 3)
+//		c.WaitForLeader()
+//		ack, err := c.Client(1, "alice").Bid(context.Background(), 100)
+//		...
+//	}
+package auctiontest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionclient"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufSize = 1024 * 1024
+
+// leaderTimeout is how long WaitForLeader waits before failing the test.
+const leaderTimeout = 10 * time.Second
+
+// Cluster is a set of replicas started by NewCluster. Everything is stopped when the test ends.
+// The replicas can be stopped and restarted from other goroutines while the test runs.
+type Cluster struct {
+	t       testing.TB // nil when started with Start
+	Addrs   []string   // the names the replicas are dialled by, "replica0", "replica1", ...
+	configs []auctionserver.Config
+
+	mutex     sync.Mutex
+	servers   []*auctionserver.RMserver // nil while a replica is stopped
+	listeners map[string]*bufconn.Listener
+}
+
+// NewCluster starts n replicas. The auction ends in an hour unless an option changes it.
+// Each option is called with the id and config of every replica before it is started.
+func NewCluster(t testing.TB, n int, opts ...func(id int, cfg *auctionserver.Config)) *Cluster {
+	t.Helper()
+	c, err := Start(t.TempDir(), n, opts...)
+	if err != nil {
+		t.Fatalf("auctiontest: %v", err)
+	}
+	c.t = t
+	t.Cleanup(c.StopAll)
+	return c
+}
+
+// Start is NewCluster for programs that are not tests, e.g. the chaos command.
+// The audit logs are kept in dir. Without a test to fail, the helpers panic when something goes wrong,
+// and the caller has to call StopAll.
+func Start(dir string, n int, opts ...func(id int, cfg *auctionserver.Config)) (*Cluster, error) {
+	c := &Cluster{listeners: make(map[string]*bufconn.Listener)}
+	for i := 0; i < n; i++ {
+		c.Addrs = append(c.Addrs, fmt.Sprintf("replica%d", i))
+	}
+
+	end := time.Now().Add(time.Hour)
+	for i := 0; i < n; i++ {
+		cfg := auctionserver.Config{
+			ID:            i,
+			Peers:         c.Addrs,
+			ListenAddr:    c.Addrs[i],
+			Dialer:        c.dial,
+			EndTime:       end,
+			RetractWindow: 30 * time.Second,
+			RetractCutoff: time.Minute,
+			ClusterToken:  "auctiontest",
+			AuditLogPath:  filepath.Join(dir, fmt.Sprintf("audit_server%d.log", i)),
+		}
+		for _, opt := range opts {
+			opt(i, &cfg)
+		}
+		c.configs = append(c.configs, cfg)
+		c.servers = append(c.servers, nil)
+	}
+
+	for i := range c.configs {
+		if err := c.start(i); err != nil {
+			c.StopAll()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// WithNetwork sends everything the replicas send to each other through the simulated network.
+func WithNetwork(n *Network) func(id int, cfg *auctionserver.Config) {
+	return func(id int, cfg *auctionserver.Config) {
+		cfg.DialOptions = append(cfg.DialOptions, n.DialOptions(cfg.ListenAddr)...)
+	}
+}
+
+// fatalf fails the test, or panics when there is no test.
+func (c *Cluster) fatalf(format string, args ...interface{}) {
+	if c.t != nil {
+		c.t.Helper()
+		c.t.Fatalf(format, args...)
+	}
+	panic(fmt.Sprintf(format, args...))
+}
+
+// dial connects to a replica by its name. A replica that is stopped refuses the connection.
+func (c *Cluster) dial(ctx context.Context, addr string) (net.Conn, error) {
+	c.mutex.Lock()
+	lis := c.listeners[addr]
+	c.mutex.Unlock()
+	if lis == nil {
+		return nil, fmt.Errorf("auctiontest: no replica called %s", addr)
+	}
+	return lis.DialContext(ctx)
+}
+
+// DialOptions are what a gRPC client needs to reach the replicas, e.g. for grpc.Dial(c.Addrs[0], c.DialOptions()...).
+func (c *Cluster) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(c.dial),
+	}
+}
+
+// Client returns an auctionclient connected to every replica, opts are added to the dial options.
+// It is closed when the test ends, without a test the caller has to close it.
+func (c *Cluster) Client(clientID int32, name string, opts ...grpc.DialOption) *auctionclient.Client {
+	client, err := auctionclient.New(auctionclient.Config{
+		Servers:     c.Addrs,
+		ClientID:    clientID,
+		Name:        name,
+		DialOptions: append(c.DialOptions(), opts...),
+		RetryDelay:  100 * time.Millisecond,
+	})
+	if err != nil {
+		c.fatalf("auctiontest: failed to make a client: %v", err)
+	}
+	if c.t != nil {
+		c.t.Cleanup(func() { client.Close() })
+	}
+	return client
+}
+
+// Server returns a replica, or nil if it is stopped.
+func (c *Cluster) Server(id int) *auctionserver.RMserver {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.servers[id]
+}
+
+// Leader returns the id of the replica that leads right now, or -1 if none does.
+func (c *Cluster) Leader() int {
+	for i := range c.Addrs {
+		if s := c.Server(i); s != nil && s.Leading() {
+			return i
+		}
+	}
+	return -1
+}
+
+// WaitForLeader waits until a replica leads and returns its id. It fails the test if none does in time.
+func (c *Cluster) WaitForLeader() int {
+	if c.t != nil {
+		c.t.Helper()
+	}
+	deadline := time.Now().Add(leaderTimeout)
+	for time.Now().Before(deadline) {
+		if id := c.Leader(); id >= 0 {
+			return id
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.fatalf("auctiontest: no leader after %v", leaderTimeout)
+	return -1
+}
+
+// Stop stops one replica as if it crashed. Its audit log is kept, so Restart brings it back.
+func (c *Cluster) Stop(id int) {
+	c.mutex.Lock()
+	delete(c.listeners, c.Addrs[id])
+	s := c.servers[id]
+	c.servers[id] = nil
+	c.mutex.Unlock()
+	if s != nil {
+		s.Stop()
+	}
+}
+
+// Shutdown stops one replica gracefully, the way SIGTERM does: a leader hands off first and the
+// running calls are waited for until ctx is done. Restart brings it back.
+func (c *Cluster) Shutdown(ctx context.Context, id int) error {
+	c.mutex.Lock()
+	s := c.servers[id]
+	c.mutex.Unlock()
+	if s == nil {
+		return nil
+	}
+	err := s.Shutdown(ctx)
+	c.mutex.Lock()
+	delete(c.listeners, c.Addrs[id])
+	if c.servers[id] == s {
+		c.servers[id] = nil
+	}
+	c.mutex.Unlock()
+	return err
+}
+
+// Restart starts a replica again from its audit log. It is stopped first if it is running.
+func (c *Cluster) Restart(id int) {
+	if c.t != nil {
+		c.t.Helper()
+	}
+	c.Stop(id)
+	if err := c.start(id); err != nil {
+		c.fatalf("auctiontest: %v", err)
+	}
+}
+
+func (c *Cluster) start(id int) error {
+	lis := bufconn.Listen(bufSize)
+	cfg := c.configs[id]
+	cfg.Listener = lis
+	s, err := auctionserver.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to make replica %d: %v", id, err)
+	}
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("failed to start replica %d: %v", id, err)
+	}
+	c.mutex.Lock()
+	c.listeners[c.Addrs[id]] = lis
+	c.servers[id] = s
+	c.mutex.Unlock()
+	return nil
+}
+
+// StopAll stops every replica.
+func (c *Cluster) StopAll() {
+	for i := range c.Addrs {
+		c.Stop(i)
+	}
+}
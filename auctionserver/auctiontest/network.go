@@ -0,0 +1,212 @@
+package auctiontest
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Faults are what the simulated network does to the requests. The chances are between 0 and 1.
+type Faults struct {
+	Drop      float64       // the request is lost on the way
+	DropReply float64       // the request is handled but the reply is lost
+	Duplicate float64       // the request is delivered a second time a little later
+	Reorder   float64       // the request is held back so requests sent after it overtake it
+	Delay     time.Duration // every request waits up to this long before it is sent
+}
+
+// Stats counts what the network has done so far.
+type Stats struct {
+	Sent, Dropped, DroppedReplies, Duplicated, Reordered, Refused int
+}
+
+// Network is a simulated network between the replicas and the clients. It sits in front of the
+// real connections as a gRPC interceptor, so every unary call can be dropped, delayed, duplicated
+// or reordered, and the nodes can be partitioned from each other.
+//
+// A lost request or reply shows up as Unavailable straight away, as if the connection broke, so
+// callers without a deadline don't hang. Streams (Watch and the health checks) are only refused
+// when they are opened across a partition, what is sent on them is not touched.
+//
+// Every link between two nodes draws its faults from its own random source seeded from the seed,
+// so the n-th request on a link always gets the same faults. Which request is the n-th still
+// depends on how the goroutines are scheduled, so a run can be repeated with the same faults but
+// not always with the same interleaving.
+type Network struct {
+	seed int64
+
+	mutex  sync.Mutex
+	faults Faults
+	links  map[string]*rand.Rand
+	groups map[string]int // the partition each node is in, see Partition
+	stats  Stats
+}
+
+// NewNetwork makes a network that injects faults from seed.
+func NewNetwork(seed int64, faults Faults) *Network {
+	return &Network{seed: seed, faults: faults, links: make(map[string]*rand.Rand)}
+}
+
+// SetFaults changes the faults from now on.
+func (n *Network) SetFaults(faults Faults) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.faults = faults
+}
+
+// Partition splits the network, only nodes in the same group can talk to each other.
+// The nodes that are not in any group are put in a group of their own.
+func (n *Network) Partition(groups ...[]string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, node := range group {
+			n.groups[node] = i + 1
+		}
+	}
+}
+
+// Isolate cuts one node off from all the others.
+func (n *Network) Isolate(node string) {
+	n.Partition([]string{node})
+}
+
+// Heal removes the partitions.
+func (n *Network) Heal() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.groups = nil
+}
+
+// Stats returns what the network has done so far.
+func (n *Network) Stats() Stats {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.stats
+}
+
+// DialOptions make a connection from the node called from go through the network.
+func (n *Network) DialOptions(from string) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(n.unary(from)),
+		grpc.WithChainStreamInterceptor(n.stream(from)),
+	}
+}
+
+// fate is what happens to one request.
+type fate struct {
+	refused   bool
+	drop      bool
+	dropReply bool
+	duplicate bool
+	delay     time.Duration
+	dupDelay  time.Duration
+}
+
+// decide draws the fate of the next request from one node to another.
+func (n *Network) decide(from, to string) fate {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.groups[from] != n.groups[to] {
+		n.stats.Refused++
+		return fate{refused: true}
+	}
+
+	link := from + "->" + to
+	r := n.links[link]
+	if r == nil {
+		seed := n.seed
+		for _, c := range link {
+			seed = seed*31 + int64(c)
+		}
+		r = rand.New(rand.NewSource(seed))
+		n.links[link] = r
+	}
+
+	// always draw the same number of values, so changing the faults does not shift what comes after
+	drop, dropReply, duplicate, reorder := r.Float64(), r.Float64(), r.Float64(), r.Float64()
+	delay, dupDelay := r.Float64(), r.Float64()
+
+	f := fate{
+		drop:      drop < n.faults.Drop,
+		dropReply: dropReply < n.faults.DropReply,
+		duplicate: duplicate < n.faults.Duplicate,
+		delay:     time.Duration(delay * float64(n.faults.Delay)),
+		dupDelay:  time.Duration(dupDelay*float64(n.faults.Delay)) + time.Millisecond,
+	}
+	if reorder < n.faults.Reorder {
+		// held back longer than any other request can be delayed
+		f.delay += n.faults.Delay + 10*time.Millisecond
+		n.stats.Reordered++
+	}
+	n.stats.Sent++
+	if f.drop {
+		n.stats.Dropped++
+	} else if f.dropReply {
+		n.stats.DroppedReplies++
+	}
+	if f.duplicate && !f.drop {
+		n.stats.Duplicated++
+	}
+	return f
+}
+
+func (n *Network) unary(from string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		to := cc.Target()
+		f := n.decide(from, to)
+		if f.refused {
+			return status.Errorf(codes.Unavailable, "simulated network: %s is partitioned from %s", from, to)
+		}
+		if f.delay > 0 {
+			select {
+			case <-ctx.Done():
+				return status.FromContextError(ctx.Err()).Err()
+			case <-time.After(f.delay):
+			}
+		}
+		if f.drop {
+			return status.Errorf(codes.Unavailable, "simulated network: request from %s to %s was lost", from, to)
+		}
+
+		if f.duplicate {
+			// the copy is sent on its own, the caller only sees the reply to the first one
+			dupReq := proto.Clone(req.(proto.Message))
+			dupReply := proto.Clone(reply.(proto.Message))
+			md, _ := metadata.FromOutgoingContext(ctx)
+			go func() {
+				time.Sleep(f.dupDelay)
+				ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), md), time.Second)
+				defer cancel()
+				invoker(ctx, method, dupReq, dupReply, cc, opts...)
+			}()
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil && f.dropReply {
+			return status.Errorf(codes.Unavailable, "simulated network: reply from %s to %s was lost", to, from)
+		}
+		return err
+	}
+}
+
+func (n *Network) stream(from string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		n.mutex.Lock()
+		refused := n.groups[from] != n.groups[cc.Target()]
+		n.mutex.Unlock()
+		if refused {
+			return nil, status.Errorf(codes.Unavailable, "simulated network: %s is partitioned from %s", from, cc.Target())
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,61 @@
+package auctionserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+	Auction "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// An admin can give a bidder a budget, the most they can spend. A bid is turned down when it is
+// more than the budget. The cluster runs one auction and a bidder has at most one bid in it, which
+// a new bid of theirs replaces, so the new bid is all they could ever have to pay. Nothing else
+// has to be added to it.
+// The budgets are folded from the BudgetSet events with the rest of the auction, so every replica has the same.
+
+func (s *RMserver) SetBudget(ctx context.Context, msg *Auction.BudgetRequest) (*Auction.AdminReply, error) {
+	if err := s.lockAdmin(ctx); err != nil {
+		return nil, err
+	}
+	defer s.commitMutex.Unlock()
+	if msg.Budget < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "the budget can't be negative, use 0 to take it away")
+	}
+
+	s.mutex.Lock()
+	name := s.auction.Names[msg.ClientID]
+	s.mutex.Unlock()
+
+	reply, err := s.adminCommit(ctx, audit.Record{Kind: audit.KindBudget, ClientID: msg.ClientID, ClientName: name, Amount: msg.Budget, Detail: msg.Reason})
+	if err != nil {
+		return nil, err
+	}
+	if msg.Budget == 0 {
+		reply.Message = "Client " + fmt.Sprint(msg.ClientID) + " no longer has a budget"
+	} else {
+		// a budget under the bid the bidder already has only stops their next bids
+		reply.Message = "Client " + fmt.Sprint(msg.ClientID) + " can now spend " + fmt.Sprint(msg.Budget)
+	}
+	return reply, nil
+}
+
+// overBudget says if a bid of amount is more than the bidder's budget, and returns the budget.
+// The caller must hold s.mutex.
+func (s *RMserver) overBudget(clientID int32, amount float32) (bool, float32) {
+	budget, ok := s.auction.Budgets[clientID]
+	return ok && amount > budget, budget
+}
+
+// budgetStates returns the budget of every bidder that has one, for DumpState.
+// The caller must hold s.mutex.
+func (s *RMserver) budgetStates() []*Auction.BudgetState {
+	var states []*Auction.BudgetState
+	for id, budget := range s.auction.Budgets {
+		states = append(states, &Auction.BudgetState{ClientID: id, Budget: budget})
+	}
+	return states
+}
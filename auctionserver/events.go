@@ -0,0 +1,145 @@
+package auctionserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+	"github.com/Alex-itu/A_Distributed_Auction_System/events"
+	Auction "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The audit log is the event store of the auction. apply folds the event of every committed record
+// into s.auction and keeps it in s.events, so ReplayEvents can send the events to whoever builds a
+// read model from them, without going near Bid.
+//
+// A bid that is turned down by the rules of the auction (too low, banned, over budget, over) is a
+// BidRejected event, but it is not written to the audit log: it changes nothing, and a bidder that
+// keeps sending bids would make the log grow without end. The leader keeps the last maxRejections
+// of them in memory and ReplayEvents sends them between the committed events, so they are gone
+// after a change of leader or a restart and the other replicas never have them. The
+// auction_bids_rejected_total metric counts all of them.
+
+// maxRejections is how many BidRejected events the leader keeps for ReplayEvents.
+const maxRejections = 10000
+
+// createAuction writes the AuctionCreated event if no leader has yet. It is called by a new leader
+// once its config record is committed, so everything before it is applied.
+func (s *RMserver) createAuction(ctx context.Context) {
+	s.mutex.Lock()
+	created := s.auction.Created
+	ends := s.auction.EndTime
+	s.mutex.Unlock()
+	if created {
+		return
+	}
+	if _, err := s.commit(ctx, audit.Record{Kind: audit.KindCreated, Detail: ends.Format(time.RFC3339Nano)}); err != nil {
+		// the next leader tries again
+		s.logger.Warn("could not write the auction created event", "err", err)
+	}
+}
+
+// recordRejection keeps a BidRejected event for ReplayEvents. Its Seq is the last record that was
+// applied before it, so it is sent after that record's event.
+func (s *RMserver) recordRejection(msg *Auction.BidAmount, reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rejections = append(s.rejections, events.Event{Seq: s.appliedSeq, Type: events.BidRejected, Time: time.Now(),
+		ClientID: msg.ClientID, ClientName: msg.ClientName, Amount: msg.Amount, Reason: reason})
+	s.rejectionCount++
+	if len(s.rejections) > maxRejections {
+		s.rejections = s.rejections[1:]
+	}
+	for changed := range s.replays {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ReplayEvents sends the committed events that match the request, oldest first, with the rejections
+// this server has kept. With follow it then sends the new ones as they come, until the caller goes
+// away or the server shuts down.
+func (s *RMserver) ReplayEvents(msg *Auction.ReplayRequest, stream Auction.AuctionAdmin_ReplayEventsServer) error {
+	if err := s.checkAdmin(stream.Context()); err != nil {
+		return err
+	}
+	filter := events.Filter{FromSeq: msg.FromSeq}
+	if len(msg.Types) > 0 {
+		filter.Types = make(map[events.Type]bool)
+		for _, t := range msg.Types {
+			filter.Types[events.Type(t)] = true
+		}
+	}
+	if len(msg.ClientIDs) > 0 {
+		filter.ClientIDs = make(map[int32]bool)
+		for _, id := range msg.ClientIDs {
+			filter.ClientIDs[id] = true
+		}
+	}
+
+	changed := make(chan struct{}, 1)
+	if msg.Follow {
+		s.mutex.Lock()
+		s.watchers[changed] = true
+		s.replays[changed] = true
+		s.mutex.Unlock()
+		defer func() {
+			s.mutex.Lock()
+			delete(s.watchers, changed)
+			delete(s.replays, changed)
+			s.mutex.Unlock()
+		}()
+	}
+
+	next := 0                 // the first event in s.events that was not sent yet
+	nextRejection := int64(0) // the number of the first rejection that was not sent yet
+	for {
+		s.mutex.Lock()
+		// s.events only grows, so the events up to its length now can be read without the lock
+		pending := s.events[next:len(s.events):len(s.events)]
+		next = len(s.events)
+		// the rejections that were dropped to make room are gone
+		first := s.rejectionCount - int64(len(s.rejections))
+		rejections := append([]events.Event(nil), s.rejections[max(nextRejection-first, 0):]...)
+		nextRejection = s.rejectionCount
+		s.mutex.Unlock()
+
+		send := func(e events.Event) error {
+			if !filter.Match(e) {
+				return nil
+			}
+			return stream.Send(events.ToProto(e))
+		}
+		for _, e := range pending {
+			for len(rejections) > 0 && rejections[0].Seq < e.Seq {
+				if err := send(rejections[0]); err != nil {
+					return err
+				}
+				rejections = rejections[1:]
+			}
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+		for _, e := range rejections {
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+		if !msg.Follow {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return nil
+		case <-s.drain:
+			return status.Errorf(codes.Unavailable, "server %d is shutting down", s.Id)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package auctionserver_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	"github.com/Alex-itu/A_Distributed_Auction_System/events"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestReplayRejections(t *testing.T) {
+	c := auctiontest.NewCluster(t, 1, func(id int, cfg *auctionserver.Config) { cfg.AdminToken = "secret" })
+	leader := c.WaitForLeader()
+	alice := c.Client(1, "alice")
+	bob := c.Client(2, "bob")
+	ctx := context.Background()
+
+	replay := func() []events.Event {
+		t.Helper()
+		conn, err := grpc.Dial(c.Addrs[leader], c.DialOptions()...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		stream, err := gRPC.NewAuctionAdminClient(conn).ReplayEvents(metadata.AppendToOutgoingContext(ctx, "admin-token", "secret"), &gRPC.ReplayRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var replayed []events.Event
+		for {
+			pe, err := stream.Recv()
+			if err == io.EOF {
+				return replayed
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			replayed = append(replayed, events.FromProto(pe))
+		}
+	}
+
+	if ack, err := alice.Bid(ctx, 10); err != nil || !ack.Accepted {
+		t.Fatalf("alice's bid: %v, %v", ack, err)
+	}
+	before := replay()
+	for i := 0; i < 3; i++ {
+		if ack, err := bob.Bid(ctx, 5); err != nil || ack.Accepted {
+			t.Fatalf("bob's bid that is too low: %v, %v", ack, err)
+		}
+	}
+	after := replay()
+
+	// the rejections are replayed after the bid they lost to, and as no record was written for them
+	// they all come after the same record
+	if len(after) != len(before)+3 {
+		t.Fatalf("replayed %d events after the rejections, %d before, want 3 more", len(after), len(before))
+	}
+	placed := before[len(before)-1]
+	if placed.Type != events.BidPlaced || placed.ClientID != 1 {
+		t.Fatalf("the last event before the rejections is %+v, want alice's bid", placed)
+	}
+	for _, e := range after[len(before):] {
+		if e.Type != events.BidRejected || e.ClientID != 2 || e.Seq != placed.Seq {
+			t.Fatalf("replayed %+v, want a rejection of bob after record %d", e, placed.Seq)
+		}
+	}
+}
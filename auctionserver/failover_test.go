@@ -0,0 +1,62 @@
+package auctionserver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+)
+
+func TestFailover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the auction to close")
+	}
+	end := time.Now().Add(4 * time.Second)
+	c := auctiontest.NewCluster(t, 3, func(id int, cfg *auctionserver.Config) { cfg.EndTime = end })
+	leader := c.WaitForLeader()
+	alice, bob := c.Client(1, "alice"), c.Client(2, "bob")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mustBid := func(name string, bid func(context.Context, float32) (*gRPC.Ack, error), amount float32) {
+		t.Helper()
+		ack, err := bid(ctx, amount)
+		if err != nil || !ack.Accepted {
+			t.Fatalf("%s's bid of %v: %v, %v", name, amount, ack, err)
+		}
+	}
+	mustBid("alice", alice.Bid, 10)
+	mustBid("bob", bob.Bid, 20)
+
+	// the leader crashes, the others pick a new one and still have both bids
+	c.Stop(leader)
+	next := c.WaitForLeader()
+	if next == leader {
+		t.Fatalf("replica %d still leads after it was stopped", leader)
+	}
+	if outcome, err := alice.Result(ctx); err != nil || outcome.Amount != 20 || outcome.ClientName != "bob" {
+		t.Fatalf("the result after the failover: %v, %v, want bob with 20", outcome, err)
+	}
+	mustBid("alice", alice.Bid, 30)
+
+	// the old leader comes back as a follower and the auction closes with the bid made after the failover
+	c.Restart(leader)
+	time.Sleep(time.Until(end))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		outcome, err := bob.Result(ctx)
+		if err == nil && outcome.BidDone {
+			if outcome.Amount != 30 || outcome.ClientName != "alice" {
+				t.Fatalf("the auction closed with %v, want alice with 30", outcome)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the auction did not close: %v, %v", outcome, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,86 @@
+package auctionserver
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// The server reports its readiness through the standard gRPC health service (grpc.health.v1),
+// both for the whole server ("") and for the auction service. The client uses it to pick which
+// servers to talk to, and a load balancer can use it the same way.
+//
+// A replica is SERVING when it can reach a majority of the replicas and
+//   - it is the leader and has caught up, or
+//   - it follows a leader whose records it has taken recently.
+// A follower that is SERVING can still be a little behind the leader, so bids and results are
+// only answered by the leader (see readBarrier in replication.go). A follower can serve Watch.
+// Otherwise it is NOT_SERVING, e.g. while it is still catching up after a restart
+// or when it has lost contact with the quorum, and from the moment it starts shutting down.
+
+// healthServices are the service names the health status is reported for.
+var healthServices = []string{"", "proto.AuctionService"}
+
+// updateHealth works out the current health and tells the health service if it changed.
+func (s *RMserver) updateHealth() {
+	s.mutex.Lock()
+	hasQuorum := s.hasQuorum()
+	inSync := s.isLeader && s.ready || !s.isLeader && s.leaderID >= 0 && time.Since(s.lastInSync) < peerTimeout
+	serving := hasQuorum && inSync && !s.draining
+	changed := serving != s.serving
+	s.serving = serving
+	s.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	for _, service := range healthServices {
+		s.health.SetServingStatus(service, status)
+	}
+	s.logger.Info("health changed", "status", status.String(), "quorum", hasQuorum, "in_sync", inSync)
+}
+
+// healthService is the standard health service, except that the Watch streams end when the
+// replica shuts down. The clients keep them open for as long as they run, so otherwise they
+// would hold up grpcServer.GracefulStop until the drain timeout.
+type healthService struct {
+	*health.Server
+	drain <-chan struct{}
+}
+
+func (h healthService) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-h.drain:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err := h.Server.Watch(req, watchStream{stream, ctx})
+	select {
+	case <-h.drain:
+		return status.Error(codes.Unavailable, "the server is shutting down")
+	default:
+		return err
+	}
+}
+
+// watchStream is a health Watch stream with a context that is also cancelled by a shutdown.
+type watchStream struct {
+	healthpb.Health_WatchServer
+	ctx context.Context
+}
+
+func (w watchStream) Context() context.Context {
+	return w.ctx
+}
@@ -0,0 +1,76 @@
+package auctionserver
+
+import (
+	Auction "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Every bid carries an idempotency key that its client made for it and sends again with every retry.
+// The key only has to be unique for one client, so the server keeps the keys by client.
+//
+// The keys of the bids that were committed come from the audit log (see apply), so every replica
+// has them and they survive a change of leader and a restart. A retry of such a bid is told it was
+// accepted again. What a rejected bid was told is only kept by the leader that told it, for the last
+// maxRejectedAnswers rejections. A rejected bid changed nothing, so a new leader that gets its retry
+// can decide it again.
+
+// maxRejectedAnswers is how many answers to rejected bids the leader keeps for their retries.
+const maxRejectedAnswers = 10000
+
+// bidKey is one bid of one client.
+type bidKey struct {
+	client int32
+	key    string
+}
+
+// rejectedAnswer is what a rejected bid was told.
+type rejectedAnswer struct {
+	amount float32
+	ack    *Auction.Ack
+}
+
+// earlierAnswer returns the answer to an earlier try of the same bid, or nil if there was none.
+// A key that was used for a bid of another amount is an error. The caller must hold s.mutex.
+func (s *RMserver) earlierAnswer(msg *Auction.BidAmount) (*Auction.Ack, error) {
+	if msg.IdempotencyKey == "" {
+		return nil, nil
+	}
+	k := bidKey{msg.ClientID, msg.IdempotencyKey}
+	if amount, ok := s.bidKeys[k]; ok {
+		if amount != msg.Amount {
+			return nil, reusedKey(k, amount)
+		}
+		return s.acceptedAck(msg.ClientID), nil
+	}
+	if answer, ok := s.rejectedAnswers[k]; ok {
+		if answer.amount != msg.Amount {
+			return nil, reusedKey(k, answer.amount)
+		}
+		return answer.ack, nil
+	}
+	return nil, nil
+}
+
+func reusedKey(k bidKey, amount float32) error {
+	return status.Errorf(codes.InvalidArgument, "the idempotency key %q was already used for a bid of %v", k.key, amount)
+}
+
+// rememberRejection keeps what a rejected bid was told, for its retries.
+func (s *RMserver) rememberRejection(msg *Auction.BidAmount, ack *Auction.Ack) {
+	if msg.IdempotencyKey == "" {
+		return
+	}
+	k := bidKey{msg.ClientID, msg.IdempotencyKey}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.rejectedAnswers[k]; ok {
+		return
+	}
+	s.rejectedAnswers[k] = rejectedAnswer{amount: msg.Amount, ack: ack}
+	s.rejectedOrder = append(s.rejectedOrder, k)
+	if len(s.rejectedOrder) > maxRejectedAnswers {
+		delete(s.rejectedAnswers, s.rejectedOrder[0])
+		s.rejectedOrder = s.rejectedOrder[1:]
+	}
+}
@@ -0,0 +1,84 @@
+package auctionserver_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	"github.com/Alex-itu/A_Distributed_Auction_System/logging"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	c := auctiontest.NewCluster(t, 1)
+	server := c.Server(c.WaitForLeader())
+	ctx := context.Background()
+	bid := func(client int32, key string, amount float32) (*gRPC.Ack, error) {
+		return server.Bid(ctx, &gRPC.BidAmount{ClientID: client, ClientName: "bidder", Amount: amount, IdempotencyKey: key})
+	}
+	mustBid := func(client int32, key string, amount float32, accepted bool) *gRPC.Ack {
+		t.Helper()
+		ack, err := bid(client, key, amount)
+		if err != nil {
+			t.Fatalf("bid of %v with key %q: %v", amount, key, err)
+		}
+		if ack.Accepted != accepted {
+			t.Fatalf("bid of %v with key %q: accepted is %v, want %v (%s)", amount, key, ack.Accepted, accepted, ack.Message)
+		}
+		return ack
+	}
+
+	mustBid(1, "a", 10, true)
+	// the retry is answered the same and is not a second bid
+	mustBid(1, "a", 10, true)
+	// the key is only unique per client
+	mustBid(2, "a", 20, true)
+
+	// a rejected bid is answered the same on a retry, even when it would now be decided otherwise
+	first := mustBid(1, "b", 15, false)
+	mustBid(2, "c", 30, true)
+	if retry := mustBid(1, "b", 15, false); retry.Message != first.Message {
+		t.Fatalf("the retry was told %q, the first try %q", retry.Message, first.Message)
+	}
+
+	// a key that comes again with another amount is refused
+	for _, key := range []string{"a", "b"} {
+		if _, err := bid(1, key, 50); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("reusing key %q for another amount: %v, want InvalidArgument", key, err)
+		}
+	}
+
+	outcome, err := server.Result(ctx, &gRPC.Void{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome.Amount != 30 {
+		t.Fatalf("the highest bid is %v, want 30", outcome.Amount)
+	}
+}
+
+func TestBidsWithTheSameCtx(t *testing.T) {
+	c := auctiontest.NewCluster(t, 1)
+	c.WaitForLeader()
+	alice := c.Client(1, "alice")
+	defer alice.Close()
+
+	// two bids are two bids, even when the ctx and so the request id of the logs is the same
+	ctx := logging.WithRequestID(context.Background(), "same")
+	for _, amount := range []float32{10, 20} {
+		ack, err := alice.Bid(ctx, amount)
+		if err != nil || !ack.Accepted {
+			t.Fatalf("bid of %v: %v, %v", amount, ack, err)
+		}
+	}
+	outcome, err := alice.Result(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome.Amount != 20 {
+		t.Fatalf("the highest bid is %v, want 20", outcome.Amount)
+	}
+}
@@ -0,0 +1,415 @@
+package auctionserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+	Auction "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// How the replicas change:
+// The replicas of the cluster are written to the audit log as members records, with the whole
+// list in Detail. A replica uses the last members record in its log, also before it is committed,
+// and Config.Peers while its log has none. Only one replica is added or removed at a time, so the
+// old and the new majority always have a replica in common and there can't be two leaders.
+// A new replica first gets the whole log as a learner: the leader replicates to it, but it does not
+// vote and does not count for the majority. Once it has caught up, the leader commits the members
+// record that makes it one of the replicas.
+
+// catchUpTimeout is how long a new replica gets to copy the log before AddReplica gives up.
+const catchUpTimeout = 10 * time.Second
+
+// membership is the Detail of a members record.
+type membership struct {
+	Members []member `json:"members"`
+	Reason  string   `json:"reason,omitempty"`
+}
+
+type member struct {
+	ID      int    `json:"id"`
+	Address string `json:"address"`
+}
+
+func encodeMembers(members map[int]string, reason string) string {
+	m := membership{Reason: reason}
+	for id, addr := range members {
+		m.Members = append(m.Members, member{ID: id, Address: addr})
+	}
+	sort.Slice(m.Members, func(i, j int) bool { return m.Members[i].ID < m.Members[j].ID })
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
+func decodeMembers(detail string) (map[int]string, error) {
+	var m membership
+	if err := json.Unmarshal([]byte(detail), &m); err != nil {
+		return nil, err
+	}
+	members := make(map[int]string)
+	for _, mb := range m.Members {
+		members[mb.ID] = mb.Address
+	}
+	return members, nil
+}
+
+// initialMembers are the replicas before the log says otherwise. A replica that joins
+// knows of none, it waits for the leader to add it.
+func (s *RMserver) initialMembers() map[int]string {
+	members := make(map[int]string)
+	if s.cfg.Join {
+		return members
+	}
+	if len(s.cfg.Peers) == 0 {
+		members[s.Id] = s.ownAddr()
+		return members
+	}
+	for id, addr := range s.cfg.Peers {
+		members[id] = addr
+	}
+	return members
+}
+
+// ownAddr is the address the others reach this replica at, as far as it knows.
+func (s *RMserver) ownAddr() string {
+	if addr, ok := s.members[s.Id]; ok {
+		return addr
+	}
+	if s.cfg.AdvertiseAddr != "" {
+		return s.cfg.AdvertiseAddr
+	}
+	addr := s.cfg.ListenAddr
+	if addr == "" && s.cfg.Listener != nil {
+		addr = s.cfg.Listener.Addr().String()
+	}
+	// nobody can dial 0.0.0.0 or [::] from another machine, the host name is a better guess
+	if host, port, err := net.SplitHostPort(addr); err == nil && (host == "" || net.ParseIP(host).IsUnspecified()) {
+		if name, err := os.Hostname(); err == nil {
+			return net.JoinHostPort(name, port)
+		}
+	}
+	return addr
+}
+
+// loadMembers finds the last members record in the log and connects to the replicas in it.
+// The caller must hold s.mutex.
+func (s *RMserver) loadMembers() {
+	for seq := s.log.Len(); seq > 0; seq-- {
+		r, ok := s.log.Get(seq)
+		if !ok || r.Kind != audit.KindMembers {
+			continue
+		}
+		members, err := decodeMembers(r.Detail)
+		if err != nil {
+			s.logger.Warn("skipping a members record that can't be read", "seq", seq, "err", err)
+			continue
+		}
+		s.setMembers(members, seq)
+		return
+	}
+	s.setMembers(s.initialMembers(), 0)
+}
+
+// hasMembers reports if the log has a members record.
+func hasMembers(log *audit.Log) bool {
+	for _, r := range log.From(1) {
+		if r.Kind == audit.KindMembers {
+			return true
+		}
+	}
+	return false
+}
+
+// membersChanged loads the members again if the records from seq on could have changed them,
+// because a members record was added or the one in use was replaced. The caller must hold s.mutex.
+func (s *RMserver) membersChanged(from int64) {
+	if s.membersSeq >= from {
+		s.loadMembers()
+		return
+	}
+	for _, r := range s.log.From(from) {
+		if r.Kind == audit.KindMembers {
+			s.loadMembers()
+			return
+		}
+	}
+}
+
+// setMembers switches to a new list of replicas. The caller must hold s.mutex.
+func (s *RMserver) setMembers(members map[int]string, seq int64) {
+	if s.members != nil && !sameMembers(s.members, members) {
+		s.logger.Info("the replicas changed", "members", encodeMembers(members, ""), "seq", seq, "member", members[s.Id] != "")
+	}
+	s.members = members
+	s.membersSeq = seq
+	s.connectToPeers()
+}
+
+func sameMembers(a, b map[int]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, addr := range a {
+		if other, ok := b[id]; !ok || other != addr {
+			return false
+		}
+	}
+	return true
+}
+
+// isMember reports if the replica with the given id votes and counts for the majority.
+// The caller must hold s.mutex.
+func (s *RMserver) isMember(id int) bool {
+	_, ok := s.members[id]
+	return ok
+}
+
+// fixAddresses corrects the members when a replica advertises another address than the one the
+// cluster has for it, e.g. after it was restarted with a new -advertise, so the other replicas and
+// the clients move to the new address. It only runs on the leader and skips a round while a
+// commit is going on.
+func (s *RMserver) fixAddresses() {
+	if !s.commitMutex.TryLock() {
+		return
+	}
+	defer s.commitMutex.Unlock()
+	members, err := s.startChange()
+	if err != nil {
+		return
+	}
+
+	var changed []string
+	s.mutex.Lock()
+	if addr, ok := members[s.Id]; ok && s.cfg.AdvertiseAddr != "" && addr != s.cfg.AdvertiseAddr {
+		members[s.Id] = s.cfg.AdvertiseAddr
+		changed = append(changed, fmt.Sprintf("server %d is at %s", s.Id, s.cfg.AdvertiseAddr))
+	}
+	for _, p := range s.alivePeers() {
+		if p.info == nil || p.info.Address == "" {
+			continue
+		}
+		if addr, ok := members[p.id]; ok && addr != p.info.Address {
+			members[p.id] = p.info.Address
+			changed = append(changed, fmt.Sprintf("server %d is at %s", p.id, p.info.Address))
+		}
+	}
+	s.mutex.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+	if _, err := s.commitMembers(context.Background(), members, strings.Join(changed, ", ")); err != nil {
+		s.logger.Warn("could not update the addresses of the replicas", "err", err)
+	}
+}
+
+// Members returns the replicas of the cluster as this replica knows them,
+// so the clients can find the replicas that were added after they started.
+func (s *RMserver) Members(ctx context.Context, msg *Auction.Void) (*Auction.MemberList, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := &Auction.MemberList{LeaderID: int32(s.leaderID)}
+	for id, addr := range s.members {
+		list.Members = append(list.Members, &Auction.Member{ServerID: int32(id), Address: addr})
+	}
+	sort.Slice(list.Members, func(i, j int) bool { return list.Members[i].ServerID < list.Members[j].ServerID })
+	return list, nil
+}
+
+// AddReplica makes a new replica one of the replicas. The new replica has to be running
+// (started with -join) and is first caught up, so it does not hold up the commits once it counts.
+func (s *RMserver) AddReplica(ctx context.Context, msg *Auction.MembershipRequest) (*Auction.AdminReply, error) {
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	id, addr := int(msg.ServerID), msg.Address
+	if id < 0 || addr == "" {
+		return nil, status.Error(codes.InvalidArgument, "a new replica needs an id and an address")
+	}
+	members, err := s.startChange()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := members[id]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "server %d is already one of the replicas", id)
+	}
+	for other, a := range members {
+		if a == addr {
+			return nil, status.Errorf(codes.AlreadyExists, "%s is already the address of server %d", addr, other)
+		}
+	}
+
+	s.mutex.Lock()
+	// a learner is a replica that is being added or removed, one change has to be done before the next
+	for other := range s.learners {
+		s.mutex.Unlock()
+		return nil, status.Errorf(codes.FailedPrecondition, "server %d is being added or removed, try again when that is done", other)
+	}
+	s.learners[id] = addr
+	s.connectToPeers()
+	s.mutex.Unlock()
+	defer func() {
+		// once the members record is in the log the replica stays connected as one of the members
+		s.mutex.Lock()
+		delete(s.learners, id)
+		s.connectToPeers()
+		s.mutex.Unlock()
+	}()
+
+	// copy most of the log while the bids still go through, and only the rest once they are held up
+	s.logFor(ctx).Info("catching up a new replica", "server", id, "addr", addr)
+	if err := s.catchUpLearner(ctx, id); err != nil {
+		return nil, err
+	}
+
+	if err := s.lockCommit(ctx); err != nil {
+		return nil, err
+	}
+	defer s.commitMutex.Unlock()
+	members, err = s.startChange()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.catchUpLearner(ctx, id); err != nil {
+		return nil, err
+	}
+	members[id] = addr
+	reply, err := s.commitMembers(ctx, members, msg.Reason)
+	if err != nil {
+		return nil, err
+	}
+	reply.Message = fmt.Sprintf("Server %d at %s is one of the %d replicas now", id, addr, len(members))
+	return reply, nil
+}
+
+// RemoveReplica takes a replica out of the cluster. The removed replica keeps running, but it no
+// longer gets the records and does not vote. If the leader removes itself it steps down afterwards.
+func (s *RMserver) RemoveReplica(ctx context.Context, msg *Auction.MembershipRequest) (*Auction.AdminReply, error) {
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	id := int(msg.ServerID)
+
+	if err := s.lockCommit(ctx); err != nil {
+		return nil, err
+	}
+	defer s.commitMutex.Unlock()
+	members, err := s.startChange()
+	if err != nil {
+		return nil, err
+	}
+	addr, ok := members[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "server %d is not one of the replicas", id)
+	}
+	if len(members) == 1 {
+		return nil, status.Error(codes.FailedPrecondition, "the last replica can't be removed")
+	}
+	delete(members, id)
+
+	// the removed replica still gets the members record, so it knows to stop taking part
+	s.mutex.Lock()
+	if id != s.Id {
+		s.learners[id] = addr
+	}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.learners, id)
+		s.connectToPeers()
+		s.mutex.Unlock()
+	}()
+
+	reply, err := s.commitMembers(ctx, members, msg.Reason)
+	if err != nil {
+		return nil, err
+	}
+	if id == s.Id {
+		s.mutex.Lock()
+		s.stepDown("removed from the replicas")
+		s.mutex.Unlock()
+	}
+	reply.Message = fmt.Sprintf("Server %d is no longer one of the replicas, %d are left", id, len(members))
+	return reply, nil
+}
+
+// startChange checks that this replica can change the replicas now and returns a copy of them.
+// A change has to wait until everything in the leader's log is committed, so the change before
+// it (or the leader's config record) is in place on a majority.
+func (s *RMserver) startChange() (map[int]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.isLeader || !s.ready {
+		return nil, status.Errorf(codes.Unavailable, "server %d is not the leader, the leader is server %d", s.Id, s.leaderID)
+	}
+	if s.commitSeq < s.log.Len() {
+		return nil, status.Errorf(codes.FailedPrecondition, "server %d has records that are not committed yet, try again when the replicas have caught up", s.Id)
+	}
+	members := make(map[int]string, len(s.members))
+	for id, addr := range s.members {
+		members[id] = addr
+	}
+	return members, nil
+}
+
+// catchUpLearner sends the log to a new replica until it has every record the leader has.
+func (s *RMserver) catchUpLearner(ctx context.Context, id int) error {
+	deadline := time.Now().Add(catchUpTimeout)
+	for time.Now().Before(deadline) {
+		var learner *peer
+		for _, p := range s.peerList() {
+			if p.id == id {
+				learner = p
+			}
+		}
+		if learner == nil {
+			return status.Errorf(codes.Internal, "server %d is not connected", id)
+		}
+		s.mutex.Lock()
+		before := learner.matchSeq
+		s.mutex.Unlock()
+		s.sendAppend(ctx, learner)
+
+		s.mutex.Lock()
+		leading := s.isLeader && s.ready
+		done := learner.matchSeq >= s.log.Len()
+		progress := learner.matchSeq > before
+		s.mutex.Unlock()
+		if !leading {
+			return status.Errorf(codes.Unavailable, "server %d is no longer the leader", s.Id)
+		}
+		if done {
+			return nil
+		}
+		if progress {
+			// it took a page, send the next one right away
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-time.After(pingInterval):
+		}
+	}
+	return status.Errorf(codes.DeadlineExceeded, "server %d did not catch up within %v, is it running with -join?", id, catchUpTimeout)
+}
+
+// commitMembers commits the new list of replicas. The caller must hold s.commitMutex.
+func (s *RMserver) commitMembers(ctx context.Context, members map[int]string, reason string) (*Auction.AdminReply, error) {
+	appended, err := s.commit(ctx, audit.Record{Kind: audit.KindMembers, Detail: encodeMembers(members, reason)})
+	if err != nil {
+		return nil, err
+	}
+	s.logFor(ctx).Info("membership change committed", "members", appended.Detail, "seq", appended.Seq)
+	return &Auction.AdminReply{Message: "done", Seq: appended.Seq}, nil
+}
@@ -0,0 +1,193 @@
+package auctionserver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// joining makes the first n replicas the cluster, the others start with Join and wait to be added.
+func joining(n int) func(id int, cfg *auctionserver.Config) {
+	return func(id int, cfg *auctionserver.Config) {
+		cfg.Peers = cfg.Peers[:n]
+		cfg.Join = id >= n
+		cfg.AdminToken = "secret"
+	}
+}
+
+// adminConn connects to one replica, the calls on ctx carry the admin token.
+func adminConn(t *testing.T, c *auctiontest.Cluster, id int) (gRPC.AuctionAdminClient, gRPC.AuctionServiceClient, context.Context) {
+	t.Helper()
+	conn, err := grpc.Dial(c.Addrs[id], c.DialOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	t.Cleanup(cancel)
+	return gRPC.NewAuctionAdminClient(conn), gRPC.NewAuctionServiceClient(conn), metadata.AppendToOutgoingContext(ctx, "admin-token", "secret")
+}
+
+// waitForMembers waits until replica id has the given replicas.
+func waitForMembers(t *testing.T, c *auctiontest.Cluster, id int, want ...int32) {
+	t.Helper()
+	_, service, ctx := adminConn(t, c, id)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list, err := service.Members(ctx, &gRPC.Void{})
+		var got []int32
+		for _, m := range list.GetMembers() {
+			got = append(got, m.ServerID)
+		}
+		if err == nil && len(got) == len(want) {
+			same := true
+			for i := range got {
+				same = same && got[i] == want[i]
+			}
+			if same {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replica %d has the replicas %v (%v), want %v", id, got, err, want)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// mustBid makes a bid that has to be accepted.
+func mustBid(t *testing.T, c *auctiontest.Cluster, amount float32) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if ack, err := c.Client(1, "alice").Bid(ctx, amount); err != nil || !ack.Accepted {
+		t.Fatalf("the bid of %v: %v, %v", amount, ack, err)
+	}
+}
+
+func TestAddReplica(t *testing.T) {
+	c := auctiontest.NewCluster(t, 4, joining(3))
+	leader := c.WaitForLeader()
+	mustBid(t, c, 10)
+
+	admin, _, ctx := adminConn(t, c, leader)
+	if _, err := admin.AddReplica(ctx, &gRPC.MembershipRequest{ServerID: 3, Address: c.Addrs[3], Reason: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for id := range c.Addrs {
+		waitForMembers(t, c, id, 0, 1, 2, 3)
+	}
+	if _, err := admin.AddReplica(ctx, &gRPC.MembershipRequest{ServerID: 3, Address: c.Addrs[3]}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("adding replica 3 again: %v, want AlreadyExists", err)
+	}
+
+	// with four replicas it takes three for a majority, so the bid only goes through if the new one counts
+	for id := 0; id < 3; id++ {
+		if id != leader {
+			c.Stop(id)
+			break
+		}
+	}
+	mustBid(t, c, 20)
+}
+
+func TestRemoveTheLeader(t *testing.T) {
+	c := auctiontest.NewCluster(t, 3, joining(3))
+	leader := c.WaitForLeader()
+	mustBid(t, c, 10)
+
+	admin, _, ctx := adminConn(t, c, leader)
+	if _, err := admin.RemoveReplica(ctx, &gRPC.MembershipRequest{ServerID: int32(leader)}); err != nil {
+		t.Fatal(err)
+	}
+	var rest []int32
+	for id := range c.Addrs {
+		if id != leader {
+			rest = append(rest, int32(id))
+		}
+	}
+
+	// the others pick a new leader among themselves, the removed replica keeps running but stays out
+	deadline := time.Now().Add(10 * time.Second)
+	for next := c.Leader(); next < 0 || next == leader; next = c.Leader() {
+		if time.Now().After(deadline) {
+			t.Fatalf("no new leader after replica %d was removed, %d leads", leader, next)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	for _, id := range rest {
+		waitForMembers(t, c, int(id), rest...)
+	}
+	mustBid(t, c, 20)
+	if c.Server(leader).Leading() {
+		t.Fatalf("the removed replica %d leads again", leader)
+	}
+}
+
+func TestOneChangeAtATime(t *testing.T) {
+	c := auctiontest.NewCluster(t, 3, joining(3))
+	leader := c.WaitForLeader()
+	admin, _, ctx := adminConn(t, c, leader)
+
+	// nothing runs at replica4, so its catch up goes on until it is given up
+	slow, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		_, err := admin.AddReplica(slow, &gRPC.MembershipRequest{ServerID: 4, Address: "replica4"})
+		done <- err
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	_, err := admin.AddReplica(ctx, &gRPC.MembershipRequest{ServerID: 5, Address: "replica5"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("a second AddReplica while one is going on: %v, want FailedPrecondition", err)
+	}
+	cancel()
+	if err := <-done; err == nil {
+		t.Fatalf("replica 4 was added, but it is not running")
+	}
+	waitForMembers(t, c, leader, 0, 1, 2)
+}
+
+func TestMembersAfterRestart(t *testing.T) {
+	c := auctiontest.NewCluster(t, 4, joining(3))
+	leader := c.WaitForLeader()
+	admin, _, ctx := adminConn(t, c, leader)
+	if _, err := admin.AddReplica(ctx, &gRPC.MembershipRequest{ServerID: 3, Address: c.Addrs[3]}); err != nil {
+		t.Fatal(err)
+	}
+	// the change waits for the records after the last one to be committed
+	for {
+		_, err := admin.RemoveReplica(ctx, &gRPC.MembershipRequest{ServerID: int32((leader + 1) % 3)})
+		if err == nil {
+			break
+		}
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	want := []int32{0, 1, 2, 3}
+	want = append(want[:(leader+1)%3], want[(leader+1)%3+1:]...)
+
+	// every replica starts again with the Peers and Join it was first started with, only the
+	// members records in the logs say that 3 was added and the other one removed
+	c.StopAll()
+	for id := range c.Addrs {
+		c.Restart(id)
+	}
+	c.WaitForLeader()
+	for _, id := range want {
+		waitForMembers(t, c, int(id), want...)
+	}
+	mustBid(t, c, 10)
+}
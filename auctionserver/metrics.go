@@ -0,0 +1,196 @@
+package auctionserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// There is one auction per cluster, but the metrics are labelled with it so the dashboards
+// don't have to change if a cluster ever runs more than one.
+const auctionLabel = "default"
+
+// The reasons a bid is rejected, as they are counted in auction_bids_rejected_total.
+const (
+	rejectCancelled    = "cancelled"
+	rejectOver         = "over"
+	rejectNotLeader    = "not_leader"
+	rejectBanned       = "banned"
+	rejectOverBudget   = "over_budget"
+	rejectTooLow       = "too_low"
+	rejectNotCommitted = "not_committed"
+	rejectRateLimited  = "rate_limited"
+)
+
+// metrics are the Prometheus metrics of one replica. Every replica has its own registry,
+// so several of them can run in one process.
+type metrics struct {
+	registry     *prometheus.Registry
+	bidsAccepted *prometheus.CounterVec
+	bidsRejected *prometheus.CounterVec
+	bidsRetried  *prometheus.CounterVec
+	webhooks     *prometheus.CounterVec
+	rpcDuration  *prometheus.HistogramVec
+}
+
+func newMetrics(s *RMserver) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		bidsAccepted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auction_bids_accepted_total",
+			Help: "Bids this replica accepted as the leader.",
+		}, []string{"auction"}),
+		bidsRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auction_bids_rejected_total",
+			Help: "Bids this replica turned down, by why.",
+		}, []string{"auction", "reason"}),
+		bidsRetried: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auction_bids_retried_total",
+			Help: "Retries of bids that had already been accepted, answered with the first answer.",
+		}, []string{"auction"}),
+		webhooks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auction_webhooks_total",
+			Help: "Webhook events this replica sent as the leader, by type and if they were delivered or failed.",
+		}, []string{"type", "result"}),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "auction_grpc_server_handling_seconds",
+			Help:    "How long the unary gRPC calls took to handle, by method and status code.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"service", "method", "code"}),
+	}
+	// start every reason at 0, so a rate over them works before the first rejection
+	for _, reason := range []string{rejectCancelled, rejectOver, rejectNotLeader, rejectBanned, rejectOverBudget, rejectTooLow, rejectNotCommitted, rejectRateLimited} {
+		m.bidsRejected.WithLabelValues(auctionLabel, reason)
+	}
+	m.bidsAccepted.WithLabelValues(auctionLabel)
+	m.bidsRetried.WithLabelValues(auctionLabel)
+
+	m.registry.MustRegister(
+		m.bidsAccepted,
+		m.bidsRejected,
+		m.bidsRetried,
+		m.webhooks,
+		m.rpcDuration,
+		stateCollector{s},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+func (m *metrics) accepted() {
+	m.bidsAccepted.WithLabelValues(auctionLabel).Inc()
+}
+
+func (m *metrics) rejected(reason string) {
+	m.bidsRejected.WithLabelValues(auctionLabel, reason).Inc()
+}
+
+func (m *metrics) retried() {
+	m.bidsRetried.WithLabelValues(auctionLabel).Inc()
+}
+
+func (m *metrics) webhook(eventType, result string) {
+	m.webhooks.WithLabelValues(eventType, result).Inc()
+}
+
+// unaryInterceptor times every unary call.
+func (m *metrics) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	service, method := splitMethod(info.FullMethod)
+	m.rpcDuration.WithLabelValues(service, method, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// splitMethod splits "/proto.AuctionService/Bid" into the service and the method.
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
+
+// MetricsHandler serves the metrics of this replica in the Prometheus format.
+// Start already serves it on Config.MetricsAddr, this is for serving it somewhere else.
+func (s *RMserver) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{})
+}
+
+var (
+	highestBidDesc = prometheus.NewDesc("auction_highest_bid",
+		"The highest bid this replica has applied.", []string{"auction"}, nil)
+	untilCloseDesc = prometheus.NewDesc("auction_seconds_until_close",
+		"How long until the auction closes, 0 when it is over.", []string{"auction"}, nil)
+	replicationLagDesc = prometheus.NewDesc("auction_replication_lag_records",
+		"How many records a peer is behind the leader. Only the leader reports it.", []string{"peer"}, nil)
+	peerUpDesc = prometheus.NewDesc("auction_peer_up",
+		"1 if the peer answered a ping recently.", []string{"peer"}, nil)
+	leaderDesc = prometheus.NewDesc("auction_is_leader",
+		"1 if this replica is the leader and takes bids.", nil, nil)
+	termDesc = prometheus.NewDesc("auction_term",
+		"The term this replica is in.", nil, nil)
+	commitSeqDesc = prometheus.NewDesc("auction_commit_seq",
+		"The last audit log record this replica knows is committed.", nil, nil)
+)
+
+// stateCollector reads the state of the replica when it is scraped, instead of keeping
+// gauges up to date everywhere the state changes.
+type stateCollector struct {
+	s *RMserver
+}
+
+func (c stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- highestBidDesc
+	ch <- untilCloseDesc
+	ch <- replicationLagDesc
+	ch <- peerUpDesc
+	ch <- leaderDesc
+	ch <- termDesc
+	ch <- commitSeqDesc
+}
+
+func (c stateCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.s
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, highest := s.HighestBid()
+	ch <- prometheus.MustNewConstMetric(highestBidDesc, prometheus.GaugeValue, float64(highest), auctionLabel)
+
+	until := time.Until(s.auction.EndTime).Seconds()
+	if s.auction.Over || until < 0 {
+		until = 0
+	}
+	ch <- prometheus.MustNewConstMetric(untilCloseDesc, prometheus.GaugeValue, until, auctionLabel)
+
+	lastSeq := s.log.Len()
+	for _, p := range s.peers {
+		peer := fmt.Sprint(p.id)
+		up := 0.0
+		if time.Since(p.lastSeen) < peerTimeout {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(peerUpDesc, prometheus.GaugeValue, up, peer)
+		if s.isLeader {
+			ch <- prometheus.MustNewConstMetric(replicationLagDesc, prometheus.GaugeValue, float64(lastSeq-p.matchSeq), peer)
+		}
+	}
+
+	leader := 0.0
+	if s.isLeader && s.ready {
+		leader = 1
+	}
+	ch <- prometheus.MustNewConstMetric(leaderDesc, prometheus.GaugeValue, leader)
+	ch <- prometheus.MustNewConstMetric(termDesc, prometheus.GaugeValue, float64(s.term))
+	ch <- prometheus.MustNewConstMetric(commitSeqDesc, prometheus.GaugeValue, float64(s.commitSeq))
+}
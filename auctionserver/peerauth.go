@@ -0,0 +1,67 @@
+package auctionserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	Auction "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The replication service is served on the same address as the bidders, so its calls carry the
+// cluster token, which every replica of a cluster is started with. Without it anybody could ask
+// for a vote with a high term and depose the leader, or send Append a record they made up.
+// A server without other replicas makes up a token nobody knows, so nobody can call it.
+// The token is sent in the clear without TLS, like the admin token.
+
+// clusterTokenKey is the metadata the cluster token is sent in.
+const clusterTokenKey = "cluster-token"
+
+// replicationMethods is what the full method names of the replication service start with.
+var replicationMethods = "/" + Auction.ReplicationService_ServiceDesc.ServiceName + "/"
+
+// clusterToken sends the token with every call to another replica.
+type clusterToken string
+
+func (t clusterToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{clusterTokenKey: string(t)}, nil
+}
+
+func (t clusterToken) RequireTransportSecurity() bool { return false }
+
+// randomClusterToken is the token of a server that has no other replicas.
+func randomClusterToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// peerAuthInterceptor turns down the replication calls that do not have the cluster token.
+func (s *RMserver) peerAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if strings.HasPrefix(info.FullMethod, replicationMethods) {
+		if err := s.checkPeer(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// checkPeer makes sure the caller sent the cluster token, so it is one of the replicas.
+func (s *RMserver) checkPeer(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(clusterTokenKey)
+	if len(tokens) == 0 || tokens[0] == "" {
+		return status.Error(codes.Unauthenticated, "missing cluster token, only the replicas can call the replication service")
+	}
+	if subtle.ConstantTimeCompare([]byte(tokens[0]), []byte(s.cfg.ClusterToken)) != 1 {
+		return status.Error(codes.PermissionDenied, "wrong cluster token")
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package auctionserver_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestReplicationNeedsTheClusterToken(t *testing.T) {
+	c := auctiontest.NewCluster(t, 3)
+	leader := c.WaitForLeader()
+	conn, err := grpc.Dial(c.Addrs[leader], c.DialOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	replication := gRPC.NewReplicationServiceClient(conn)
+
+	// a vote with a term far ahead would make the leader step down, if it were counted
+	vote := &gRPC.VoteRequest{Term: 1000, CandidateID: 7, LastSeq: 1000, LastTerm: 1000}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"no token", context.Background(), codes.Unauthenticated},
+		{"wrong token", metadata.AppendToOutgoingContext(context.Background(), "cluster-token", "guess"), codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		if _, err := replication.RequestVote(tt.ctx, vote); status.Code(err) != tt.code {
+			t.Errorf("RequestVote with %s: %v, want %v", tt.name, err, tt.code)
+		}
+		if _, err := replication.Append(tt.ctx, &gRPC.AppendRequest{Term: 1000, LeaderID: 7}); status.Code(err) != tt.code {
+			t.Errorf("Append with %s: %v, want %v", tt.name, err, tt.code)
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if !c.Server(leader).Leading() {
+		t.Fatalf("replica %d stopped leading after the calls without the cluster token", leader)
+	}
+	// the replicas themselves still get through
+	if ack, err := c.Client(1, "alice").Bid(context.Background(), 10); err != nil || !ack.Accepted {
+		t.Fatalf("a bid in the cluster: %v, %v", ack, err)
+	}
+}
+
+func TestClusterTokenNeededWithPeers(t *testing.T) {
+	_, err := auctionserver.New(auctionserver.Config{
+		ListenAddr:   "localhost:0",
+		Peers:        []string{"localhost:8080", "localhost:8081"},
+		AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+	})
+	if err == nil {
+		t.Fatal("a replica with other replicas and no cluster token was made")
+	}
+}
@@ -0,0 +1,200 @@
+package auctionserver
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	Auction "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Bids and retractions are rate limited with token buckets, one per bidder and one per source
+// address. A call needs a token from both, and when either is empty it is turned down with
+// RESOURCE_EXHAUSTED and the retry-after-ms trailer, which says when the next token is there.
+// The bidder is the common name of the client certificate when the client showed one. Without TLS
+// the clientID can't be trusted, so the bidder is the source address and both limits count the
+// calls of an address. Every replica limits the calls it gets on its own.
+
+// RetryAfterKey is the trailer a rate limited call gets, the milliseconds until it can be tried again.
+const RetryAfterKey = "retry-after-ms"
+
+// RateLimit is how fast bids and retractions can come in. A rate of 0 has no limit.
+type RateLimit struct {
+	BidderRate  float64 // calls per second per bidder
+	BidderBurst int     // how many calls a bidder can make at once, default the rate rounded up
+	AddrRate    float64 // calls per second per source address, so many bidders behind one address share it
+	AddrBurst   int     // how many calls an address can make at once, default the rate rounded up
+}
+
+// limitedMethods are the calls that are rate limited.
+var limitedMethods = map[string]bool{
+	Auction.AuctionService_Bid_FullMethodName:        true,
+	Auction.AuctionService_RetractBid_FullMethodName: true,
+}
+
+// idleBuckets is how often the buckets that are full again are dropped, so the map does not grow
+// with every bidder and address that was ever seen.
+const idleBuckets = time.Minute
+
+// bucket holds up to burst tokens and gets rate of them a second.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter is a set of token buckets with the same rate, by key.
+type limiter struct {
+	rate  float64
+	burst float64
+
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &limiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), swept: time.Now()}
+}
+
+// refill lets the bucket of key fill up to now and returns it with how long until it has a token.
+// The caller must hold l.mutex.
+func (l *limiter) refill(key string, now time.Time) (*bucket, time.Duration) {
+	if now.Sub(l.swept) > idleBuckets {
+		l.sweep(now)
+	}
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return b, 0
+	}
+	return b, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep drops the buckets that have filled up again, they are the same as new ones.
+// The caller must hold l.mutex.
+func (l *limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// rateLimiter is the limit per bidder and per address of one replica.
+type rateLimiter struct {
+	bidders *limiter
+	addrs   *limiter
+}
+
+func newRateLimiter(cfg RateLimit) *rateLimiter {
+	return &rateLimiter{bidders: newLimiter(cfg.BidderRate, cfg.BidderBurst), addrs: newLimiter(cfg.AddrRate, cfg.AddrBurst)}
+}
+
+// take takes a token for the bidder and one for the address. A call that is over either limit
+// takes neither, so the calls that are turned down don't use up the other bucket. If it is over,
+// take returns how long until both have a token and which of them is empty.
+func (r *rateLimiter) take(bidder, addr string, now time.Time) (bool, time.Duration, string) {
+	type key struct {
+		l    *limiter
+		key  string
+		what string
+	}
+	var buckets []*bucket
+	wait, what := time.Duration(0), ""
+	// the bidders are always locked before the addresses
+	for _, k := range []key{{r.bidders, bidder, "bidder " + bidder}, {r.addrs, addr, "address " + addr}} {
+		if k.l == nil {
+			continue // no limit
+		}
+		k.l.mutex.Lock()
+		defer k.l.mutex.Unlock()
+		b, w := k.l.refill(k.key, now)
+		if w > wait {
+			wait, what = w, k.what
+		}
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return false, wait, what
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0, ""
+}
+
+// rateLimitInterceptor turns down the bids and retractions that are over the limit.
+func (s *RMserver) rateLimitInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !limitedMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	ok, wait, what := s.limits.take(bidderKey(ctx), sourceAddr(ctx), time.Now())
+	if ok {
+		return handler(ctx, req)
+	}
+
+	if info.FullMethod == Auction.AuctionService_Bid_FullMethodName {
+		s.rejected(ctx, rejectRateLimited)
+	}
+	ms := wait.Milliseconds() + 1
+	grpc.SetTrailer(ctx, metadata.Pairs(RetryAfterKey, strconv.FormatInt(ms, 10)))
+	s.logFor(ctx).Debug("rate limited", "method", info.FullMethod, "key", what, "retry_after_ms", ms)
+	return nil, status.Errorf(codes.ResourceExhausted, "too many requests from %s, try again in %dms", what, ms)
+}
+
+// bidderKey is who a call is from: the common name of its verified client certificate. Without one
+// the clientID in the request is only what the client says, and a client could use a new one for
+// every call, so it is then the address the call came from.
+func bidderKey(ctx context.Context) string {
+	if name := certName(ctx); name != "" {
+		return "cert:" + name
+	}
+	return "addr:" + sourceAddr(ctx)
+}
+
+// certName is the common name of the client certificate the call was made with, if the server
+// verified it, or else "". It is the only thing about the caller the server can trust.
+func certName(ctx context.Context) string {
+	if p, ok := grpcpeer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			return info.State.VerifiedChains[0][0].Subject.CommonName
+		}
+	}
+	return ""
+}
+
+// sourceAddr is the address a call came from, without the port. Calls over a Unix socket
+// or an in-memory connection all share one key.
+func sourceAddr(ctx context.Context) string {
+	p, ok := grpcpeer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.Network()
+	}
+	return host
+}
@@ -0,0 +1,92 @@
+package auctionserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterBurstAndRate(t *testing.T) {
+	r := newRateLimiter(RateLimit{BidderRate: 2, BidderBurst: 3})
+	now := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := r.take("alice", "", now); !ok {
+			t.Fatalf("call %d of the burst was limited", i+1)
+		}
+	}
+	ok, wait, what := r.take("alice", "", now)
+	if ok || wait != 500*time.Millisecond || what != "bidder alice" {
+		t.Fatalf("the call after the burst: %v, wait %v, %q, want limited for 500ms by bidder alice", ok, wait, what)
+	}
+	// another bidder has its own bucket
+	if ok, _, _ := r.take("bob", "", now); !ok {
+		t.Fatalf("bob was limited by alice's calls")
+	}
+	if ok, wait, _ := r.take("alice", "", now.Add(250*time.Millisecond)); ok || wait != 250*time.Millisecond {
+		t.Fatalf("half a token later: %v, wait %v, want limited for 250ms", ok, wait)
+	}
+	if ok, _, _ := r.take("alice", "", now.Add(500*time.Millisecond)); !ok {
+		t.Fatalf("a token later alice was still limited")
+	}
+	// the bucket holds no more than the burst
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := r.take("alice", "", later); !ok {
+			t.Fatalf("call %d after an hour was limited", i+1)
+		}
+	}
+	if ok, _, _ := r.take("alice", "", later); ok {
+		t.Fatalf("the bucket filled up over the burst")
+	}
+}
+
+func TestLimiterDefaultBurst(t *testing.T) {
+	if l := newLimiter(2.5, 0); l.burst != 3 {
+		t.Fatalf("the burst of a rate of 2.5 is %v, want 3", l.burst)
+	}
+	if l := newLimiter(0, 10); l != nil {
+		t.Fatalf("a rate of 0 should have no limiter")
+	}
+}
+
+func TestRateLimiterTakesFromBoth(t *testing.T) {
+	r := newRateLimiter(RateLimit{BidderRate: 1, BidderBurst: 2, AddrRate: 1, AddrBurst: 1})
+	now := time.Unix(1700000000, 0)
+	if ok, _, _ := r.take("alice", "10.0.0.1", now); !ok {
+		t.Fatalf("the first call was limited")
+	}
+	// the address is empty, so alice's second call is turned down and does not use up her bucket
+	for i := 0; i < 3; i++ {
+		if ok, _, what := r.take("alice", "10.0.0.1", now); ok || what != "address 10.0.0.1" {
+			t.Fatalf("a call from the empty address: %v, %q, want limited by the address", ok, what)
+		}
+	}
+	if ok, _, _ := r.take("alice", "10.0.0.2", now); !ok {
+		t.Fatalf("alice was limited from another address, her bucket was taken from by turned down calls")
+	}
+	// with both empty the call waits for the one that fills up last
+	r = newRateLimiter(RateLimit{BidderRate: 1, BidderBurst: 1, AddrRate: 4, AddrBurst: 1})
+	r.take("alice", "10.0.0.1", now)
+	if ok, wait, what := r.take("alice", "10.0.0.1", now); ok || wait != time.Second || what != "bidder alice" {
+		t.Fatalf("with both empty: %v, wait %v, %q, want limited for 1s by bidder alice", ok, wait, what)
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	l := newLimiter(1, 1)
+	now := time.Unix(1700000000, 0)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.swept = now
+	l.refill("alice", now)
+	l.buckets["alice"].tokens--
+	l.refill("bob", now.Add(idleBuckets))
+	l.buckets["bob"].tokens--
+	// alice's bucket is full again, bob's was only just emptied
+	l.refill("carol", now.Add(idleBuckets+time.Millisecond))
+	if _, ok := l.buckets["alice"]; ok {
+		t.Fatalf("the full bucket of alice was not dropped")
+	}
+	if _, ok := l.buckets["bob"]; !ok {
+		t.Fatalf("the bucket of bob was dropped before it was full")
+	}
+}
@@ -0,0 +1,900 @@
+package auctionserver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+	"github.com/Alex-itu/A_Distributed_Auction_System/logging"
+	Auction "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+	"github.com/Alex-itu/A_Distributed_Auction_System/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// How replication works:
+// Only the leader accepts bids. Every event (bid, close, config) is appended to the leader's
+// audit log and pushed to the other replicas with Append. An event counts as committed once a
+// majority has it, and only committed events change the auction state (CurrentBids etc.).
+// The replica with the lowest id that can reach a majority tries to lead. It first copies the
+// most up to date log from the others and then asks for their votes in a new term. A replica
+// only votes once per term and only for a candidate whose log is at least as up to date as its own,
+// so a new leader always has every committed bid.
+// Which replicas there are can change while the cluster runs, see membership.go.
+
+const (
+	pingInterval  = 100 * time.Millisecond
+	peerTimeout   = 500 * time.Millisecond // a peer that has not answered a ping for this long is seen as dead
+	rpcTimeout    = 300 * time.Millisecond
+	commitTimeout = 1 * time.Second
+
+	// An Append or FetchLog reply carries at most one page of records, so a replica that is far
+	// behind gets the log in pieces that stay well under the 4 MB gRPC takes in one message.
+	pageRecords = 1000
+	pageBytes   = 1 << 20
+)
+
+// peer is another replica that this server replicates to.
+type peer struct {
+	id     int
+	addr   string
+	conn   *grpc.ClientConn
+	client Auction.ReplicationServiceClient
+
+	sendMutex sync.Mutex // only one Append to a peer at a time
+	lastSeen  time.Time
+	info      *Auction.PingReply
+	nextSeq   int64     // the next record the leader will send to the peer
+	matchSeq  int64     // the last record the leader knows the peer has
+	confirmed time.Time // when the last Append the peer answered in the leader's term was sent, see readBarrier
+}
+
+// peerDialOptions are the options the other replicas are dialed with.
+func (s *RMserver) peerDialOptions() []grpc.DialOption {
+	creds := insecure.NewCredentials()
+	if s.cfg.PeerTLS != nil {
+		creds = credentials.NewTLS(s.cfg.PeerTLS)
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(clusterToken(s.cfg.ClusterToken)),
+	}
+	if s.cfg.Dialer != nil {
+		opts = append(opts, grpc.WithContextDialer(s.cfg.Dialer))
+	}
+	// the request id and the spans go before the DialOptions, so a delay the chaos network adds shows up in them
+	opts = append(opts,
+		grpc.WithChainUnaryInterceptor(logging.UnaryClientInterceptor, tracing.UnaryClientInterceptor(s.cfg.TracerProvider, s.replicaAttr())),
+	)
+	return append(opts, s.cfg.DialOptions...)
+}
+
+// connectToPeers dials the members and learners that are not connected yet and closes the
+// connections to the replicas that are gone. The dial does not block, so peers that are not up
+// yet are connected to when they come up. s.peers is replaced and never changed in place,
+// so what peerList returns can be used without the lock. The caller must hold s.mutex.
+func (s *RMserver) connectToPeers() {
+	if s.peerOpts == nil {
+		return // not started yet, Start connects
+	}
+	want := make(map[int]string)
+	for id, addr := range s.members {
+		want[id] = addr
+	}
+	for id, addr := range s.learners {
+		want[id] = addr
+	}
+	delete(want, s.Id)
+
+	var peers []*peer
+	for _, p := range s.peers {
+		if addr, ok := want[p.id]; ok && addr == p.addr {
+			peers = append(peers, p)
+			delete(want, p.id)
+			continue
+		}
+		p.conn.Close()
+		s.logger.Info("disconnected from peer", "peer", p.id, "addr", p.addr)
+	}
+	for id, addr := range want {
+		conn, err := grpc.Dial(addr, s.peerOpts...)
+		if err != nil {
+			s.logger.Error("failed to dial peer", "peer", id, "addr", addr, "err", err)
+			continue
+		}
+		peers = append(peers, &peer{
+			id:      id,
+			addr:    addr,
+			conn:    conn,
+			client:  Auction.NewReplicationServiceClient(conn),
+			nextSeq: s.log.Len() + 1,
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].id < peers[j].id })
+	s.peers = peers
+}
+
+// peerList returns the peers right now.
+func (s *RMserver) peerList() []*peer {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.peers
+}
+
+// majority is the number of members that must have a record before it is committed.
+// The caller must hold s.mutex.
+func (s *RMserver) majority() int {
+	return len(s.members)/2 + 1
+}
+
+// hasQuorum reports if this replica can reach a majority of the members, itself included if it is one.
+// The caller must hold s.mutex.
+func (s *RMserver) hasQuorum() bool {
+	count := 0
+	if s.isMember(s.Id) {
+		count++
+	}
+	for _, p := range s.alivePeers() {
+		if s.isMember(p.id) {
+			count++
+		}
+	}
+	return count >= s.majority()
+}
+
+// pingLoop keeps track of which peers are alive and decides who should lead.
+func (s *RMserver) pingLoop() {
+	for !s.stopped() {
+		var wg sync.WaitGroup
+		for _, p := range s.peerList() {
+			wg.Add(1)
+			go func(p *peer) {
+				defer wg.Done()
+				s.ping(p)
+			}(p)
+		}
+		wg.Wait()
+
+		s.checkLeader()
+		if s.leading() {
+			// the heartbeats would start a trace every round, the records are traced by commit
+			s.heartbeat(tracing.Untraced(context.Background()))
+			s.fixAddresses()
+		}
+		s.updateHealth()
+		time.Sleep(pingInterval)
+	}
+}
+
+func (s *RMserver) ping(p *peer) {
+	s.mutex.Lock()
+	term := s.term
+	s.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(tracing.Untraced(context.Background()), rpcTimeout)
+	defer cancel()
+	reply, err := p.client.Ping(ctx, &Auction.PingRequest{ServerID: int32(s.Id), Term: term})
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	p.lastSeen = time.Now()
+	p.info = reply
+	s.mutex.Unlock()
+}
+
+// alivePeers returns the peers that answered a ping recently. The caller must hold s.mutex.
+func (s *RMserver) alivePeers() []*peer {
+	var alive []*peer
+	for _, p := range s.peers {
+		if time.Since(p.lastSeen) < peerTimeout {
+			alive = append(alive, p)
+		}
+	}
+	return alive
+}
+
+// checkLeader works out if this replica should try to take over or give up the leadership.
+// A leader keeps leading until it loses the quorum or sees a newer term, also when a replica
+// with a lower id comes back, because that replica has to win an election first.
+// Only the members take part, a learner or a removed replica just follows,
+// and so does a replica that is shutting down.
+func (s *RMserver) checkLeader() {
+	s.mutex.Lock()
+	member := s.isMember(s.Id) && !s.draining
+	lowest := s.Id
+	for _, p := range s.alivePeers() {
+		if s.isMember(p.id) && !p.info.GetDraining() && p.id < lowest {
+			lowest = p.id
+		}
+	}
+	hasQuorum := s.hasQuorum()
+
+	if s.isLeader && !hasQuorum {
+		s.stepDown("lost contact with the majority")
+	}
+	// a leader that removed itself leads until the change is committed
+	if s.isLeader && !member && s.membersSeq <= s.commitSeq {
+		s.stepDown("no longer one of the replicas")
+	}
+	shouldRun := !s.isLeader && member && hasQuorum && lowest == s.Id
+	s.mutex.Unlock()
+
+	if shouldRun {
+		s.runElection()
+	}
+}
+
+// stepDown stops this replica from acting as leader. The caller must hold s.mutex.
+func (s *RMserver) stepDown(reason string) {
+	if !s.isLeader {
+		return
+	}
+	s.isLeader = false
+	s.ready = false
+	s.leaderID = -1
+	s.logger.Info("no longer the leader", "reason", reason, "term", s.term)
+}
+
+// runElection copies the most up to date log from the live replicas, asks them for their votes
+// in a new term and, if a majority votes for it, commits a config record as the new leader.
+// The config record also commits everything the old leader left behind.
+func (s *RMserver) runElection() {
+	// every election is a trace of its own, with the catching up, the votes and the config record in it
+	ctx, span := s.tracer.Start(context.Background(), "election", trace.WithAttributes(s.replicaAttr()))
+	defer span.End()
+
+	s.mutex.Lock()
+	var best *peer
+	last := s.log.Last()
+	bestTerm, bestSeq := last.Term, last.Seq
+	maxTerm := s.term
+	for _, p := range s.alivePeers() {
+		if p.info == nil {
+			continue
+		}
+		if p.info.Term > maxTerm {
+			maxTerm = p.info.Term
+		}
+		if p.info.LastTerm > bestTerm || (p.info.LastTerm == bestTerm && p.info.LastSeq > bestSeq) {
+			best, bestTerm, bestSeq = p, p.info.LastTerm, p.info.LastSeq
+		}
+	}
+	s.mutex.Unlock()
+
+	if best != nil {
+		if err := s.catchUpFrom(ctx, best); err != nil {
+			span.SetStatus(otelcodes.Error, "could not catch up")
+			s.logger.Warn("could not catch up", "from", best.id, "err", err)
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	if maxTerm < s.term {
+		maxTerm = s.term
+	}
+	s.term = maxTerm + 1
+	s.votedFor = s.Id
+	if err := s.persistTerm(); err != nil {
+		s.mutex.Unlock()
+		span.SetStatus(otelcodes.Error, "could not write the term file")
+		s.logger.Warn("could not write the term file, not standing for election", "err", err)
+		return
+	}
+	term := s.term
+	last = s.log.Last()
+	var voters []*peer
+	for _, p := range s.peers {
+		if s.isMember(p.id) {
+			voters = append(voters, p)
+		}
+	}
+	s.mutex.Unlock()
+	span.SetAttributes(attribute.Int64("auction.term", term))
+
+	// ask every member for their vote at the same time
+	req := &Auction.VoteRequest{Term: term, CandidateID: int32(s.Id), LastSeq: last.Seq, LastTerm: last.Term}
+	votes := make(chan bool, len(voters))
+	for _, p := range voters {
+		go func(p *peer) {
+			ctx, cancel := context.WithTimeout(ctx, rpcTimeout)
+			defer cancel()
+			reply, err := p.client.RequestVote(ctx, req)
+			if err != nil {
+				votes <- false
+				return
+			}
+			s.mutex.Lock()
+			s.adoptTerm(reply.Term)
+			s.mutex.Unlock()
+			votes <- reply.Granted
+		}(p)
+	}
+	granted := 1
+	for range voters {
+		if <-votes {
+			granted++
+		}
+	}
+	span.SetAttributes(attribute.Int("auction.votes", granted))
+
+	s.mutex.Lock()
+	if granted < s.majority() || s.term != term {
+		s.mutex.Unlock()
+		span.SetStatus(otelcodes.Error, "lost the election")
+		s.logger.Info("lost the election", "term", term, "votes", granted)
+		return
+	}
+	s.isLeader = true
+	s.leaderID = s.Id
+	for _, p := range s.peers {
+		p.nextSeq = s.log.Len() + 1
+		p.matchSeq = 0
+	}
+	s.ready = true
+	ends := s.auction.EndTime
+	s.mutex.Unlock()
+
+	s.logger.Info("became the leader", "term", term)
+
+	s.commitMutex.Lock()
+	defer s.commitMutex.Unlock()
+	_, err := s.commit(ctx, audit.Record{
+		Kind:   audit.KindConfig,
+		Detail: fmt.Sprintf("server %d leads term %d, auction ends at %s", s.Id, term, ends.Format(time.DateTime)),
+	})
+	if err != nil {
+		s.mutex.Lock()
+		s.stepDown("could not commit the config record")
+		s.mutex.Unlock()
+		return
+	}
+	s.createAuction(ctx)
+}
+
+// catchUpFrom replaces the part of our log that differs from the log of p.
+// Only records we never committed can differ, because p's log is more up to date than ours,
+// so it fetches from our last committed record on, one page at a time.
+func (s *RMserver) catchUpFrom(ctx context.Context, p *peer) error {
+	s.mutex.Lock()
+	from := max(s.commitSeq, 1)
+	s.mutex.Unlock()
+
+	first := true        // the first page starts at a record we have, unless that one differs too
+	replaced := int64(0) // the first record of ours that was replaced
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, commitTimeout)
+		reply, err := p.client.FetchLog(fetchCtx, &Auction.FetchRequest{FromSeq: from})
+		cancel()
+		if err != nil {
+			return err
+		}
+		records := fromProtoRecords(reply.Records)
+		if len(records) == 0 {
+			break
+		}
+		if records[0].Seq != from {
+			return fmt.Errorf("server %d sent records from %d instead of %d", p.id, records[0].Seq, from)
+		}
+
+		// skip the records we have, the first one that differs is where ours get replaced
+		same := from - 1
+		for _, r := range records {
+			mine, ok := s.log.Get(r.Seq)
+			if !ok || mine.Hash != r.Hash {
+				break
+			}
+			same = r.Seq
+		}
+		if first && same < from && from > 1 {
+			// even our last committed record is not in p's log, so compare from the start
+			from = 1
+			continue
+		}
+		first = false
+		if rest := records[same-from+1:]; len(rest) > 0 {
+			if replaced == 0 {
+				replaced = same + 1
+				s.logger.Info("copying records", "from_seq", same+1, "to_seq", reply.LastSeq, "from", p.id)
+			}
+			// AppendAfter checks that every record fits the chain of the ones before it
+			if err := s.log.AppendAfter(same, rest); err != nil {
+				return err
+			}
+		}
+		from = records[len(records)-1].Seq + 1
+		if from > reply.LastSeq {
+			break
+		}
+	}
+	if replaced > 0 {
+		s.mutex.Lock()
+		s.membersChanged(replaced)
+		s.mutex.Unlock()
+	}
+	return nil
+}
+
+// leading reports if this replica is the leader and has caught up.
+func (s *RMserver) leading() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.isLeader && s.ready
+}
+
+// commit appends a record to the leader's log and waits until a majority of the replicas has it.
+// If that does not happen an error is returned. The record stays in the log, so it can still be
+// committed later, which is why the client is only told that the outcome is unknown.
+// The caller must hold s.commitMutex, so the records are added in the order the checks were made.
+// When ctx is done before the record is committed, commit stops waiting and returns the context's
+// error. The record stays in the log and is committed with the next one, so the caller has to treat
+// it as maybe made, the same as a timeout.
+func (s *RMserver) commit(ctx context.Context, r audit.Record) (audit.Record, error) {
+	ctx, span := s.tracer.Start(ctx, "commit", trace.WithAttributes(s.replicaAttr(), attribute.String("auction.record", string(r.Kind))))
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		// the caller gave up while waiting for its turn, so don't add anything
+		span.SetStatus(otelcodes.Error, err.Error())
+		return audit.Record{}, status.FromContextError(err).Err()
+	}
+
+	s.mutex.Lock()
+	if !s.isLeader || !s.ready {
+		s.mutex.Unlock()
+		span.SetStatus(otelcodes.Error, "not the leader")
+		return audit.Record{}, status.Errorf(codes.Unavailable, "server %d is not the leader", s.Id)
+	}
+	r.Term = s.term
+	r.Time = time.Now().UnixNano()
+	s.mutex.Unlock()
+
+	appended, err := s.log.Append(r)
+	if err != nil {
+		span.SetStatus(otelcodes.Error, err.Error())
+		return audit.Record{}, status.Errorf(codes.Internal, "could not write the audit log: %v", err)
+	}
+	span.SetAttributes(attribute.Int64("auction.seq", appended.Seq), attribute.Int64("auction.term", appended.Term))
+	if appended.Kind == audit.KindMembers {
+		// the new replicas count from now on, also for committing this record
+		s.mutex.Lock()
+		s.membersChanged(appended.Seq)
+		s.mutex.Unlock()
+	}
+
+	deadline := time.Now().Add(commitTimeout)
+	for time.Now().Before(deadline) {
+		s.replicateAll(ctx)
+
+		s.mutex.Lock()
+		if !s.isLeader {
+			s.mutex.Unlock()
+			break
+		}
+		if s.majorityHas(appended.Seq) {
+			if appended.Seq > s.commitSeq {
+				s.commitSeq = appended.Seq
+			}
+			s.applyCommitted(ctx)
+			s.mutex.Unlock()
+			return appended, nil
+		}
+		s.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+			span.SetStatus(otelcodes.Error, ctx.Err().Error())
+			s.logFor(ctx).Info("stopped waiting for the commit, the caller is gone", "seq", appended.Seq, "err", ctx.Err())
+			return audit.Record{}, status.FromContextError(ctx.Err()).Err()
+		case <-time.After(pingInterval / 2):
+		}
+	}
+
+	span.SetStatus(otelcodes.Error, "no majority")
+	s.logger.Warn("could not replicate record to a majority", "seq", appended.Seq)
+	return audit.Record{}, status.Errorf(codes.Unavailable, "server %d could not reach a majority of the replicas", s.Id)
+}
+
+// majorityHas reports if a majority of the replicas has the records up to seq. The caller must hold s.mutex.
+func (s *RMserver) majorityHas(seq int64) bool {
+	count := 0
+	if s.isMember(s.Id) {
+		count++
+	}
+	for _, p := range s.peers {
+		if s.isMember(p.id) && p.matchSeq >= seq {
+			count++
+		}
+	}
+	return count >= s.majority()
+}
+
+// readBarrier returns once a majority has taken an Append that was sent after it was called, so no
+// other replica can have been elected and have committed anything we don't know about. The state
+// is then at least as new as every bid that was accepted before the read started.
+// Followers don't answer reads at all, their state can be behind the leader's.
+func (s *RMserver) readBarrier(ctx context.Context) error {
+	start := time.Now()
+	deadline := time.After(commitTimeout)
+	for {
+		s.mutex.Lock()
+		if !s.isLeader || !s.ready {
+			leader := s.leaderID
+			s.mutex.Unlock()
+			return status.Errorf(codes.Unavailable, "server %d is not the leader, the leader is server %d", s.Id, leader)
+		}
+		count := 0
+		if s.isMember(s.Id) {
+			count++
+		}
+		for _, p := range s.peers {
+			if s.isMember(p.id) && !p.confirmed.Before(start) {
+				count++
+			}
+		}
+		confirmed := count >= s.majority()
+		wait := s.confirmations
+		s.mutex.Unlock()
+		if confirmed {
+			return nil
+		}
+
+		// the reads that come in together share the Appends, a peer that is busy with one
+		// is asked again when it answers
+		s.heartbeat(tracing.Untraced(context.Background()))
+		select {
+		case <-wait:
+		case <-time.After(pingInterval):
+		case <-deadline:
+			return status.Errorf(codes.Unavailable, "server %d could not reach a majority of the replicas", s.Id)
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// advanceCommit commits the records a majority has that nobody waits for, like the rejected bids,
+// when the heartbeats got them to the peers. Like in Raft only a record of our own term is counted,
+// the older ones are committed with it. The caller must hold s.mutex.
+func (s *RMserver) advanceCommit(ctx context.Context) {
+	for seq := s.log.Len(); seq > s.commitSeq; seq-- {
+		r, ok := s.log.Get(seq)
+		if !ok || r.Term != s.term {
+			return
+		}
+		if s.majorityHas(seq) {
+			s.commitSeq = seq
+			s.applyCommitted(ctx)
+			return
+		}
+	}
+}
+
+// lockCommit takes s.commitMutex for a call, or gives up when the call's ctx is done first,
+// so a call that waits behind a slow commit does not outlive its client.
+func (s *RMserver) lockCommit(ctx context.Context) error {
+	if s.commitMutex.TryLock() {
+		return nil
+	}
+	locked := make(chan struct{})
+	go func() {
+		s.commitMutex.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		// whoever has the lock is still busy, let it go as soon as we get it
+		go func() {
+			<-locked
+			s.commitMutex.Unlock()
+		}()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// replicateAll sends the records each peer is missing, together with the commit seq.
+func (s *RMserver) replicateAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range s.peerList() {
+		wg.Add(1)
+		go func(p *peer) {
+			defer wg.Done()
+			s.sendAppend(ctx, p)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// heartbeat is replicateAll without waiting for the answers, so a peer that hangs does not hold up
+// the pings to the others, which would make them look dead. A peer that is still busy with an
+// append is left out of this round.
+func (s *RMserver) heartbeat(ctx context.Context) {
+	for _, p := range s.peerList() {
+		if !p.sendMutex.TryLock() {
+			continue
+		}
+		go func(p *peer) {
+			defer p.sendMutex.Unlock()
+			s.sendAppendLocked(ctx, p)
+		}(p)
+	}
+}
+
+// sendAppend brings one peer up to date. If the peer's log does not match ours
+// we go back one record at a time until it does.
+func (s *RMserver) sendAppend(ctx context.Context, p *peer) {
+	p.sendMutex.Lock()
+	defer p.sendMutex.Unlock()
+	s.sendAppendLocked(ctx, p)
+}
+
+// sendAppendLocked is sendAppend for a caller that holds p.sendMutex.
+func (s *RMserver) sendAppendLocked(ctx context.Context, p *peer) {
+
+	// a span per peer shows which replica was slow. ctx only carries the trace,
+	// the append must not stop when the client that made the bid goes away
+	ctx, span := s.tracer.Start(context.WithoutCancel(ctx), "replicate", trace.WithAttributes(s.replicaAttr(), attribute.Int("auction.peer", p.id)))
+	defer span.End()
+
+	for !s.stopped() {
+		s.mutex.Lock()
+		if !s.isLeader || !s.ready {
+			s.mutex.Unlock()
+			return
+		}
+		if p.nextSeq < 1 {
+			p.nextSeq = 1
+		}
+		prevSeq := p.nextSeq - 1
+		prev, _ := s.log.Get(prevSeq)
+		req := &Auction.AppendRequest{
+			Term:      s.term,
+			LeaderID:  int32(s.Id),
+			PrevSeq:   prevSeq,
+			PrevHash:  prev.Hash,
+			Records:   toProtoRecords(page(s.log.Page(p.nextSeq, pageRecords))),
+			CommitSeq: s.commitSeq,
+		}
+		s.mutex.Unlock()
+
+		sent := time.Now()
+		callCtx, cancel := context.WithTimeout(ctx, rpcTimeout)
+		reply, err := p.client.Append(callCtx, req)
+		cancel()
+		if err != nil {
+			span.SetStatus(otelcodes.Error, err.Error())
+			return
+		}
+
+		s.mutex.Lock()
+		if reply.Term > s.term {
+			s.adoptTerm(reply.Term)
+			s.stepDown(fmt.Sprintf("server %d is in a newer term", p.id))
+			s.mutex.Unlock()
+			return
+		}
+		if reply.Term == req.Term && sent.After(p.confirmed) {
+			// the peer still takes us as the leader, whether it had the records or not
+			p.confirmed = sent
+			close(s.confirmations)
+			s.confirmations = make(chan struct{})
+		}
+		if reply.Success {
+			span.SetAttributes(attribute.Int64("auction.match_seq", reply.LastSeq))
+			p.matchSeq = reply.LastSeq
+			p.nextSeq = reply.LastSeq + 1
+			s.advanceCommit(ctx)
+			s.mutex.Unlock()
+			// the rest of the log goes with the next heartbeat or commit, so a replica that is far
+			// behind does not hold up the bid that is being committed
+			return
+		}
+		if prevSeq == 0 {
+			// the peer can't take our log from the start either, so give up until the next round
+			s.mutex.Unlock()
+			return
+		}
+		// the peer is missing records or has records we don't, so try from further back
+		p.nextSeq = prevSeq
+		if reply.LastSeq+1 < p.nextSeq {
+			p.nextSeq = reply.LastSeq + 1
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// applyCommitted updates the auction state with every committed record that has not been applied yet.
+// The caller must hold s.mutex.
+func (s *RMserver) applyCommitted(ctx context.Context) {
+	if s.appliedSeq >= s.commitSeq {
+		return
+	}
+	_, span := s.tracer.Start(ctx, "apply", trace.WithAttributes(s.replicaAttr(),
+		attribute.Int64("auction.from_seq", s.appliedSeq+1), attribute.Int64("auction.to_seq", s.commitSeq)))
+	defer span.End()
+	for s.appliedSeq < s.commitSeq {
+		r, ok := s.log.Get(s.appliedSeq + 1)
+		if !ok {
+			return
+		}
+		s.apply(r)
+		s.appliedSeq = r.Seq
+		s.notifyWatchers()
+	}
+}
+
+// Append is called by the leader to add records to this replica's log.
+func (s *RMserver) Append(ctx context.Context, req *Auction.AppendRequest) (*Auction.AppendReply, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// the leader gave up on this call while we waited for the lock, it sends the records again
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+
+	if req.Term < s.term {
+		return &Auction.AppendReply{Term: s.term, Success: false, LastSeq: s.log.Len()}, nil
+	}
+	if req.Term > s.term || int(req.LeaderID) != s.leaderID {
+		s.adoptTerm(req.Term)
+		s.stepDown(fmt.Sprintf("server %d leads term %d", req.LeaderID, req.Term))
+		s.leaderID = int(req.LeaderID)
+		s.logger.Info("following", "leader", req.LeaderID, "term", req.Term)
+	}
+
+	prev, ok := s.log.Get(req.PrevSeq)
+	if req.PrevSeq > 0 && (!ok || prev.Hash != req.PrevHash) {
+		lastSeq := s.log.Len()
+		if req.PrevSeq-1 < lastSeq {
+			lastSeq = req.PrevSeq - 1
+		}
+		return &Auction.AppendReply{Term: s.term, Success: false, LastSeq: lastSeq}, nil
+	}
+
+	records := fromProtoRecords(req.Records)
+	if err := s.log.AppendAfter(req.PrevSeq, records); err != nil {
+		s.logger.Warn("rejected records", "from", req.LeaderID, "err", err)
+		return &Auction.AppendReply{Term: s.term, Success: false, LastSeq: req.PrevSeq}, nil
+	}
+	if len(records) > 0 {
+		s.membersChanged(req.PrevSeq + 1)
+	}
+
+	matched := req.PrevSeq + int64(len(records))
+	s.lastInSync = time.Now()
+	commitSeq := req.CommitSeq
+	if commitSeq > matched {
+		commitSeq = matched
+	}
+	if commitSeq > s.commitSeq {
+		s.commitSeq = commitSeq
+		s.applyCommitted(ctx)
+	}
+	return &Auction.AppendReply{Term: s.term, Success: true, LastSeq: matched}, nil
+}
+
+// Ping tells the other replicas that this one is alive and how far its log goes.
+func (s *RMserver) Ping(ctx context.Context, req *Auction.PingRequest) (*Auction.PingReply, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	last := s.log.Last()
+	return &Auction.PingReply{
+		ServerID: int32(s.Id),
+		Term:     s.term,
+		LeaderID: int32(s.leaderID),
+		LastSeq:  last.Seq,
+		LastTerm: last.Term,
+		Address:  s.cfg.AdvertiseAddr,
+		Draining: s.draining,
+	}, nil
+}
+
+// RequestVote gives our vote to a candidate if we have not voted for another one in its term yet
+// and its log has everything ours has. The vote is written to the term file before it is given.
+func (s *RMserver) RequestVote(ctx context.Context, req *Auction.VoteRequest) (*Auction.VoteReply, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// a replica that was removed (or not added yet) must not start a new term for the others
+	if !s.isMember(int(req.CandidateID)) {
+		return &Auction.VoteReply{Term: s.term, Granted: false}, nil
+	}
+	if req.Term < s.term {
+		return &Auction.VoteReply{Term: s.term, Granted: false}, nil
+	}
+	if req.Term > s.term {
+		// move to the new term even if we don't vote, so the candidate knows to catch up and try again
+		s.adoptTerm(req.Term)
+		s.stepDown(fmt.Sprintf("server %d started term %d", req.CandidateID, req.Term))
+	}
+	if s.votedFor != -1 && s.votedFor != int(req.CandidateID) {
+		return &Auction.VoteReply{Term: s.term, Granted: false}, nil
+	}
+	last := s.log.Last()
+	if req.LastTerm < last.Term || (req.LastTerm == last.Term && req.LastSeq < last.Seq) {
+		return &Auction.VoteReply{Term: s.term, Granted: false}, nil
+	}
+
+	s.votedFor = int(req.CandidateID)
+	if err := s.persistTerm(); err != nil {
+		// a vote we can't remember is not given
+		s.votedFor = -1
+		s.logger.Warn("could not write the term file, not voting", "for", req.CandidateID, "term", req.Term, "err", err)
+		return &Auction.VoteReply{Term: s.term, Granted: false}, nil
+	}
+	s.logger.Info("voted", "for", req.CandidateID, "term", req.Term)
+	return &Auction.VoteReply{Term: s.term, Granted: true}, nil
+}
+
+// FetchLog returns the records from a given seq. A new leader uses it to catch up.
+func (s *RMserver) FetchLog(ctx context.Context, req *Auction.FetchRequest) (*Auction.FetchReply, error) {
+	lastSeq := s.log.Len()
+	records := page(s.log.Page(req.FromSeq, pageRecords))
+	return &Auction.FetchReply{Records: toProtoRecords(records), LastSeq: lastSeq}, nil
+}
+
+// page cuts records down to what is sent in one message, at least one record.
+func page(records []audit.Record) []audit.Record {
+	size := 0
+	for i, r := range records {
+		// the hashes, numbers and field tags come to about 200 bytes
+		size += 200 + len(r.Kind) + len(r.ClientName) + len(r.Detail) + len(r.RequestID)
+		if i > 0 && size > pageBytes {
+			return records[:i]
+		}
+	}
+	return records
+}
+
+func toProtoRecords(records []audit.Record) []*Auction.Record {
+	out := make([]*Auction.Record, 0, len(records))
+	for _, r := range records {
+		out = append(out, &Auction.Record{
+			Seq:        r.Seq,
+			Term:       r.Term,
+			Time:       r.Time,
+			Kind:       r.Kind,
+			ClientID:   r.ClientID,
+			ClientName: r.ClientName,
+			Amount:     r.Amount,
+			Detail:     r.Detail,
+			RequestID:  r.RequestID,
+			PrevHash:   r.PrevHash,
+			Hash:       r.Hash,
+		})
+	}
+	return out
+}
+
+func fromProtoRecords(records []*Auction.Record) []audit.Record {
+	out := make([]audit.Record, 0, len(records))
+	for _, r := range records {
+		out = append(out, audit.Record{
+			Seq:        r.Seq,
+			Term:       r.Term,
+			Time:       r.Time,
+			Kind:       r.Kind,
+			ClientID:   r.ClientID,
+			ClientName: r.ClientName,
+			Amount:     r.Amount,
+			Detail:     r.Detail,
+			RequestID:  r.RequestID,
+			PrevHash:   r.PrevHash,
+			Hash:       r.Hash,
+		})
+	}
+	return out
+}
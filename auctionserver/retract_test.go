@@ -0,0 +1,73 @@
+package auctionserver_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+)
+
+func TestRetract(t *testing.T) {
+	tests := []struct {
+		name     string
+		opt      func(id int, cfg *auctionserver.Config)
+		bid      bool
+		wait     time.Duration
+		accepted bool
+		message  string
+	}{
+		{"within the window", nil, true, 0, true, "was taken back"},
+		{"no bid", nil, false, 0, false, "no bid to take back"},
+		{"turned off", func(id int, cfg *auctionserver.Config) { cfg.RetractWindow = 0 }, true, 0, false, "can only be taken back within"},
+		{"after the window", func(id int, cfg *auctionserver.Config) { cfg.RetractWindow = 200 * time.Millisecond }, true, 400 * time.Millisecond, false, "can only be taken back within"},
+		{"in the cutoff", func(id int, cfg *auctionserver.Config) { cfg.RetractCutoff = 2 * time.Hour }, true, 0, false, "can't be taken back in the last"},
+		{"after the close", func(id int, cfg *auctionserver.Config) { cfg.EndTime = time.Now().Add(time.Second) }, true, 1500 * time.Millisecond, false, "auction is over"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []func(int, *auctionserver.Config)
+			if tt.opt != nil {
+				opts = append(opts, tt.opt)
+			}
+			c := auctiontest.NewCluster(t, 1, opts...)
+			c.WaitForLeader()
+			ctx := context.Background()
+			alice := c.Client(1, "alice")
+			bob := c.Client(2, "bob")
+
+			if ack, err := alice.Bid(ctx, 10); err != nil || !ack.Accepted {
+				t.Fatalf("alice's bid: %v, %v", ack, err)
+			}
+			if tt.bid {
+				if ack, err := bob.Bid(ctx, 20); err != nil || !ack.Accepted {
+					t.Fatalf("bob's bid: %v, %v", ack, err)
+				}
+			}
+			time.Sleep(tt.wait)
+
+			ack, err := bob.Retract(ctx, "changed my mind")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ack.Accepted != tt.accepted || !strings.Contains(ack.Message, tt.message) {
+				t.Fatalf("Retract() = %v %q, want %v and %q", ack.Accepted, ack.Message, tt.accepted, tt.message)
+			}
+
+			// a retracted bid no longer counts, the one before it is the highest again
+			want := float32(20)
+			if !tt.bid || tt.accepted {
+				want = 10
+			}
+			outcome, err := alice.Result(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outcome.Amount != want {
+				t.Fatalf("the highest bid is %v, want %v", outcome.Amount, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,682 @@
+// Package auctionserver is the replicated auction server. Several replicas can run in one
+// process (see the auctiontest package), each with its own Config.
+//
+//	s, err := auctionserver.New(auctionserver.Config{ID: 0, ListenAddr: "localhost:8080", Peers: []string{":8080", ":8081", ":8082"}, ClusterToken: token, EndTime: end})
+//	if err != nil { ... }
+//	if err := s.Start(); err != nil { ... }
+//	defer s.Stop()
+package auctionserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+	"github.com/Alex-itu/A_Distributed_Auction_System/events"
+	"github.com/Alex-itu/A_Distributed_Auction_System/logging"
+	"github.com/Alex-itu/A_Distributed_Auction_System/payment"
+	Auction "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+	"github.com/Alex-itu/A_Distributed_Auction_System/tracing"
+	"github.com/Alex-itu/A_Distributed_Auction_System/webhook"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// Config is everything a replica needs to know. ID and EndTime are required.
+type Config struct {
+	ID    int
+	Peers []string // the addresses of all the replicas, the index is the id. Empty means this replica runs alone
+	// Join starts the replica outside of the cluster, Peers is not used. It waits for the leader
+	// to add it with AddReplica, see membership.go.
+	Join bool
+
+	// ListenAddr is where the server listens: host:port for TCP over IPv4 or IPv6, e.g. "0.0.0.0:8080"
+	// or "[::]:8080", or unix:/path for a Unix socket. It is not used if Listener is set.
+	ListenAddr string
+	// AdvertiseAddr is where the other replicas and the clients reach this replica, if that is not
+	// its address in Peers, e.g. when it listens on every interface or is behind NAT. The leader
+	// writes it into the members of the cluster, so they all pick it up.
+	AdvertiseAddr string
+	// Listener is used instead of listening on ListenAddr, e.g. a bufconn listener in tests.
+	Listener net.Listener
+	// Dialer is used to connect to the other replicas. Leave it nil to dial them over TCP.
+	Dialer func(ctx context.Context, addr string) (net.Conn, error)
+	// DialOptions are added to the options used to connect to the other replicas, e.g. interceptors.
+	DialOptions []grpc.DialOption
+	// TLS is what the server serves with. nil serves without TLS.
+	TLS *tls.Config
+	// PeerTLS is what the other replicas are dialed with. nil dials them without TLS.
+	PeerTLS *tls.Config
+
+	EndTime       time.Time     // when the auction closes
+	RetractWindow time.Duration // how long after making a bid the client can take it back, 0 turns retractions off
+	RetractCutoff time.Duration // no bids can be taken back when the auction ends within this long
+	AdminToken    string        // the token auctionctl has to send, empty turns the admin service off
+	ClusterToken  string        // what the replicas send each other, the same on all of them, see peerauth.go. Needed when there are other replicas
+	RateLimit     RateLimit     // how fast a bidder or an address can bid, see ratelimit.go. The zero value has no limit
+
+	// Payments checks that the buyer paid after the close, see settlement.go. nil turns payments off,
+	// nobody can pay then and every buyer defaults. The buyer confirms with a client certificate,
+	// so it also needs TLS with ClientCAs
+	Payments payment.Provider
+	// PaymentTimeout is how long the buyer has to pay before the item goes to the next bidder in line.
+	// 0 waits for as long as it takes.
+	PaymentTimeout time.Duration
+
+	// Webhooks are where the leader sends the events of the auction, see webhooks.go.
+	// Leave URLs empty to send nothing.
+	Webhooks webhook.Config
+
+	// AuditLogPath is the file the audit log is kept in. Default audit_server{ID}.log
+	AuditLogPath string
+
+	// Logger is where the replica logs to, every line gets the id of the replica. Default slog.Default()
+	Logger *slog.Logger
+
+	// TracerProvider makes the spans of the calls, the replication and the state changes.
+	// Default otel.GetTracerProvider(), which does nothing unless one has been set.
+	TracerProvider trace.TracerProvider
+
+	// MetricsAddr is where to serve the Prometheus metrics over HTTP at /metrics, e.g. "localhost:9080".
+	// Empty serves nothing, MetricsHandler can still be used to serve them somewhere else.
+	MetricsAddr string
+}
+
+type RMserver struct {
+	Auction.UnimplementedAuctionServiceServer            //need this if it's a server
+	Auction.UnimplementedReplicationServiceServer        // the replicas talk to each other through this
+	Auction.UnimplementedAuctionAdminServer              // for the operators, see admin.go
+	Id                                            int
+	cfg                                           Config
+	logger                                        *slog.Logger // has the replica id, use logFor to get one with the request id too
+
+	mutex       sync.Mutex // used to lock the server to avoid race conditions.
+	commitMutex sync.Mutex // held while checking and committing a new record, so only one goes through at a time
+
+	// the auction state, folded from the events of the committed records, with the budgets and the
+	// settlement. It is only changed by apply, so every replica ends up with the same state, see events.go
+	auction *events.Auction
+	events  []events.Event // every event in the audit log, oldest first, for ReplayEvents
+
+	// the idempotency keys of the bids, see idempotency.go
+	bidKeys         map[bidKey]float32        // the committed bids and their amounts, from the audit log
+	rejectedAnswers map[bidKey]rejectedAnswer // what the rejected bids were told, only on the leader
+	rejectedOrder   []bidKey                  // the keys of rejectedAnswers, oldest first
+
+	// outbound webhooks, see webhooks.go
+	webhooks      *webhook.Sender  // nil when none are configured
+	outbox        []pendingEvent   // the events of the applied records that not every URL took yet, oldest first
+	deliveredSeqs map[string]int64 // by URL, the events of the records up to this one were taken
+
+	// replication, see replication.go
+	log        *audit.Log
+	peers      []*peer // the other members and the learners, only replaced as a whole by connectToPeers
+	peerOpts   []grpc.DialOption
+	members    map[int]string // the replicas by id, from the last members record in the log
+	membersSeq int64          // the record members is from, 0 if it is from the config
+	learners   map[int]string // replicas that are being added or removed, they get the records but don't vote
+	term       int64
+	votedFor   int
+	leaderID   int
+	isLeader   bool
+	ready      bool // the leader has caught up with the other replicas and can take bids
+	commitSeq  int64
+	appliedSeq int64
+	lastInSync time.Time // when this replica last took records from the leader
+
+	// closed and replaced every time a peer confirms that we still lead, see readBarrier
+	confirmations chan struct{}
+
+	// health, see health.go
+	health  *health.Server
+	serving bool
+
+	watchers map[chan struct{}]bool // the open Watch streams, and the ReplayEvents streams that follow
+
+	// the last BidRejected events, only on the leader, see events.go
+	rejections     []events.Event
+	rejectionCount int64                  // how many there have been, also the ones that were dropped
+	replays        map[chan struct{}]bool // the ReplayEvents streams that follow, woken for a rejection
+
+	limits      *rateLimiter // see ratelimit.go
+	metrics     *metrics     // see metrics.go
+	metricsHTTP *http.Server
+	tracer      trace.Tracer
+
+	grpcServer *grpc.Server
+	stop       chan struct{} // closed by Stop to end the background loops
+	drain      chan struct{} // closed by Shutdown to end the Watch streams, see shutdown.go
+	draining   bool          // set by Shutdown
+	served     chan error    // gets the result of grpcServer.Serve
+}
+
+// New makes a replica and opens its audit log, which is also what it recovers its bids from
+// after a restart. Nothing runs until Start is called.
+func New(cfg Config) (*RMserver, error) {
+	if cfg.Listener == nil && cfg.ListenAddr == "" {
+		return nil, errors.New("auctionserver: a ListenAddr or Listener is needed")
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.TracerProvider == nil {
+		cfg.TracerProvider = otel.GetTracerProvider()
+	}
+	if cfg.AuditLogPath == "" {
+		cfg.AuditLogPath = "audit_server" + fmt.Sprint(cfg.ID) + ".log"
+	}
+
+	if cfg.ClusterToken == "" {
+		if len(cfg.Peers) > 1 || cfg.Join {
+			return nil, errors.New("auctionserver: a ClusterToken is needed when there are other replicas")
+		}
+		cfg.ClusterToken = randomClusterToken()
+	}
+
+	var webhooks *webhook.Sender
+	if len(cfg.Webhooks.URLs) > 0 {
+		if cfg.Webhooks.Logger == nil {
+			cfg.Webhooks.Logger = cfg.Logger.With("replica", cfg.ID)
+		}
+		var err error
+		if webhooks, err = webhook.New(cfg.Webhooks); err != nil {
+			return nil, fmt.Errorf("auctionserver: %v", err)
+		}
+	}
+
+	auditLog, err := audit.Open(cfg.AuditLogPath)
+	if err != nil {
+		return nil, fmt.Errorf("auctionserver: failed to open the audit log: %v", err)
+	}
+	voted, err := loadTerm(termPath(cfg.AuditLogPath))
+	if err != nil {
+		auditLog.Close()
+		return nil, fmt.Errorf("auctionserver: failed to read the term file: %v", err)
+	}
+	// a log from before there were term files can be in a newer term than the file, we have not voted in it then
+	if last := auditLog.Last().Term; last > voted.Term {
+		voted = termState{Term: last, VotedFor: -1}
+	}
+	// a replica that was added while the cluster ran is not in Peers, its log says which replicas there are
+	if !cfg.Join && len(cfg.Peers) > 0 && (cfg.ID < 0 || cfg.ID >= len(cfg.Peers)) && !hasMembers(auditLog) {
+		auditLog.Close()
+		return nil, fmt.Errorf("auctionserver: id %d is not in the list of %d peers", cfg.ID, len(cfg.Peers))
+	}
+
+	s := &RMserver{
+		Id:              cfg.ID,
+		cfg:             cfg,
+		logger:          cfg.Logger.With("replica", cfg.ID),
+		auction:         events.NewAuction(cfg.EndTime),
+		bidKeys:         make(map[bidKey]float32),
+		rejectedAnswers: make(map[bidKey]rejectedAnswer),
+		webhooks:        webhooks,
+		deliveredSeqs:   make(map[string]int64),
+		log:             auditLog,
+		term:            voted.Term,
+		votedFor:        voted.VotedFor,
+		leaderID:        -1,
+		learners:        make(map[int]string),
+		health:          health.NewServer(),
+		watchers:        make(map[chan struct{}]bool),
+		replays:         make(map[chan struct{}]bool),
+		limits:          newRateLimiter(cfg.RateLimit),
+		stop:            make(chan struct{}),
+		drain:           make(chan struct{}),
+		served:          make(chan error, 1),
+		tracer:          cfg.TracerProvider.Tracer(tracing.InstrumentationName + "/auctionserver"),
+	}
+	s.confirmations = make(chan struct{})
+	s.metrics = newMetrics(s)
+	// the health service says SERVING by default, but we are not ready until we have found the leader
+	for _, service := range healthServices {
+		s.health.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	return s, nil
+}
+
+// Start listens, connects to the other replicas and starts serving in the background.
+func (s *RMserver) Start() error {
+	lis := s.cfg.Listener
+	if lis == nil {
+		s.logger.Info("creating listener", "addr", s.cfg.ListenAddr)
+
+		// Create listener for the RMserver connection
+		var err error
+		lis, err = listen(s.cfg.ListenAddr)
+		if err != nil {
+			s.logger.Error("failed to listen", "addr", s.cfg.ListenAddr, "err", err)
+			return err
+		}
+	}
+
+	// makes gRPC server using the options
+	// you can add options here if you want or remove the options part entirely
+	// the logging interceptor goes first, so the spans get the request id
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			logging.UnaryServerInterceptor(s.logger),
+			tracing.UnaryServerInterceptor(s.cfg.TracerProvider, s.replicaAttr()),
+			s.metrics.unaryInterceptor,
+			s.peerAuthInterceptor,
+			s.rateLimitInterceptor,
+		),
+		grpc.ChainStreamInterceptor(
+			logging.StreamServerInterceptor(s.logger),
+			tracing.StreamServerInterceptor(s.cfg.TracerProvider, s.replicaAttr()),
+		),
+	}
+	if s.cfg.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.cfg.TLS)))
+	}
+	s.grpcServer = grpc.NewServer(opts...)
+
+	Auction.RegisterAuctionServiceServer(s.grpcServer, s) //Registers the server to the gRPC server.
+	Auction.RegisterReplicationServiceServer(s.grpcServer, s)
+	Auction.RegisterAuctionAdminServer(s.grpcServer, s)
+	healthpb.RegisterHealthServer(s.grpcServer, healthService{s.health, s.drain})
+	// reflection lets generic tools like grpcurl list and call the services without the proto file
+	reflection.Register(s.grpcServer)
+
+	if s.cfg.MetricsAddr != "" {
+		metricsLis, err := net.Listen("tcp", s.cfg.MetricsAddr)
+		if err != nil {
+			s.logger.Error("failed to listen for metrics", "addr", s.cfg.MetricsAddr, "err", err)
+			lis.Close()
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.MetricsHandler())
+		s.metricsHTTP = &http.Server{Handler: mux}
+		go s.metricsHTTP.Serve(metricsLis)
+		s.logger.Info("serving metrics", "url", fmt.Sprintf("http://%v/metrics", metricsLis.Addr()))
+	}
+
+	s.mutex.Lock()
+	s.peerOpts = s.peerDialOptions()
+	s.loadMembers()
+	s.mutex.Unlock()
+	go s.pingLoop()
+	go s.Timeout()
+	go s.settleLoop()
+	if s.webhooks != nil {
+		go s.webhookLoop()
+	}
+
+	s.logger.Info("listening", "addr", lis.Addr().String())
+	go func() {
+		s.served <- s.grpcServer.Serve(lis)
+	}()
+	return nil
+}
+
+// Wait blocks until the server stops serving and returns why.
+func (s *RMserver) Wait() error {
+	err := <-s.served
+	s.served <- err // so Wait can be called again
+	return err
+}
+
+// Stop stops the server right away, closes the connections to the other replicas and the audit log.
+func (s *RMserver) Stop() {
+	select {
+	case <-s.stop:
+		return // already stopped
+	default:
+	}
+	close(s.stop)
+	// so the calls that are still running give up instead of waiting for their commits
+	s.mutex.Lock()
+	s.stepDown("stopping")
+	s.mutex.Unlock()
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+	if s.metricsHTTP != nil {
+		s.metricsHTTP.Close()
+	}
+	for _, p := range s.peerList() {
+		p.conn.Close()
+	}
+	s.commitMutex.Lock()
+	s.log.Close()
+	s.commitMutex.Unlock()
+}
+
+// listen listens on addr, which is host:port for TCP (e.g. [::1]:8080) or a Unix socket as
+// unix:/path or unix:///path, the way gRPC dials them.
+func listen(addr string) (net.Listener, error) {
+	path, ok := unixPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	// a replica that was killed leaves its socket file behind, which would make the listen fail.
+	// Only remove it if nobody answers on it
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+		} else {
+			os.Remove(path)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// unixPath returns the path of a unix: address.
+func unixPath(addr string) (string, bool) {
+	for _, prefix := range []string{"unix://", "unix:"} {
+		if strings.HasPrefix(addr, prefix) {
+			return addr[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// logFor returns the logger of the replica with the request id of the call added.
+func (s *RMserver) logFor(ctx context.Context) *slog.Logger {
+	return logging.FromContext(ctx, s.logger)
+}
+
+// replicaAttr is put on every span of this replica, so it can be told apart from the others.
+func (s *RMserver) replicaAttr() attribute.KeyValue {
+	return attribute.Int("auction.replica", s.Id)
+}
+
+// stopped reports if Stop has been called.
+func (s *RMserver) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Leading reports if this replica is the leader and can take bids.
+func (s *RMserver) Leading() bool {
+	return s.leading()
+}
+
+// Timeout closes the auction when the end time has passed.
+func (s *RMserver) Timeout() {
+	// Only the leader can close the auction. If the leader dies before it gets to it,
+	// whoever leads next closes it, so we keep checking until the close is committed.
+	// The end time is checked every round, because an admin can move it while we wait.
+	for !s.stopped() {
+		s.mutex.Lock()
+		over := s.auction.Over
+		ends := s.auction.EndTime
+		s.mutex.Unlock()
+		if over {
+			return
+		}
+		if time.Now().After(ends) && s.leading() {
+			s.commitMutex.Lock()
+			_, err := s.commit(context.Background(), audit.Record{Kind: audit.KindClose})
+			s.commitMutex.Unlock()
+			if err == nil {
+				s.logger.Info("closed the auction at its end time")
+				return
+			}
+		}
+		time.Sleep(pingInterval)
+	}
+}
+
+func (s *RMserver) Bid(cxt context.Context, msg *Auction.BidAmount) (*Auction.Ack, error) {
+	span := trace.SpanFromContext(cxt)
+	span.SetAttributes(attribute.Int("auction.client", int(msg.ClientID)), attribute.Float64("auction.amount", float64(msg.Amount)))
+
+	if err := s.lockCommit(cxt); err != nil {
+		return nil, err
+	}
+	defer s.commitMutex.Unlock()
+
+	s.mutex.Lock()
+	// a retry gets the answer of the first try, whatever happened since
+	if ack, err := s.earlierAnswer(msg); ack != nil || err != nil {
+		s.mutex.Unlock()
+		if err != nil {
+			s.logFor(cxt).Warn("bid reused an idempotency key", "client", msg.ClientID, "amount", msg.Amount, "err", err)
+			return nil, err
+		}
+		s.metrics.retried()
+		s.logFor(cxt).Info("bid was already answered", "client", msg.ClientID, "amount", msg.Amount, "accepted", ack.Accepted)
+		return ack, nil
+	}
+	maxid, max := s.HighestBid()
+	maxName := s.auction.Names[maxid]
+	over := s.auction.Over || time.Now().After(s.auction.EndTime)
+	isCancelled := s.auction.Cancelled
+	isBanned := s.auction.Banned[msg.ClientID]
+	overBudget, budget := s.overBudget(msg.ClientID, msg.Amount)
+	leading := s.isLeader && s.ready
+	leader := s.leaderID
+	s.mutex.Unlock()
+
+	if isCancelled {
+		return s.rejectBid(cxt, msg, rejectCancelled, &Auction.Ack{Message: "The auction was cancelled", ClientID: msg.ClientID}), nil
+	}
+	if over {
+		return s.rejectBid(cxt, msg, rejectOver, &Auction.Ack{Message: "The auction is over. The winner is " + maxName + " with a bid of " + fmt.Sprint(max), ClientID: maxid}), nil
+	}
+	if !leading {
+		// Unavailable tells the client to try another server
+		s.rejected(cxt, rejectNotLeader)
+		return nil, status.Errorf(codes.Unavailable, "server %d is not the leader, the leader is server %d", s.Id, leader)
+	}
+	if isBanned {
+		return s.rejectBid(cxt, msg, rejectBanned, &Auction.Ack{Message: "You are banned from this auction", ClientID: msg.ClientID}), nil
+	}
+	if msg.GetAmount() > max && overBudget {
+		s.logFor(cxt).Debug("bid over budget", "client", msg.ClientID, "amount", msg.Amount, "budget", budget)
+		return s.rejectBid(cxt, msg, rejectOverBudget, &Auction.Ack{Message: "Bid is over your budget of " + fmt.Sprint(budget), ClientID: msg.ClientID}), nil
+	}
+	if msg.GetAmount() > max { 
+		// the bid only counts once a majority of the replicas has it in their audit log
+		_, err := s.commit(cxt, audit.Record{Kind: audit.KindBid, ClientID: msg.ClientID, ClientName: msg.ClientName, Amount: msg.Amount, RequestID: msg.IdempotencyKey})
+		if err != nil {
+			s.logFor(cxt).Warn("bid was not committed", "client", msg.ClientID, "amount", msg.Amount, "err", err)
+			s.rejected(cxt, rejectNotCommitted)
+			return nil, err
+		}
+		s.metrics.accepted()
+		span.SetAttributes(attribute.Bool("auction.accepted", true))
+		s.logFor(cxt).Info("bid accepted", "client", msg.ClientID, "name", msg.ClientName, "amount", msg.Amount)
+		return s.acceptedAck(msg.ClientID), nil
+	} else {
+		s.logFor(cxt).Debug("bid too low", "client", msg.ClientID, "amount", msg.Amount, "highest", max)
+		return s.rejectBid(cxt, msg, rejectTooLow, &Auction.Ack{Message: "Bid is lower than current highest bid: " + fmt.Sprint(max), ClientID: msg.ClientID}), nil
+	} 
+}
+
+// acceptedAck is the answer to a bid that went through.
+func (s *RMserver) acceptedAck(clientID int32) *Auction.Ack {
+	return &Auction.Ack{Message: "Nice job team from: server " + fmt.Sprint(s.Id), ClientID: clientID, Accepted: true}
+}
+
+// Result returns the highest bid, or the winner when the auction is over. Only the leader answers,
+// once it has made sure it still leads, so the result is never older than a bid that was accepted.
+func (s *RMserver) Result(cxt context.Context, msg *Auction.Void) (*Auction.Outcome, error) {
+	if err := s.readBarrier(cxt); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.outcome(), nil
+}
+
+// Watch sends the outcome right away and then again every time a committed record changes it,
+// until the client goes away or the server shuts down.
+func (s *RMserver) Watch(msg *Auction.Void, stream Auction.AuctionService_WatchServer) error {
+	changed := make(chan struct{}, 1)
+	s.mutex.Lock()
+	s.watchers[changed] = true
+	current := s.outcome()
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.watchers, changed)
+		s.mutex.Unlock()
+	}()
+
+	for {
+		if err := stream.Send(current); err != nil {
+			return err
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return nil
+		case <-s.drain:
+			return status.Errorf(codes.Unavailable, "server %d is shutting down", s.Id)
+		}
+		s.mutex.Lock()
+		current = s.outcome()
+		s.mutex.Unlock()
+	}
+}
+
+// notifyWatchers wakes up every Watch stream. A stream that is still busy sending
+// already has a wake up waiting, so it picks up the newest outcome when it is done.
+// The caller must hold s.mutex.
+func (s *RMserver) notifyWatchers() {
+	for changed := range s.watchers {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// outcome returns the highest bid, and whether the auction is over. The caller must hold the server's mutex.
+func (s *RMserver) outcome() *Auction.Outcome {
+	if s.auction.Cancelled {
+		return &Auction.Outcome{Amount: -1, BidDone: true, Cancelled: true}
+	}
+	maxid, max := s.HighestBid()	
+	if s.auction.Over {
+		return &Auction.Outcome{Amount: max, ClientName: s.auction.Names[maxid], BidDone: true, Settlement: s.settlementState()}
+	} else {
+		return &Auction.Outcome{Amount: max, ClientName: s.auction.Names[maxid], BidDone: false}
+	}
+}
+
+func (s *RMserver) RetractBid(cxt context.Context, msg *Auction.Retraction) (*Auction.Ack, error) {
+	if err := s.lockCommit(cxt); err != nil {
+		return nil, err
+	}
+	defer s.commitMutex.Unlock()
+
+	s.mutex.Lock()
+	bid, hasBid := s.auction.Bids[msg.ClientID]
+	amount, madeAt := bid.Amount, bid.Time
+	over := s.auction.Over || time.Now().After(s.auction.EndTime)
+	ends := s.auction.EndTime
+	leading := s.isLeader && s.ready
+	leader := s.leaderID
+	s.mutex.Unlock()
+
+	if !leading {
+		// Unavailable tells the client to try another server
+		return nil, status.Errorf(codes.Unavailable, "server %d is not the leader, the leader is server %d", s.Id, leader)
+	}
+	if over {
+		return &Auction.Ack{Message: "The auction is over, bids can no longer be taken back", ClientID: msg.ClientID}, nil
+	}
+	if !hasBid {
+		return &Auction.Ack{Message: "You have no bid to take back", ClientID: msg.ClientID}, nil
+	}
+	if s.cfg.RetractWindow <= 0 || time.Since(madeAt) > s.cfg.RetractWindow {
+		return &Auction.Ack{Message: "Your bid can only be taken back within " + s.cfg.RetractWindow.String() + " of making it", ClientID: msg.ClientID}, nil
+	}
+	if time.Until(ends) <= s.cfg.RetractCutoff {
+		return &Auction.Ack{Message: "Bids can't be taken back in the last " + s.cfg.RetractCutoff.String() + " of the auction", ClientID: msg.ClientID}, nil
+	}
+
+	_, err := s.commit(cxt, audit.Record{Kind: audit.KindRetract, ClientID: msg.ClientID, ClientName: msg.ClientName, Amount: amount, Detail: msg.Reason})
+	if err != nil {
+		s.logFor(cxt).Warn("retraction was not committed", "client", msg.ClientID, "err", err)
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	maxid, max := s.HighestBid()
+	maxName := s.auction.Names[maxid]
+	s.mutex.Unlock()
+	s.logFor(cxt).Info("bid retracted", "client", msg.ClientID, "name", msg.ClientName, "amount", amount, "reason", msg.Reason)
+	if maxid == -1 {
+		return &Auction.Ack{Message: "Your bid of " + fmt.Sprint(amount) + " was taken back. There are no bids left", ClientID: msg.ClientID, Accepted: true}, nil
+	}
+	return &Auction.Ack{Message: "Your bid of " + fmt.Sprint(amount) + " was taken back. The highest bid is now " + fmt.Sprint(max) + " by " + maxName, ClientID: msg.ClientID, Accepted: true}, nil
+}
+
+// rejectBid turns down a bid with ack and keeps the answer for its retries.
+// The caller must hold s.commitMutex.
+func (s *RMserver) rejectBid(ctx context.Context, msg *Auction.BidAmount, reason string, ack *Auction.Ack) *Auction.Ack {
+	s.rejected(ctx, reason)
+	s.recordRejection(msg, reason)
+	s.rememberRejection(msg, ack)
+	return ack
+}
+
+// rejected counts a turned down bid and says why on the span of the call.
+func (s *RMserver) rejected(ctx context.Context, reason string) {
+	s.metrics.rejected(reason)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("auction.rejected", reason))
+}
+
+// apply changes the auction state according to a committed record from the audit log: the event
+// in it is folded into the auction and kept for ReplayEvents, and what the replica keeps next to
+// the auction (budgets, the settlement, webhooks) is updated. The caller must hold the server's mutex.
+func (s *RMserver) apply(r audit.Record) {
+	// a bid that was retried before its first try was committed can be in the log twice, only the first counts
+	if r.Kind == audit.KindBid && r.RequestID != "" {
+		k := bidKey{r.ClientID, r.RequestID}
+		if _, ok := s.bidKeys[k]; ok {
+			return
+		}
+		s.bidKeys[k] = r.Amount
+	}
+	if e, ok := events.FromRecord(r); ok {
+		if r.Kind == audit.KindBid {
+			// the webhooks need the highest bid from before the bid
+			s.bidEvents(r)
+		}
+		s.auction.Apply(e)
+		s.events = append(s.events, e)
+	}
+
+	switch r.Kind {
+	case audit.KindClose, audit.KindCancel:
+		s.closeEvent(r)
+	case audit.KindConfig:
+		s.leaderEvent(r)
+	case audit.KindDelivered:
+		s.delivered(r)
+	}
+}
+
+// HighestBid returns the bidder with the highest bid and the bid, or -1 and -1 if there is none.
+// The caller must hold the server's mutex.
+func (s *RMserver) HighestBid() (int32, float32) {
+	return s.auction.Highest()
+}
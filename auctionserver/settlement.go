@@ -0,0 +1,163 @@
+package auctionserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+	"github.com/Alex-itu/A_Distributed_Auction_System/payment"
+	Auction "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// When the auction closes the winner has to pay, which they confirm with ConfirmPayment:
+//
+//	AWAITING_PAYMENT -> PAID
+//	                 -> DEFAULTED -> OFFERED_TO_RUNNER_UP -> PAID
+//	                                                      -> DEFAULTED -> ...
+//
+// Only the buyer can confirm a payment: the call has to come with a client certificate the server
+// verified, whose common name is the name the buyer bid with, so payments need Config.TLS with
+// ClientCAs. The clientID in the request is only what the client says. The payment is then checked with Config.Payments before it is written down,
+// and a server without a payment provider turns every payment down. A buyer that has not paid
+// within Config.PaymentTimeout defaults, and the item is offered to the highest bidder that has not
+// defaulted yet, for their own bid. When nobody is left the settlement stays DEFAULTED.
+// The leader writes every step to the audit log, so the replicas all get it and a new leader
+// carries on where the old one stopped. The steps are events, and the settlement is folded from
+// them with the rest of the auction (see events.Auction). Only the deadline is worked out here,
+// from Config.PaymentTimeout, which is not in the log: it starts at the time of the record that
+// made the buyer the buyer, so the replicas agree on it as long as they have the same PaymentTimeout.
+
+// payBy is when the buyer's time to pay is up, zero if there is no deadline. The caller must hold s.mutex.
+func (s *RMserver) payBy() time.Time {
+	if s.cfg.PaymentTimeout <= 0 || !s.auction.AwaitingPayment() {
+		return time.Time{}
+	}
+	return s.auction.BuyerSince.Add(s.cfg.PaymentTimeout)
+}
+
+// settlementState is the settlement as it is sent to the clients. The caller must hold s.mutex.
+func (s *RMserver) settlementState() *Auction.SettlementState {
+	a := s.auction
+	if a.Settlement == Auction.Settlement_NONE {
+		return nil
+	}
+	state := &Auction.SettlementState{State: a.Settlement, ClientID: a.Buyer, ClientName: a.Names[a.Buyer], Price: a.Price}
+	if payBy := s.payBy(); !payBy.IsZero() {
+		state.PayBy = payBy.Unix()
+	}
+	return state
+}
+
+// ConfirmPayment is called by the buyer once they have paid. The caller has to show the buyer's
+// client certificate, and the payment is checked with the payment provider and only written down
+// as paid if it says so.
+func (s *RMserver) ConfirmPayment(cxt context.Context, msg *Auction.PaymentConfirmation) (*Auction.Ack, error) {
+	if s.cfg.Payments == nil {
+		return nil, status.Error(codes.FailedPrecondition, "this auction has no payment provider, payments can't be confirmed")
+	}
+	if s.cfg.TLS == nil || s.cfg.TLS.ClientCAs == nil {
+		// no certificate is ever verified then, so nobody can show they are the buyer
+		return nil, status.Error(codes.FailedPrecondition, "this server does not verify client certificates, so nobody can confirm a payment: start it with TLS and a client CA (-tlsCert, -tlsKey and -tlsCA)")
+	}
+	caller := certName(cxt)
+	if caller == "" {
+		return nil, status.Error(codes.Unauthenticated, "only the buyer can confirm a payment, call with their client certificate")
+	}
+	if err := s.lockCommit(cxt); err != nil {
+		return nil, err
+	}
+	defer s.commitMutex.Unlock()
+
+	s.mutex.Lock()
+	leading := s.isLeader && s.ready
+	leader := s.leaderID
+	over := s.auction.Over
+	cancelled := s.auction.Cancelled
+	settlement := s.auction.Settlement
+	awaiting := s.auction.AwaitingPayment()
+	buyer := s.auction.Buyer
+	name := s.auction.Names[buyer]
+	price := s.auction.Price
+	payBy := s.payBy()
+	s.mutex.Unlock()
+	isBuyer := buyer == msg.ClientID && caller == name
+
+	if !leading {
+		// Unavailable tells the client to try another server
+		return nil, status.Errorf(codes.Unavailable, "server %d is not the leader, the leader is server %d", s.Id, leader)
+	}
+	switch {
+	case cancelled:
+		return &Auction.Ack{Message: "The auction was cancelled, there is nothing to pay for", ClientID: msg.ClientID}, nil
+	case !over:
+		return &Auction.Ack{Message: "The auction is not over yet", ClientID: msg.ClientID}, nil
+	case settlement == Auction.Settlement_PAID && isBuyer:
+		// a retry of a payment that went through
+		return &Auction.Ack{Message: "You have already paid, the item is yours", ClientID: msg.ClientID, Accepted: true}, nil
+	case !awaiting || !isBuyer:
+		return &Auction.Ack{Message: "You have nothing to pay for", ClientID: msg.ClientID}, nil
+	case !payBy.IsZero() && time.Now().After(payBy):
+		return &Auction.Ack{Message: "Your time to pay was up at " + payBy.Format(time.DateTime), ClientID: msg.ClientID}, nil
+	}
+
+	p := payment.Payment{ClientID: buyer, ClientName: name, Amount: price, Reference: msg.Reference}
+	if err := s.cfg.Payments.Confirm(cxt, p); err != nil {
+		s.logFor(cxt).Warn("payment was not confirmed", "client", msg.ClientID, "amount", price, "reference", msg.Reference, "err", err)
+		return &Auction.Ack{Message: "Your payment could not be confirmed: " + err.Error(), ClientID: msg.ClientID}, nil
+	}
+	_, err := s.commit(cxt, audit.Record{Kind: audit.KindPaid, ClientID: buyer, ClientName: name, Amount: price, Detail: msg.Reference})
+	if err != nil {
+		s.logFor(cxt).Warn("payment was not committed", "client", msg.ClientID, "err", err)
+		return nil, err
+	}
+	s.logFor(cxt).Info("payment confirmed", "client", buyer, "name", name, "amount", price, "reference", msg.Reference)
+	return &Auction.Ack{Message: "Your payment of " + fmt.Sprint(price) + " is confirmed, the item is yours", ClientID: msg.ClientID, Accepted: true}, nil
+}
+
+// settleLoop lets the buyer default when their time to pay is up and offers the item to the
+// next bidder in line. Only the leader does it, the others get its records.
+func (s *RMserver) settleLoop() {
+	for !s.stopped() {
+		time.Sleep(pingInterval)
+		if s.cfg.PaymentTimeout > 0 && s.leading() {
+			s.settle()
+		}
+	}
+}
+
+// settle takes the next step of the settlement if one is due. A leader that went down between
+// the two steps leaves the settlement DEFAULTED, and the next leader makes the offer.
+func (s *RMserver) settle() {
+	s.commitMutex.Lock()
+	defer s.commitMutex.Unlock()
+
+	s.mutex.Lock()
+	payBy := s.payBy()
+	due := s.auction.AwaitingPayment() && time.Now().After(payBy)
+	buyer, price := s.auction.Buyer, s.auction.Price
+	name := s.auction.Names[buyer]
+	s.mutex.Unlock()
+	if due {
+		_, err := s.commit(context.Background(), audit.Record{Kind: audit.KindDefaulted, ClientID: buyer, ClientName: name, Amount: price, Detail: "did not pay by " + payBy.Format(time.DateTime)})
+		if err != nil {
+			return
+		}
+		s.logger.Info("buyer did not pay in time", "client", buyer, "name", name, "amount", price)
+	}
+
+	s.mutex.Lock()
+	next, bid := s.auction.RunnerUp()
+	name = s.auction.Names[next]
+	defaulted := s.auction.Settlement == Auction.Settlement_DEFAULTED
+	s.mutex.Unlock()
+	if !defaulted || next < 0 {
+		return
+	}
+	if _, err := s.commit(context.Background(), audit.Record{Kind: audit.KindOffered, ClientID: next, ClientName: name, Amount: bid}); err == nil {
+		s.logger.Info("offered the item to the next bidder", "client", next, "name", name, "amount", bid)
+	}
+}
@@ -0,0 +1,145 @@
+package auctionserver_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	"github.com/Alex-itu/A_Distributed_Auction_System/payment"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// withCert is ctx as a call made with a verified client certificate of that common name.
+func withCert(ctx context.Context, name string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+	info := credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}
+	return peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, AuthInfo: info})
+}
+
+// clientCATLS is a server's TLS that verifies client certificates. The calls are made on the
+// server with withCert, so nothing is served with it.
+func clientCATLS() *tls.Config {
+	return &tls.Config{ClientCAs: x509.NewCertPool(), ClientAuth: tls.VerifyClientCertIfGiven}
+}
+
+func TestSettlement(t *testing.T) {
+	fake := payment.NewFake()
+	fake.Decline("declined")
+	c := auctiontest.NewCluster(t, 1, func(id int, cfg *auctionserver.Config) {
+		cfg.EndTime = time.Now().Add(time.Second)
+		cfg.PaymentTimeout = time.Second
+		cfg.Payments = fake
+		cfg.TLS = clientCATLS()
+	})
+	server := c.Server(c.WaitForLeader())
+	ctx := context.Background()
+	for _, b := range []struct {
+		client int32
+		name   string
+		amount float32
+	}{{1, "alice", 10}, {3, "carol", 15}, {2, "bob", 20}} {
+		if ack, err := server.Bid(ctx, &gRPC.BidAmount{ClientID: b.client, ClientName: b.name, Amount: b.amount}); err != nil || !ack.Accepted {
+			t.Fatalf("%s's bid: %v, %v", b.name, ack, err)
+		}
+	}
+
+	settlement := func() *gRPC.SettlementState {
+		t.Helper()
+		outcome, err := server.Result(ctx, &gRPC.Void{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return outcome.Settlement
+	}
+	waitFor := func(state gRPC.Settlement, buyer string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			s := settlement()
+			if s.GetState() == state && s.GetClientName() == buyer {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("the settlement is %v, want %v with buyer %s", s, state, buyer)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	pay := func(ctx context.Context, client int32, name, reference string) (*gRPC.Ack, error) {
+		return server.ConfirmPayment(ctx, &gRPC.PaymentConfirmation{ClientID: client, ClientName: name, Reference: reference})
+	}
+	mustPay := func(ctx context.Context, client int32, name, reference string, accepted bool) {
+		t.Helper()
+		ack, err := pay(ctx, client, name, reference)
+		if err != nil {
+			t.Fatalf("%s paying with %q: %v", name, reference, err)
+		}
+		if ack.Accepted != accepted {
+			t.Fatalf("%s paying with %q: accepted is %v, want %v (%s)", name, reference, ack.Accepted, accepted, ack.Message)
+		}
+	}
+
+	if s := settlement(); s != nil {
+		t.Fatalf("there is a settlement before the close: %v", s)
+	}
+	mustPay(withCert(ctx, "bob"), 2, "bob", "early", false)
+
+	waitFor(gRPC.Settlement_AWAITING_PAYMENT, "bob")
+	if s := settlement(); s.ClientID != 2 || s.Price != 20 || s.PayBy == 0 {
+		t.Fatalf("the winner has to pay: %v", s)
+	}
+	// only the buyer can pay, and they have to show who they are
+	if _, err := pay(ctx, 2, "bob", "no-cert"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("paying without a certificate: %v, want Unauthenticated", err)
+	}
+	mustPay(withCert(ctx, "alice"), 2, "bob", "not-bob", false)
+	mustPay(withCert(ctx, "alice"), 1, "alice", "not-the-buyer", false)
+	// a payment the provider turns down changes nothing
+	mustPay(withCert(ctx, "bob"), 2, "bob", "declined", false)
+	waitFor(gRPC.Settlement_AWAITING_PAYMENT, "bob")
+
+	// bob does not pay in time, so the item goes to carol, who bid the most after him
+	waitFor(gRPC.Settlement_OFFERED_TO_RUNNER_UP, "carol")
+	if s := settlement(); s.ClientID != 3 || s.Price != 15 {
+		t.Fatalf("the item is offered to carol: %v", s)
+	}
+	mustPay(withCert(ctx, "bob"), 2, "bob", "too-late", false)
+	mustPay(withCert(ctx, "carol"), 3, "carol", "paid", true)
+	waitFor(gRPC.Settlement_PAID, "carol")
+	// a retry is told it went through, and is not paid twice
+	mustPay(withCert(ctx, "carol"), 3, "carol", "paid", true)
+	if paid := fake.Payments(); len(paid) != 1 || paid[0].ClientID != 3 || paid[0].Amount != 15 {
+		t.Fatalf("the provider confirmed %+v, want one payment of 15 by carol", paid)
+	}
+}
+
+func TestSettlementWithoutProvider(t *testing.T) {
+	c := auctiontest.NewCluster(t, 1)
+	server := c.Server(c.WaitForLeader())
+	_, err := server.ConfirmPayment(withCert(context.Background(), "alice"), &gRPC.PaymentConfirmation{ClientID: 1, ClientName: "alice", Reference: "paid"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("paying without a payment provider: %v, want FailedPrecondition", err)
+	}
+}
+
+func TestPaymentsNeedClientCertificates(t *testing.T) {
+	c := auctiontest.NewCluster(t, 1, func(id int, cfg *auctionserver.Config) { cfg.Payments = payment.NewFake() })
+	server := c.Server(c.WaitForLeader())
+	// even a certificate can't be verified by a server without a client CA
+	_, err := server.ConfirmPayment(withCert(context.Background(), "alice"), &gRPC.PaymentConfirmation{ClientID: 1, ClientName: "alice", Reference: "paid"})
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "-tlsCA") {
+		t.Fatalf("paying on a server without a client CA: %v, want FailedPrecondition that says what it needs", err)
+	}
+}
@@ -0,0 +1,95 @@
+package auctionserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// handOffTimeout is how long a leader that shuts down waits for another replica to take over.
+const handOffTimeout = 3 * time.Second
+
+// Shutdown stops the replica without cutting off the calls that are running:
+//   - the health service says NOT_SERVING and the Watch streams end, so the clients move to another replica
+//   - a leader finishes the bid it is committing and lets another replica take over
+//   - the calls that are still running are waited for, new calls are refused
+//   - the audit log is synced and closed
+//
+// When ctx is done before the calls have finished, they are cut off and an error is returned.
+func (s *RMserver) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.draining {
+		s.mutex.Unlock()
+		return fmt.Errorf("auctionserver: server %d is already shutting down", s.Id)
+	}
+	s.draining = true
+	s.mutex.Unlock()
+	s.logger.Info("shutting down")
+	// NOT_SERVING first, so the clients hear why before their streams end
+	s.updateHealth()
+	close(s.drain)
+
+	if s.leading() {
+		s.handOff(ctx)
+	}
+
+	var err error
+	if s.grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			s.grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			err = fmt.Errorf("auctionserver: the calls did not finish in time: %w", ctx.Err())
+		}
+	}
+	s.Stop()
+	s.logger.Info("shut down", "err", err)
+	return err
+}
+
+// handOff lets another replica lead before this one stops. It waits for the record that is being
+// committed, sends the others every record and steps down. The draining flag in our pings keeps
+// us out of the election, so the next replica in line takes over. If the commit is still going on
+// when ctx is done it gives up, Stop steps down without the hand-off then.
+func (s *RMserver) handOff(ctx context.Context) {
+	if err := s.lockCommit(ctx); err != nil {
+		s.logger.Warn("gave up the hand-off, the commit that is going on did not finish in time", "err", err)
+		return
+	}
+	s.replicateAll(ctx)
+	s.mutex.Lock()
+	s.stepDown("shutting down")
+	voters := 0
+	for _, p := range s.peers {
+		if s.isMember(p.id) {
+			voters++
+		}
+	}
+	s.mutex.Unlock()
+	s.commitMutex.Unlock()
+	if voters == 0 {
+		return // nobody to hand off to
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, handOffTimeout)
+	defer cancel()
+	for {
+		s.mutex.Lock()
+		leader := s.leaderID
+		s.mutex.Unlock()
+		if leader >= 0 && leader != s.Id {
+			s.logger.Info("handed off the leadership", "leader", leader)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			s.logger.Warn("no other replica took over before the shutdown")
+			return
+		case <-time.After(pingInterval / 2):
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package auctionserver_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc"
+)
+
+func TestShutdownHandsOff(t *testing.T) {
+	c := auctiontest.NewCluster(t, 3)
+	leader := c.WaitForLeader()
+	mustBid(t, c, 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx, leader); err != nil {
+		t.Fatal(err)
+	}
+	if next := c.WaitForLeader(); next == leader {
+		t.Fatalf("replica %d leads after it was shut down", leader)
+	}
+	mustBid(t, c, 20)
+}
+
+func TestShutdownGivesUpTheHandOff(t *testing.T) {
+	// once hang is set, the records the replicas send each other hang until the call times out
+	var hang atomic.Bool
+	hangAppends := grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if hang.Load() && method == gRPC.ReplicationService_Append_FullMethodName {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+	var logs syncBuffer
+	c := auctiontest.NewCluster(t, 3, func(id int, cfg *auctionserver.Config) {
+		cfg.DialOptions = append(cfg.DialOptions, hangAppends)
+		cfg.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+	})
+	leader := c.WaitForLeader()
+
+	// the bid can't be committed, the leader is stuck on it
+	hang.Store(true)
+	conn, err := grpc.Dial(c.Addrs[leader], c.DialOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go gRPC.NewAuctionServiceClient(conn).Bid(context.Background(), &gRPC.BidAmount{ClientID: 1, ClientName: "alice", Amount: 10})
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(ctx, leader); err == nil {
+		t.Fatal("the shutdown did not say the bid was cut off")
+	}
+	// it did not wait for the commit to hand off after its ctx was done
+	if !strings.Contains(logs.String(), "gave up the hand-off") {
+		t.Fatalf("the leader did not give up the hand-off:\n%s", logs.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer the replicas can log to at the same time.
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
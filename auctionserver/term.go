@@ -0,0 +1,85 @@
+package auctionserver
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// The term and who we voted for in it are kept in a file next to the audit log, and written before
+// a vote is answered or a new term is started. Without it a replica that restarts could vote a second
+// time in a term it already voted in, and two leaders could be elected in one term.
+
+// termState is what is kept in the file.
+type termState struct {
+	Term     int64 `json:"term"`
+	VotedFor int   `json:"votedFor"`
+}
+
+// termPath is the file the term is kept in, audit_server0.log.term for audit_server0.log.
+func termPath(auditLogPath string) string {
+	return auditLogPath + ".term"
+}
+
+// loadTerm reads the term file. A missing file is the state of a replica that never voted.
+func loadTerm(path string) (termState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return termState{VotedFor: -1}, nil
+	}
+	if err != nil {
+		return termState{}, err
+	}
+	var st termState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return termState{}, err
+	}
+	return st, nil
+}
+
+// saveTerm writes the term file, through a new file that replaces the old one so a crash
+// leaves either the old or the new state.
+func saveTerm(path string, st termState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// persistTerm writes s.term and s.votedFor to the term file. The caller must hold s.mutex.
+func (s *RMserver) persistTerm() error {
+	return saveTerm(termPath(s.cfg.AuditLogPath), termState{Term: s.term, VotedFor: s.votedFor})
+}
+
+// adoptTerm moves to a newer term, in which we have not voted yet. The caller must hold s.mutex.
+func (s *RMserver) adoptTerm(term int64) {
+	if term <= s.term {
+		return
+	}
+	s.term = term
+	s.votedFor = -1
+	if err := s.persistTerm(); err != nil {
+		// the vote is what has to be kept, and RequestVote writes it again before it votes
+		s.logger.Warn("could not write the term file", "term", term, "err", err)
+	}
+}
@@ -0,0 +1,223 @@
+package auctionserver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+	"github.com/Alex-itu/A_Distributed_Auction_System/webhook"
+)
+
+// Other systems can be told what happens in the auction with webhooks, see the webhook package.
+// The events come from the committed records, so every replica makes the same events with the same
+// ids when it applies them, and keeps them in its outbox. Only the leader sends them, to every URL
+// on its own. When a URL has taken a batch the leader commits a delivered record for that URL, and
+// an event leaves the outboxes once every URL has taken it, so a new leader sends each URL what the
+// old one had not. A URL that is down is tried again with the same events until it takes them, and
+// does not hold up the others. An event can be sent twice when the leader goes down between sending
+// it and committing the delivered record.
+
+// The types of the events.
+const (
+	EventBidAccepted   = "bid_accepted"
+	EventOutbid        = "outbid"
+	EventAuctionClosed = "auction_closed"
+	EventLeaderChanged = "leader_changed"
+)
+
+// maxOutbox is how many events a replica keeps at most. A replica that does not hear of the
+// deliveries (e.g. because the leader has no webhooks configured) or a URL that is down for long
+// gets there, and the oldest are dropped.
+const maxOutbox = 10000
+
+// pendingEvent is an event in the outbox, with the record it came from.
+type pendingEvent struct {
+	seq int64
+	webhook.Event
+}
+
+// bidData is the data of bid_accepted, and of outbid for the bidder that was outbid.
+type bidData struct {
+	Seq        int64   `json:"seq"` // the audit log record
+	ClientID   int32   `json:"clientID"`
+	ClientName string  `json:"clientName"`
+	Amount     float32 `json:"amount"`
+	// outbid only: the bid that went over it
+	HighestBidder string  `json:"highestBidder,omitempty"`
+	HighestBid    float32 `json:"highestBid,omitempty"`
+}
+
+// closedData is the data of auction_closed. There is no winner when nobody bid or it was cancelled.
+type closedData struct {
+	Seq        int64   `json:"seq"`
+	Cancelled  bool    `json:"cancelled"`
+	Reason     string  `json:"reason,omitempty"`
+	ClientID   int32   `json:"clientID"`
+	ClientName string  `json:"clientName,omitempty"`
+	Amount     float32 `json:"amount"`
+}
+
+// leaderData is the data of leader_changed.
+type leaderData struct {
+	Seq      int64 `json:"seq"`
+	LeaderID int   `json:"leaderID"`
+	Term     int64 `json:"term"`
+}
+
+// addEvent puts an event for r in the outbox. The caller must hold s.mutex.
+func (s *RMserver) addEvent(r audit.Record, eventType string, data interface{}) {
+	if s.webhooks == nil || r.Seq <= s.deliveredToAll() {
+		return
+	}
+	if len(s.outbox) >= maxOutbox {
+		s.logger.Warn("too many webhook events waiting, dropping the oldest", "event", s.outbox[0].ID)
+		s.outbox = s.outbox[1:]
+	}
+	s.outbox = append(s.outbox, pendingEvent{r.Seq, webhook.Event{
+		ID:   fmt.Sprintf("%d-%s", r.Seq, eventType),
+		Type: eventType,
+		Time: time.Unix(0, r.Time),
+		Data: data,
+	}})
+}
+
+// bidEvents makes the events of a bid record, before it is applied. The caller must hold s.mutex.
+func (s *RMserver) bidEvents(r audit.Record) {
+	if s.webhooks == nil {
+		return
+	}
+	prevID, prev := s.HighestBid()
+	s.addEvent(r, EventBidAccepted, bidData{Seq: r.Seq, ClientID: r.ClientID, ClientName: r.ClientName, Amount: r.Amount})
+	if prevID >= 0 && prevID != r.ClientID {
+		s.addEvent(r, EventOutbid, bidData{Seq: r.Seq, ClientID: prevID, ClientName: s.auction.Names[prevID], Amount: prev,
+			HighestBidder: r.ClientName, HighestBid: r.Amount})
+	}
+}
+
+// closeEvent makes the event of a close or cancel record, after it is applied. The caller must hold s.mutex.
+func (s *RMserver) closeEvent(r audit.Record) {
+	data := closedData{Seq: r.Seq, Cancelled: s.auction.Cancelled, Reason: r.Detail, ClientID: -1}
+	if maxid, max := s.HighestBid(); !s.auction.Cancelled && maxid >= 0 {
+		data.ClientID, data.ClientName, data.Amount = maxid, s.auction.Names[maxid], max
+	}
+	s.addEvent(r, EventAuctionClosed, data)
+}
+
+// leaderEvent makes the event of the config record a new leader starts its term with.
+// The caller must hold s.mutex.
+func (s *RMserver) leaderEvent(r audit.Record) {
+	leader := -1
+	fmt.Sscanf(r.Detail, "server %d leads", &leader)
+	s.addEvent(r, EventLeaderChanged, leaderData{Seq: r.Seq, LeaderID: leader, Term: r.Term})
+}
+
+// delivered notes that a URL took the events up to the record in a delivered record, and takes the
+// events every URL took out of the outbox. A delivered record without a URL, from an older log,
+// is for all of them. The caller must hold s.mutex.
+func (s *RMserver) delivered(r audit.Record) {
+	if s.webhooks == nil {
+		return
+	}
+	seqText, url, _ := strings.Cut(r.Detail, " ")
+	seq, err := strconv.ParseInt(seqText, 10, 64)
+	if err != nil {
+		return
+	}
+	for _, u := range s.webhooks.URLs() {
+		if (url == "" || url == u) && seq > s.deliveredSeqs[u] {
+			s.deliveredSeqs[u] = seq
+		}
+	}
+	all := s.deliveredToAll()
+	n := 0
+	for n < len(s.outbox) && s.outbox[n].seq <= all {
+		n++
+	}
+	s.outbox = s.outbox[n:]
+}
+
+// deliveredToAll is the last record whose events every URL took. The caller must hold s.mutex.
+func (s *RMserver) deliveredToAll() int64 {
+	all := int64(-1)
+	for _, u := range s.webhooks.URLs() {
+		if all < 0 || s.deliveredSeqs[u] < all {
+			all = s.deliveredSeqs[u]
+		}
+	}
+	return all
+}
+
+// webhookLoop sends the events in the outbox while this replica leads.
+func (s *RMserver) webhookLoop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for !s.stopped() {
+		time.Sleep(pingInterval)
+		if s.leading() {
+			var wg sync.WaitGroup
+			for _, url := range s.webhooks.URLs() {
+				wg.Add(1)
+				go func(url string) {
+					defer wg.Done()
+					s.deliverEvents(ctx, url)
+				}(url)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// deliverEvents sends the events in the outbox that url has not taken yet, in order, and commits
+// how far it got. When the retries of an event run out it is logged and counted, and it is sent
+// again the next time, so url gets every event, in order. A record can have more than one event
+// (a bid is bid_accepted and outbid), and the delivered record only goes up to a record once url
+// took all of its events, so the ones it did take are sent again with the rest.
+func (s *RMserver) deliverEvents(ctx context.Context, url string) {
+	s.mutex.Lock()
+	var pending []pendingEvent
+	for _, ev := range s.outbox {
+		if ev.seq > s.deliveredSeqs[url] {
+			pending = append(pending, ev)
+		}
+	}
+	s.mutex.Unlock()
+
+	last := int64(0)
+	for i, ev := range pending {
+		if !s.leading() {
+			break // the next leader sends the rest
+		}
+		if err := s.webhooks.SendTo(ctx, url, ev.Event); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Error("webhook not taken, it is sent again later", "url", url, "event", ev.ID, "err", err)
+			s.metrics.webhook(ev.Type, "failed")
+			break
+		}
+		s.logger.Debug("sent webhook", "url", url, "event", ev.ID)
+		s.metrics.webhook(ev.Type, "delivered")
+		if i+1 == len(pending) || pending[i+1].seq != ev.seq {
+			last = ev.seq // the last event of the record
+		}
+	}
+	if last == 0 {
+		return
+	}
+	s.commitMutex.Lock()
+	defer s.commitMutex.Unlock()
+	if _, err := s.commit(ctx, audit.Record{Kind: audit.KindDelivered, Detail: strconv.FormatInt(last, 10) + " " + url}); err != nil {
+		s.logger.Warn("could not commit the webhook deliveries, they will be sent again", "url", url, "seq", last, "err", err)
+	}
+}
@@ -0,0 +1,161 @@
+package auctionserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	"github.com/Alex-itu/A_Distributed_Auction_System/webhook"
+)
+
+// receiver is a webhook URL that keeps the ids of the events it took, and turns them down while down is set.
+type receiver struct {
+	t      *testing.T
+	down   atomic.Bool
+	mutex  sync.Mutex
+	ids    []string
+	refuse map[string]int // how many more times to turn down the events of a type
+	server *httptest.Server
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{t: t, refuse: make(map[string]int)}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		if !webhook.Verify("secret", body, req.Header.Get(webhook.SignatureHeader)) {
+			t.Errorf("an event with a bad signature: %s", body)
+		}
+		if r.down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var ev webhook.Event
+		json.Unmarshal(body, &ev)
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if r.refuse[ev.Type] > 0 {
+			r.refuse[ev.Type]--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.ids = append(r.ids, ev.ID)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *receiver) took() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.ids...)
+}
+
+// waitFor waits until the receiver took n events.
+func (r *receiver) waitFor(n int) []string {
+	r.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.took()) < n {
+		if time.Now().After(deadline) {
+			r.t.Fatalf("%s took %v, want %d events", r.server.URL, r.took(), n)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return r.took()
+}
+
+func TestWebhooksPerURL(t *testing.T) {
+	up, flaky := newReceiver(t), newReceiver(t)
+	flaky.down.Store(true)
+	c := auctiontest.NewCluster(t, 1, func(id int, cfg *auctionserver.Config) {
+		cfg.Webhooks = webhook.Config{URLs: []string{up.server.URL, flaky.server.URL}, Secret: "secret", Retries: 1, RetryDelay: 10 * time.Millisecond}
+	})
+	c.WaitForLeader()
+	alice, bob := c.Client(1, "alice"), c.Client(2, "bob")
+	ctx := context.Background()
+	if _, err := alice.Bid(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Bid(ctx, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	// leader_changed, alice's bid, bob's bid and that alice was outbid
+	want := up.waitFor(4)
+	// the URL that is down does not hold up the other one, and gets the same events once it is back
+	time.Sleep(200 * time.Millisecond)
+	if took := flaky.took(); len(took) != 0 {
+		t.Fatalf("the URL that is down took %v", took)
+	}
+	flaky.down.Store(false)
+	got := flaky.waitFor(len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("the URL that was down took %v, the other one %v", got, want)
+		}
+	}
+	if took := up.took(); len(took) != len(want) {
+		t.Fatalf("the URL that was up took the events again: %v", took)
+	}
+}
+
+func TestWebhooksSecondEventOfARecord(t *testing.T) {
+	r := newReceiver(t)
+	// outbid is the second event of bob's bid, it fails on both tries of the first round
+	r.refuse[auctionserver.EventOutbid] = 2
+	c := auctiontest.NewCluster(t, 1, func(id int, cfg *auctionserver.Config) {
+		cfg.Webhooks = webhook.Config{URLs: []string{r.server.URL}, Secret: "secret", Retries: 1, RetryDelay: 10 * time.Millisecond}
+	})
+	c.WaitForLeader()
+	ctx := context.Background()
+	if _, err := c.Client(1, "alice").Bid(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Client(2, "bob").Bid(ctx, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var outbid, accepted int
+		for _, id := range r.took() {
+			if strings.HasSuffix(id, "-"+auctionserver.EventOutbid) {
+				outbid++
+			}
+			if strings.HasSuffix(id, "-"+auctionserver.EventBidAccepted) {
+				accepted++
+			}
+		}
+		// bob's bid_accepted was taken in the first round and is sent again with the outbid
+		if outbid == 1 && accepted >= 2 {
+			break
+		}
+		if outbid > 1 {
+			t.Fatalf("outbid was taken more than once: %v", r.took())
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the outbid of a record whose first event was taken never came: %v", r.took())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWebhooksNeedASecret(t *testing.T) {
+	_, err := auctionserver.New(auctionserver.Config{
+		ListenAddr:   "localhost:0",
+		AuditLogPath: filepath.Join(t.TempDir(), "audit.log"),
+		Webhooks:     webhook.Config{URLs: []string{"https://example.com/auction-events"}},
+	})
+	if err == nil {
+		t.Fatal("a server with webhooks and no secret was made")
+	}
+}
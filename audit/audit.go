@@ -0,0 +1,322 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// The different kinds of events that end up in the audit log.
+const (
+	KindBid     = "bid"
+	KindRetract = "retract"
+	KindClose   = "close"
+	KindConfig  = "config"
+	// KindCreated is written once by the first leader, Detail is the end time in RFC 3339.
+	KindCreated = "created"
+	// KindRejected is a bid the leader turned down, Detail says why. It changes nothing. The servers
+	// no longer write it (see auctionserver/events.go), it is only read from older logs.
+	KindRejected = "rejected"
+
+	// written by the admin service. The reason the admin gave is in Detail,
+	// except for an extension where Detail is the new end time in RFC 3339.
+	KindExtend = "extend"
+	KindCancel = "cancel"
+	KindBan    = "ban"
+	// KindBudget sets how much a bidder can spend, Amount is the budget and 0 takes it away.
+	KindBudget = "budget"
+
+	// the settlement after the close, see auctionserver/settlement.go. ClientID is the buyer and Amount the price.
+	// Detail is the reference of the payment for KindPaid and why for KindDefaulted.
+	KindPaid      = "paid"
+	KindDefaulted = "defaulted"
+	KindOffered   = "offered" // the item is offered to the next bidder in line after the buyer defaulted
+
+	// KindDelivered says a webhook URL took the events of the records up to a seq. Detail is the
+	// seq and the URL, separated by a space, see auctionserver/webhooks.go.
+	KindDelivered = "delivered"
+
+	// KindMembers changes the replicas of the cluster. Detail is the new list of replicas as JSON.
+	KindMembers = "members"
+)
+
+// Record is one line in the audit log. Every record carries the hash of the record before it,
+// so if someone changes an old record (e.g. the winning bid) every hash after it stops matching.
+type Record struct {
+	Seq        int64   `json:"seq"`
+	Term       int64   `json:"term"`
+	Time       int64   `json:"time"` // unix nano
+	Kind       string  `json:"kind"`
+	ClientID   int32   `json:"clientID"`
+	ClientName string  `json:"clientName"`
+	Amount     float32 `json:"amount"`
+	Detail     string  `json:"detail"`
+	RequestID  string  `json:"requestID,omitempty"` // the idempotency key of a bid, the same on every retry. Left out when empty, so older records hash the same
+	PrevHash   string  `json:"prevHash"`
+	Hash       string  `json:"hash,omitempty"`
+}
+
+// ComputeHash returns the sha256 of the record with the hash field left out.
+func (r Record) ComputeHash() string {
+	r.Hash = ""
+	b, _ := json.Marshal(r) // a struct of plain fields can't fail to marshal
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Log is an append only, hash-chained list of records that is kept in memory and in a file.
+// Seq numbers start at 1, so Seq n is stored at index n-1.
+type Log struct {
+	mutex   sync.Mutex
+	path    string
+	file    *os.File
+	records []Record
+}
+
+// Open loads the log in the file at path (creating it if it does not exist) and checks the chain.
+func Open(path string) (*Log, error) {
+	records, err := ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := Verify(records); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{path: path, file: f, records: records}, nil
+}
+
+// ReadFile reads all records from a log file without checking them.
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return records, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// Verify checks that the seq numbers are in order, that every record points at the hash of
+// the one before it and that every hash matches the content of its record.
+func Verify(records []Record) error {
+	prevHash := ""
+	for i, r := range records {
+		if r.Seq != int64(i+1) {
+			return fmt.Errorf("record %d has seq %d", i+1, r.Seq)
+		}
+		if r.PrevHash != prevHash {
+			return fmt.Errorf("record %d: previous hash is %q but should be %q", r.Seq, r.PrevHash, prevHash)
+		}
+		if h := r.ComputeHash(); r.Hash != h {
+			return fmt.Errorf("record %d: hash is %q but the content hashes to %q", r.Seq, r.Hash, h)
+		}
+		prevHash = r.Hash
+	}
+	return nil
+}
+
+// Append chains a new record onto the end of the log and writes it to the file.
+// Seq, PrevHash and Hash are filled in by the log.
+func (l *Log) Append(r Record) (Record, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	r.Seq = int64(len(l.records) + 1)
+	r.PrevHash = l.lastHash()
+	r.Hash = r.ComputeHash()
+	if err := l.write(r, true); err != nil {
+		return Record{}, err
+	}
+	l.records = append(l.records, r)
+	return r, nil
+}
+
+// AppendAfter is used by the replicas that are not the leader. It drops everything after prevSeq
+// (records the leader never committed) and appends records that already have their hashes.
+// It fails if the records don't chain onto the record at prevSeq.
+func (l *Log) AppendAfter(prevSeq int64, records []Record) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if prevSeq > int64(len(l.records)) {
+		return fmt.Errorf("missing records after %d", len(l.records))
+	}
+
+	// skip the records we already have, so a repeated Append does not rewrite the file
+	for len(records) > 0 && records[0].Seq <= int64(len(l.records)) && l.records[records[0].Seq-1].Hash == records[0].Hash {
+		prevSeq = records[0].Seq
+		records = records[1:]
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	prevHash := ""
+	if prevSeq > 0 {
+		prevHash = l.records[prevSeq-1].Hash
+	}
+	for i, r := range records {
+		if r.Seq != prevSeq+int64(i)+1 || r.PrevHash != prevHash || r.ComputeHash() != r.Hash {
+			return fmt.Errorf("record %d does not fit the chain", r.Seq)
+		}
+		prevHash = r.Hash
+	}
+
+	if int(prevSeq) < len(l.records) {
+		// some of our records are being replaced, so the file has to be rewritten
+		l.records = append(l.records[:prevSeq], records...)
+		return l.rewrite()
+	}
+	// one sync for the whole batch, the leader sends many records at once when it was busy
+	for _, r := range records {
+		if err := l.write(r, false); err != nil {
+			return err
+		}
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.records = append(l.records, records...)
+	return nil
+}
+
+// Len returns the seq of the last record.
+func (l *Log) Len() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int64(len(l.records))
+}
+
+// Last returns the last record, or an empty record if the log is empty.
+func (l *Log) Last() Record {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.records) == 0 {
+		return Record{}
+	}
+	return l.records[len(l.records)-1]
+}
+
+// Get returns the record with the given seq.
+func (l *Log) Get(seq int64) (Record, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if seq < 1 || seq > int64(len(l.records)) {
+		return Record{}, false
+	}
+	return l.records[seq-1], true
+}
+
+// From returns a copy of every record with a seq of at least from.
+func (l *Log) From(from int64) []Record {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if from < 1 {
+		from = 1
+	}
+	if from > int64(len(l.records)) {
+		return nil
+	}
+	return append([]Record(nil), l.records[from-1:]...)
+}
+
+// Page returns a copy of at most n records from the seq from on.
+func (l *Log) Page(from int64, n int) []Record {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if from < 1 {
+		from = 1
+	}
+	if from > int64(len(l.records)) {
+		return nil
+	}
+	end := min(int(from-1)+n, len(l.records))
+	return append([]Record(nil), l.records[from-1:end]...)
+}
+
+// Close syncs and closes the file behind the log.
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+func (l *Log) lastHash() string {
+	if len(l.records) == 0 {
+		return ""
+	}
+	return l.records[len(l.records)-1].Hash
+}
+
+// write appends one record to the file. With sync it is synced, so an acknowledged bid survives a crash.
+func (l *Log) write(r Record, sync bool) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if !sync {
+		return nil
+	}
+	return l.file.Sync()
+}
+
+// rewrite replaces the file with the records in memory.
+func (l *Log) rewrite() error {
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, r := range l.records {
+		b, _ := json.Marshal(r)
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	l.file.Close()
+	if err := os.Rename(tmp, l.path); err != nil {
+		return err
+	}
+	l.file, err = os.OpenFile(l.path, os.O_RDWR|os.O_APPEND, 0666)
+	return err
+}
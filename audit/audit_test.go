@@ -0,0 +1,185 @@
+package audit
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// chain makes n hash-chained bids, like a leader would append them.
+func chain(t *testing.T, n int) []Record {
+	t.Helper()
+	l := openLog(t)
+	for i := 0; i < n; i++ {
+		if _, err := l.Append(Record{Term: 1, Kind: KindBid, ClientID: int32(i), Amount: float32(10 * (i + 1))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return l.From(1)
+}
+
+func openLog(t *testing.T) *Log {
+	t.Helper()
+	l, err := Open(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(records []Record) []Record
+		ok     bool
+	}{
+		{"untouched", func(records []Record) []Record { return records }, true},
+		{"empty", func(records []Record) []Record { return nil }, true},
+		{"changed amount", func(records []Record) []Record {
+			records[1].Amount = 1000
+			return records
+		}, false},
+		{"changed amount with a new hash", func(records []Record) []Record {
+			// the record checks out on its own, but the next one points at its old hash
+			records[1].Amount = 1000
+			records[1].Hash = records[1].ComputeHash()
+			return records
+		}, false},
+		{"record left out", func(records []Record) []Record {
+			return append(records[:1], records[2:]...)
+		}, false},
+		{"records swapped", func(records []Record) []Record {
+			records[1], records[2] = records[2], records[1]
+			return records
+		}, false},
+		{"first record missing", func(records []Record) []Record { return records[1:] }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.change(chain(t, 4)))
+			if tt.ok && err != nil {
+				t.Errorf("Verify() = %v, want no error", err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("Verify() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestAppendAfter(t *testing.T) {
+	leader := chain(t, 5)
+
+	// a follower that has the first 3 records, plus 2 of an old leader that were never committed
+	follower := openLog(t)
+	if err := follower.AppendAfter(0, leader[:3]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := follower.Append(Record{Term: 0, Kind: KindBid, ClientID: 99, Amount: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the same records again change nothing
+	if err := follower.AppendAfter(0, leader[:3]); err != nil {
+		t.Fatalf("repeating records: %v", err)
+	}
+	if follower.Len() != 5 {
+		t.Fatalf("Len() = %d after repeating records, want 5", follower.Len())
+	}
+
+	// the leader's records replace the uncommitted ones
+	if err := follower.AppendAfter(3, leader[3:]); err != nil {
+		t.Fatalf("replacing records: %v", err)
+	}
+	assertRecords(t, follower.From(1), leader)
+
+	// and the file was rewritten with them
+	records, err := ReadFile(follower.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRecords(t, records, leader)
+	if err := Verify(records); err != nil {
+		t.Fatalf("the rewritten file does not verify: %v", err)
+	}
+
+	// a reopened log has them too, and takes new records after them
+	follower.Close()
+	reopened, err := Open(follower.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	assertRecords(t, reopened.From(1), leader)
+	if r, err := reopened.Append(Record{Term: 2, Kind: KindClose}); err != nil || r.Seq != 6 {
+		t.Fatalf("Append() = seq %d, %v, want seq 6", r.Seq, err)
+	}
+}
+
+func TestAppendAfterRejects(t *testing.T) {
+	leader := chain(t, 4)
+	changed := append([]Record(nil), leader...)
+	changed[2].Amount = 1
+
+	tests := []struct {
+		name    string
+		prevSeq int64
+		records []Record
+	}{
+		{"gap", 1, leader[2:]},
+		{"past the end", 3, leader[3:]},
+		{"changed record", 2, changed[2:]},
+		{"wrong order", 2, []Record{leader[3], leader[2]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := openLog(t)
+			if err := l.AppendAfter(0, leader[:2]); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.AppendAfter(tt.prevSeq, tt.records); err == nil {
+				t.Fatalf("AppendAfter() = nil, want an error")
+			}
+			// nothing was changed
+			assertRecords(t, l.From(1), leader[:2])
+		})
+	}
+}
+
+func TestPage(t *testing.T) {
+	l := openLog(t)
+	if err := l.AppendAfter(0, chain(t, 5)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		from      int64
+		n         int
+		wantFirst int64
+		wantLen   int
+	}{
+		{1, 2, 1, 2},
+		{4, 10, 4, 2},
+		{0, 3, 1, 3},
+		{6, 3, 0, 0},
+	}
+	for _, tt := range tests {
+		page := l.Page(tt.from, tt.n)
+		if len(page) != tt.wantLen || (len(page) > 0 && page[0].Seq != tt.wantFirst) {
+			t.Errorf("Page(%d, %d) has %d records, want %d from %d", tt.from, tt.n, len(page), tt.wantLen, tt.wantFirst)
+		}
+	}
+}
+
+func assertRecords(t *testing.T, got, want []Record) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].Hash != want[i].Hash {
+			t.Fatalf("record %d is %+v, want %+v", i+1, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionclient"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+	"github.com/Alex-itu/A_Distributed_Auction_System/history"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc"
+)
+
+// chaos runs a cluster of servers in this process behind a simulated network that drops, delays,
+// duplicates and reorders requests, while clients bid and a nemesis partitions and crashes replicas.
+// When the auction is over it checks that nothing went wrong.
+// Run with:
+// go run ./chaos -seed 42 -duration 20s
+// go run ./chaos -seed 7 -drop 0.2 -nemesis 1s -history history.jsonl
+// go test ./chaos runs a few short seeds.
+
+var seed = flag.Int64("seed", 1, "The seed for the faults, the bids and the nemesis")
+var replicas = flag.Int("replicas", 3, "How many replicas to run")
+var clients = flag.Int("clients", 3, "How many clients bid at the same time")
+var duration = flag.Duration("duration", 10*time.Second, "How long the auction runs")
+var drop = flag.Float64("drop", 0.05, "The chance a request is lost")
+var dropReply = flag.Float64("dropReply", 0.05, "The chance a reply is lost after the request was handled")
+var duplicate = flag.Float64("duplicate", 0.05, "The chance a request is delivered twice")
+var reorder = flag.Float64("reorder", 0.05, "The chance a request is held back so later ones overtake it")
+var delay = flag.Duration("delay", 20*time.Millisecond, "Every request is delayed by up to this long")
+var nemesis = flag.Duration("nemesis", 2*time.Second, "How often the nemesis partitions or crashes a replica (0 turns it off)")
+var dir = flag.String("dir", "", "Where to keep the audit logs and the server log (default a new temporary directory)")
+var historyPath = flag.String("history", "", "Write the history of the client calls to this file, for lincheck")
+
+// out is where the report goes, the servers log to a file instead
+var out io.Writer = os.Stdout
+
+var start = time.Now()
+
+func main() {
+	flag.Parse()
+
+	violations, err := run()
+	if err != nil {
+		printf("%v", err)
+		os.Exit(1)
+	}
+	if len(violations) > 0 {
+		for _, v := range violations {
+			printf("VIOLATION: %s", v)
+		}
+		os.Exit(1)
+	}
+	printf("OK: single winner, monotonic highest bid, no lost acknowledged bid, no stale result")
+}
+
+// run runs one auction with the flags and returns what went wrong.
+func run() ([]string, error) {
+	if *dir == "" {
+		var err error
+		*dir, err = os.MkdirTemp("", "chaos")
+		if err != nil {
+			return nil, err
+		}
+	} else if err := os.MkdirAll(*dir, 0777); err != nil {
+		return nil, err
+	}
+	// the servers and clients log everything they do, so send that to a file to keep the output readable
+	logFile, err := os.Create(filepath.Join(*dir, "servers.log"))
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logFile, nil)))
+
+	printf("seed %d, %d replicas, %d clients, logs in %s", *seed, *replicas, *clients, *dir)
+
+	network := auctiontest.NewNetwork(*seed, auctiontest.Faults{
+		Drop:      *drop,
+		DropReply: *dropReply,
+		Duplicate: *duplicate,
+		Reorder:   *reorder,
+		Delay:     *delay,
+	})
+	end := time.Now().Add(*duration)
+	cluster, err := auctiontest.Start(*dir, *replicas, auctiontest.WithNetwork(network), func(id int, cfg *auctionserver.Config) {
+		cfg.EndTime = end
+		cfg.RetractWindow = 0
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the cluster: %v", err)
+	}
+	defer cluster.StopAll()
+	printf("replica %d leads", cluster.WaitForLeader())
+
+	var recorder history.Recorder
+	var wg sync.WaitGroup
+	for i := 1; i <= *clients; i++ {
+		wg.Add(1)
+		go func(id int32) {
+			defer wg.Done()
+			name := fmt.Sprintf("client%d", id)
+			client := cluster.Client(id, name, network.DialOptions(name)...)
+			defer client.Close()
+			bidder(client, id, name, end, &recorder)
+		}(int32(i))
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		client := cluster.Client(0, "observer", network.DialOptions("observer")...)
+		defer client.Close()
+		observer(client, end, &recorder)
+	}()
+
+	stopNemesis := make(chan struct{})
+	nemesisDone := make(chan struct{})
+	var plan []step
+	if *nemesis > 0 {
+		// a step right at the end would race with the end of the auction
+		plan = schedule(*seed, *replicas, max(int(*duration / *nemesis)-1, 0))
+		printf("nemesis: %s", describe(plan))
+	}
+	go func() {
+		defer close(nemesisDone)
+		runNemesis(cluster, network, plan, stopNemesis)
+	}()
+
+	wg.Wait()
+	close(stopNemesis)
+	<-nemesisDone
+
+	// let the cluster settle so every replica can close the auction
+	network.Heal()
+	network.SetFaults(auctiontest.Faults{})
+	for i := range cluster.Addrs {
+		if cluster.Server(i) == nil {
+			cluster.Restart(i)
+		}
+	}
+	stats := network.Stats()
+	printf("network: %d requests, %d dropped, %d replies dropped, %d duplicated, %d reordered, %d refused by partitions",
+		stats.Sent, stats.Dropped, stats.DroppedReplies, stats.Duplicated, stats.Reordered, stats.Refused)
+
+	ops := recorder.Ops()
+	if *historyPath != "" {
+		if err := history.WriteFile(*historyPath, ops); err != nil {
+			printf("failed to write the history: %v", err)
+		}
+	}
+
+	var violations []string
+	outcomes := finalOutcomes(cluster)
+	violations = append(violations, checkSingleWinner(outcomes)...)
+	violations = append(violations, checkAuditLogs(cluster)...)
+	violations = append(violations, checkMonotonic(cluster)...)
+	violations = append(violations, checkAckedBids(ops, outcomes, cluster)...)
+	violations = append(violations, checkStaleResults(ops)...)
+
+	bids, accepted, failed := 0, 0, 0
+	for _, op := range ops {
+		if op.Kind != history.KindBid {
+			continue
+		}
+		bids++
+		if op.Accepted {
+			accepted++
+		}
+		if !op.Ok {
+			failed++
+		}
+	}
+	printf("%d bids, %d accepted, %d with an unknown outcome, %d results", bids, accepted, failed, len(ops)-bids)
+	return violations, nil
+}
+
+func printf(format string, args ...interface{}) {
+	fmt.Fprintf(out, "%8.3fs "+format+"\n", append([]interface{}{time.Since(start).Seconds()}, args...)...)
+}
+
+// bidder keeps bidding a little over the highest bid it knows of until the auction ends.
+func bidder(client *auctionclient.Client, id int32, name string, end time.Time, recorder *history.Recorder) {
+	r := rand.New(rand.NewSource(*seed + int64(id)))
+	highest := float32(0)
+	for time.Now().Before(end) {
+		amount := highest + float32(1+r.Intn(10))
+		op := history.Op{Client: id, Kind: history.KindBid, Name: name, Amount: amount, Call: time.Now().UnixNano()}
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		ack, err := client.Bid(ctx, amount)
+		cancel()
+		op.Return = time.Now().UnixNano()
+		if err != nil {
+			op.Error = err.Error()
+		} else {
+			op.Ok = true
+			op.Accepted = ack.Accepted
+			op.Message = ack.Message
+		}
+		recorder.Add(op)
+
+		if op.Accepted {
+			highest = amount
+		} else {
+			// someone else is ahead, find out by how much
+			op := result(client, id, recorder)
+			if op.Ok && op.Highest > highest {
+				highest = op.Highest
+			}
+		}
+		time.Sleep(time.Duration(r.Intn(50)) * time.Millisecond)
+	}
+}
+
+// observer asks for the result every 50ms until the auction ends.
+func observer(client *auctionclient.Client, end time.Time, recorder *history.Recorder) {
+	for time.Now().Before(end) {
+		result(client, 0, recorder)
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func result(client *auctionclient.Client, id int32, recorder *history.Recorder) history.Op {
+	op := history.Op{Client: id, Kind: history.KindResult, Call: time.Now().UnixNano()}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	outcome, err := client.Result(ctx)
+	cancel()
+	op.Return = time.Now().UnixNano()
+	if err != nil {
+		op.Error = err.Error()
+	} else {
+		op.Ok = true
+		op.Highest = outcome.Amount
+		op.Winner = outcome.ClientName
+		op.Done = outcome.BidDone
+	}
+	recorder.Add(op)
+	return op
+}
+
+// step is one thing the nemesis does.
+type step struct {
+	action string // "isolate", "crash", "shutdown" or "nothing"
+	victim int
+	leader bool // the victim is whichever replica leads at the time, not victim
+}
+
+// schedule works out what the nemesis does from the seed alone, before anything runs.
+// Which replica leads depends on timing, but the steps that go for the leader always do.
+func schedule(seed int64, replicas, steps int) []step {
+	r := rand.New(rand.NewSource(seed))
+	plan := make([]step, steps)
+	for i := range plan {
+		victim := r.Intn(replicas)
+		switch action := r.Intn(6); action {
+		case 0, 1:
+			plan[i] = step{"isolate", victim, action == 1}
+		case 2, 3:
+			plan[i] = step{"crash", victim, action == 3}
+		case 4:
+			plan[i] = step{"shutdown", victim, true}
+		default:
+			plan[i] = step{action: "nothing"}
+		}
+	}
+	return plan
+}
+
+// describe prints the schedule, e.g. "crash the leader, isolate replica 2, nothing".
+func describe(plan []step) string {
+	s := ""
+	for i, st := range plan {
+		if i > 0 {
+			s += ", "
+		}
+		s += st.action
+		switch {
+		case st.action == "nothing":
+		case st.leader:
+			s += " the leader"
+		default:
+			s += fmt.Sprintf(" replica %d", st.victim)
+		}
+	}
+	return s
+}
+
+// runNemesis takes the steps of the plan one every -nemesis, counted from when it starts,
+// and repairs each one before taking the next.
+func runNemesis(cluster *auctiontest.Cluster, network *auctiontest.Network, plan []step, stop chan struct{}) {
+	begin := time.Now()
+	crashed := -1
+	for i, st := range plan {
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Until(begin.Add(time.Duration(i+1) * *nemesis))):
+		}
+
+		// repair the last thing
+		network.Heal()
+		if crashed >= 0 {
+			printf("nemesis: restarting replica %d", crashed)
+			cluster.Restart(crashed)
+			crashed = -1
+		}
+
+		victim := st.victim
+		if st.leader {
+			if leader := cluster.Leader(); leader >= 0 {
+				victim = leader
+			}
+		}
+		switch st.action {
+		case "isolate":
+			printf("nemesis: isolating replica %d", victim)
+			network.Isolate(cluster.Addrs[victim])
+		case "crash":
+			printf("nemesis: crashing replica %d", victim)
+			cluster.Stop(victim)
+			crashed = victim
+		case "shutdown":
+			printf("nemesis: shutting down replica %d", victim)
+			ctx, cancel := context.WithTimeout(context.Background(), *nemesis)
+			if err := cluster.Shutdown(ctx, victim); err != nil {
+				printf("nemesis: %v", err)
+			}
+			cancel()
+			crashed = victim
+		default:
+			printf("nemesis: leaving the network alone")
+		}
+	}
+	<-stop
+}
+
+// finalOutcomes waits until every replica says the auction is over and returns what they say.
+// Only the leader answers Result, so each replica is watched instead, which sends what it has applied.
+func finalOutcomes(cluster *auctiontest.Cluster) []*gRPC.Outcome {
+	outcomes := make([]*gRPC.Outcome, len(cluster.Addrs))
+	for i, addr := range cluster.Addrs {
+		conn, err := grpc.Dial(addr, cluster.DialOptions()...)
+		if err != nil {
+			continue
+		}
+		server := gRPC.NewAuctionServiceClient(conn)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		for ctx.Err() == nil && outcomes[i] == nil {
+			stream, err := server.Watch(ctx, &gRPC.Void{})
+			for err == nil {
+				var outcome *gRPC.Outcome
+				outcome, err = stream.Recv()
+				if err == nil && outcome.BidDone {
+					outcomes[i] = outcome
+					break
+				}
+			}
+			if outcomes[i] == nil {
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+		cancel()
+		conn.Close()
+	}
+	return outcomes
+}
+
+// checkSingleWinner checks every replica closed the auction with the same winner.
+func checkSingleWinner(outcomes []*gRPC.Outcome) []string {
+	var violations []string
+	var first *gRPC.Outcome
+	for i, outcome := range outcomes {
+		if outcome == nil {
+			violations = append(violations, fmt.Sprintf("replica %d never closed the auction", i))
+			continue
+		}
+		if first == nil {
+			first = outcome
+			printf("the winner is %s with a bid of %v", outcome.ClientName, outcome.Amount)
+			continue
+		}
+		if outcome.ClientName != first.ClientName || outcome.Amount != first.Amount {
+			violations = append(violations, fmt.Sprintf("replica %d says %s won with %v, another replica says %s won with %v",
+				i, outcome.ClientName, outcome.Amount, first.ClientName, first.Amount))
+		}
+	}
+	return violations
+}
+
+// checkAuditLogs checks every audit log is intact and they agree where they overlap.
+func checkAuditLogs(cluster *auctiontest.Cluster) []string {
+	var violations []string
+	var first []audit.Record
+	for i := range cluster.Addrs {
+		records, err := audit.ReadFile(filepath.Join(*dir, fmt.Sprintf("audit_server%d.log", i)))
+		if err == nil {
+			err = audit.Verify(records)
+		}
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("the audit log of replica %d is broken: %v", i, err))
+			continue
+		}
+		if first == nil {
+			first = records
+			continue
+		}
+		for j := 0; j < len(first) && j < len(records); j++ {
+			if first[j].Hash != records[j].Hash {
+				violations = append(violations, fmt.Sprintf("the audit log of replica %d differs from record %d", i, records[j].Seq))
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// checkMonotonic checks the highest bid never goes down as the committed records are applied.
+// Nobody retracts in this run, so every bid in the log must be higher than the ones before it.
+func checkMonotonic(cluster *auctiontest.Cluster) []string {
+	var violations []string
+	for i := range cluster.Addrs {
+		records, _ := audit.ReadFile(filepath.Join(*dir, fmt.Sprintf("audit_server%d.log", i)))
+		highest := float32(-1)
+		for _, r := range records {
+			if r.Kind != audit.KindBid {
+				continue
+			}
+			if r.Amount <= highest {
+				violations = append(violations, fmt.Sprintf("record %d in the audit log of replica %d is a bid of %v, but %v was already bid", r.Seq, i, r.Amount, highest))
+				break
+			}
+			highest = r.Amount
+		}
+	}
+	return violations
+}
+
+// checkStaleResults checks no result was lower than a bid that had already been accepted when it
+// was asked for. Nobody retracts in this run, so the highest bid can only go up. lincheck looks
+// at the whole history, this is the check that is cheap enough to always run.
+func checkStaleResults(ops []history.Op) []string {
+	var violations []string
+	for _, r := range ops {
+		if r.Kind != history.KindResult || !r.Ok {
+			continue
+		}
+		for _, bid := range ops {
+			if bid.Kind == history.KindBid && bid.Accepted && bid.Return < r.Call && r.Highest < bid.Amount {
+				violations = append(violations, fmt.Sprintf("the result asked for at %v said the highest bid was %v, but the bid of %v by client %d was accepted at %v",
+					at(r.Call), r.Highest, bid.Amount, bid.Client, at(bid.Return)))
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// checkAckedBids checks no accepted bid was lost: it is in the audit logs and the final highest bid
+// is at least as high.
+func checkAckedBids(ops []history.Op, outcomes []*gRPC.Outcome, cluster *auctiontest.Cluster) []string {
+	var violations []string
+
+	var longest []audit.Record
+	for i := range cluster.Addrs {
+		records, _ := audit.ReadFile(filepath.Join(*dir, fmt.Sprintf("audit_server%d.log", i)))
+		if len(records) > len(longest) {
+			longest = records
+		}
+	}
+	inLog := make(map[[2]float32]bool)
+	for _, r := range longest {
+		if r.Kind == audit.KindBid {
+			inLog[[2]float32{float32(r.ClientID), r.Amount}] = true
+		}
+	}
+
+	for _, bid := range ops {
+		if bid.Kind != history.KindBid || !bid.Accepted {
+			continue
+		}
+		if !inLog[[2]float32{float32(bid.Client), bid.Amount}] {
+			violations = append(violations, fmt.Sprintf("the bid of %v by client %d was accepted at %v but is not in the audit log", bid.Amount, bid.Client, at(bid.Return)))
+		}
+		for _, outcome := range outcomes {
+			if outcome != nil && outcome.Amount < bid.Amount {
+				violations = append(violations, fmt.Sprintf("the bid of %v by client %d was accepted but the auction closed at %v", bid.Amount, bid.Client, outcome.Amount))
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// at returns how long into the run a time was, for printing.
+func at(unixNano int64) string {
+	return fmt.Sprintf("%.3fs", time.Duration(unixNano-start.UnixNano()).Seconds())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logWriter sends the report to the test log, so it shows up when a seed fails.
+type logWriter struct{ t *testing.T }
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+func TestChaos(t *testing.T) {
+	if testing.Short() {
+		t.Skip("every seed runs an auction of a few seconds")
+	}
+	for _, s := range []int64{1, 42} {
+		t.Run(fmt.Sprint("seed ", s), func(t *testing.T) {
+			*seed = s
+			*duration = 4 * time.Second
+			*nemesis = time.Second
+			*dir = t.TempDir()
+			out = logWriter{t}
+
+			violations, err := run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range violations {
+				t.Error(v)
+			}
+		})
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	a, b := schedule(7, 3, 20), schedule(7, 3, 20)
+	if describe(a) != describe(b) {
+		t.Fatalf("the same seed gave two schedules:\n%s\n%s", describe(a), describe(b))
+	}
+	if describe(a) == describe(schedule(8, 3, 20)) {
+		t.Fatalf("seeds 7 and 8 gave the same schedule")
+	}
+}
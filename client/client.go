@@ -5,7 +5,8 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"strconv"
 	"strings"
@@ -14,23 +15,47 @@ import (
 	// this has to be the same as the go.mod module,
 	// followed by the path to the folder the proto file is in.
 	// inspired by https://github.com/PatrickMatthiesen/DSYS-gRPC-template and https://articles.wesionary.team/grpc-console-chat-application-in-go-dd77a29bb5c3
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionclient"
+	"github.com/Alex-itu/A_Distributed_Auction_System/config"
+	"github.com/Alex-itu/A_Distributed_Auction_System/discovery"
+	"github.com/Alex-itu/A_Distributed_Auction_System/history"
+	"github.com/Alex-itu/A_Distributed_Auction_System/logging"
 	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+	"github.com/Alex-itu/A_Distributed_Auction_System/tracing"
 
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials"
 )
 
 // Same principle as in client. Flags allows for user specific arguments/values
+var configFile = flag.String("config", "", "A YAML file with the settings of the cluster, see the config package. Flags and AUCTION_* environment variables go over it")
 var clientsName = flag.String("name", "Bames Nond", "Senders name")
 var serverPorts = flag.String("serverPorts", ":8080 :8081 :8082", "TcP SeRvEr pOrTs UwU")
+var discover = flag.String("discover", "", "Find the servers with file:<cluster.yaml>, dns:<SRV name> or members:<addresses> instead of -serverPorts")
 var clientId = flag.Int("id", 0, "Client id")
+var timeout = flag.Duration("timeout", 10*time.Second, "How long a bid, retract or result may take with all its retries before the client gives up on it")
+var tryTimeout = flag.Duration("tryTimeout", 3*time.Second, "How long to wait for one server before trying the next one")
+var hedgeAfter = flag.Duration("hedgeAfter", 300*time.Millisecond, "Also send a bid or result to the next server when the first has not answered after this long (0 turns it off)")
+var historyFile = flag.String("history", "", "Record the bids, retractions and results in this file, so lincheck can check them (written on exit)")
+var logOutput = flag.String("log", "", "Where to log: stdout, stderr or a file, which is appended to and rotated (default log_client<id>.log)")
+var logFormat = flag.String("logFormat", "json", "How to log: json or text")
+var logLevel = flag.String("logLevel", "info", "The lowest level that is logged: debug, info, warn or error")
+var traceExporter = flag.String("trace", "", "Send OpenTelemetry traces to: otlp (a collector) or file (empty turns tracing off)")
+var traceEndpoint = flag.String("traceEndpoint", "", "The collector address for otlp (default localhost:4317) or the file for file (default traces.json)")
+var traceSample = flag.Float64("traceSample", 1, "The share of the traces that are kept, from 0 to 1")
+var tlsCA = flag.String("tlsCA", "", "The CA the certificates of the servers are signed by (empty dials without TLS)")
+var tlsCert = flag.String("tlsCert", "", "A certificate to show the servers, if they want one")
+var tlsKey = flag.String("tlsKey", "", "The key of -tlsCert")
 
-var ServerConn1 *grpc.ClientConn //the server connection
-var ServerConn2 *grpc.ClientConn
-var ServerConn3 *grpc.ClientConn
-var auctionServer1 gRPC.AuctionServiceClient // new chat server client
-var auctionServer2 gRPC.AuctionServiceClient
-var auctionServer3 gRPC.AuctionServiceClient
+var auction *auctionclient.Client // talks to the servers, see the auctionclient package
+
+var recorder history.Recorder // what was asked and answered, for -history
+
+var logger *slog.Logger // the console is for the user, everything else is logged here
+
+var tracerProvider trace.TracerProvider // set up from the -trace flags
+var tracerCloser io.Closer              // sends the spans that are left, the client exits with os.Exit so it is closed by hand
 
 var servers []string
 
@@ -39,22 +64,29 @@ var clientID int32 // clientID is set to 1 by default
 func main() {
 	//parse flag/arguments
 	flag.Parse()
+	if err := loadConfig(); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if *timeout <= 0 || *tryTimeout <= 0 || *hedgeAfter < 0 {
+		fmt.Println("-timeout and -tryTimeout must be positive and -hedgeAfter can't be negative")
+		os.Exit(2)
+	}
 	servers = strings.Split(*serverPorts, " ")
 	clientID = int32(*clientId)
 	
 	fmt.Println("--- CLIENT APP ---")
 
 	//log to file instead of console
-	f := setLog()
-	defer f.Close()
+	closer := setLog()
+	defer closer.Close()
+	tracerCloser = setTracing()
+	defer tracerCloser.Close()
 
 	//connect to server and close the connection when program closes
 	fmt.Println("--- join Server ---")
 	ConnectToServers()
-	
-	defer ServerConn1.Close()
-	defer ServerConn2.Close()
-	defer ServerConn3.Close()	
+	defer auction.Close()
 
 	//start the biding
 	parseInput()
@@ -62,49 +94,57 @@ func main() {
 
 // connect to server
 func ConnectToServers() {
-
-	//dial options
-	//the server is not using TLS, so we use insecure credentials
-	//(should be fine for local testing but not in the real world)
-	opts := []grpc.DialOption{
-		grpc.WithBlock(),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	}
-
-	//dial the server, with the flag "server", to get a connection to it
-	conn1, err := grpc.Dial(servers[0], opts...)
+	tlsConfig, err := config.ClientTLS(*tlsCert, *tlsKey, *tlsCA)
 	if err != nil {
-		fmt.Printf("Fail to Dial : %v \n", err)
-		log.Printf("Fail to Dial : %v", err)
-		return
+		fmt.Printf("%v \n", err)
+		os.Exit(2)
 	}
-	auctionServer1 = gRPC.NewAuctionServiceClient(conn1)
-	ServerConn1 = conn1
-	fmt.Println("Connected to server 0")
-
-	conn2, err := grpc.Dial(servers[1], opts...)
-	if err != nil {
-		fmt.Printf("Fail to Dial : %v \n", err)
-		log.Printf("Fail to Dial : %v", err)
-		return
+	var dialOptions []grpc.DialOption // nil dials without TLS
+	if tlsConfig != nil {
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+	if *discover != "" {
+		source, err := discovery.Parse(*discover, dialOptions...)
+		if err != nil {
+			fmt.Printf("%v \n", err)
+			os.Exit(2)
+		}
+		discovery.Register("cluster", source)
+		servers = []string{discovery.Target("cluster")}
 	}
-	auctionServer2 = gRPC.NewAuctionServiceClient(conn2)
-	ServerConn2 = conn2
-	fmt.Println("Connected to server 1")
 
-	conn3, err := grpc.Dial(servers[2], opts...)
+	auction, err = auctionclient.New(auctionclient.Config{
+		Servers:     servers,
+		ClientID:    clientID,
+		Name:        *clientsName,
+		Logger:      logger,
+		DialOptions: dialOptions,
+		Timeout:     *timeout,
+		TryTimeout:  *tryTimeout,
+		HedgeAfter:  *hedgeAfter,
+
+		TracerProvider: tracerProvider,
+		OnHealthChange: func(server int, healthy bool) {
+			if healthy {
+				fmt.Printf("Server %d is up \n", server)
+			} else {
+				fmt.Printf("Server %d is down \n", server)
+			}
+		},
+	})
 	if err != nil {
 		fmt.Printf("Fail to Dial : %v \n", err)
-		log.Printf("Fail to Dial : %v", err)
-		return
+		logger.Error("could not dial", "err", err)
+		os.Exit(1)
 	}
-	auctionServer3 = gRPC.NewAuctionServiceClient(conn3)
-	ServerConn3 = conn3
-	fmt.Println("Connected to server 2")
 
-	//for the chat implementation
-	// makes a client from the server connection and saves the connection
-	// and prints rather or not the connection was is READY
+	// give the health checks a moment, so the first command does not find every server down
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := auction.WaitReady(ctx); err != nil {
+		fmt.Println("No server is up yet, the client keeps trying in the background")
+		logger.Warn("no server is up yet")
+	}
 }
 
 // watch the god
@@ -118,13 +158,18 @@ func parseInput() {
 		//Read input into var input and any errors into err
 		input, err := reader.ReadString('\n')
 		if err != nil {
+			writeHistory()
+			tracerCloser.Close()
 			fmt.Printf("%v \n", err)
-			log.Fatal(err)
+			logger.Info("input closed", "err", err)
+			os.Exit(1)
 		}
 		input = strings.TrimSpace(input) //Trim input
 		splitInput := strings.Split(input, " ")
 
 		if splitInput[0] == "exit" { 
+			writeHistory()
+			tracerCloser.Close()
 			time.Sleep(1 * time.Second)
 			os.Exit(1)
 		} else if splitInput[0] == "bid" {
@@ -132,79 +177,181 @@ func parseInput() {
 			amount32 := float32(amount64)
 			if err != nil {
 				fmt.Printf("%v \n", err)
-				log.Fatalf("%v", err)
+				logger.Error("not a bid", "input", input, "err", err)
+				os.Exit(1)
 			}
 			fmt.Println(amount32)
-			ack1, err := auctionServer1.Bid(context.Background(), &gRPC.BidAmount{Amount: amount32, ClientID: clientID, ClientName: *clientsName})
-			if err != nil {
-				fmt.Printf("Server 0 is down \n")
-				log.Printf("Server 0 is down")
+			op := history.Op{Client: clientID, Kind: history.KindBid, Name: *clientsName, Amount: amount32, Call: time.Now().UnixNano()}
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			ack, err := auction.Bid(ctx, amount32)
+			cancel()
+			op.Return = time.Now().UnixNano()
+			if err == nil {
+				op.Ok, op.Accepted, op.Message = true, ack.Accepted, ack.Message
 			} else {
-				fmt.Println(ack1.Message)
-				log.Println(ack1.Message)
+				op.Error = err.Error()
 			}
-			
-			ack2, err := auctionServer2.Bid(context.Background(), &gRPC.BidAmount{Amount: amount32, ClientID: clientID, ClientName: *clientsName})
-			if err != nil {
-				fmt.Printf("Server 1 is down \n")
-				log.Printf("Server 1 is down")
-			} else {
-				fmt.Println(ack2.Message)
-				log.Println(ack2.Message)
-			}
-			
-			ack3, err := auctionServer3.Bid(context.Background(), &gRPC.BidAmount{Amount: amount32, ClientID: clientID, ClientName: *clientsName})
-			if err != nil {
-				fmt.Printf("Server 2 is down \n")
-				log.Printf("Server 2 is down")
+			recorder.Add(op)
+			printAck(ack, err)
+
+		} else if splitInput[0] == "retract" {
+			// everything after "retract" is the reason, e.g. "retract typo, meant 100"
+			reason := strings.TrimSpace(strings.TrimPrefix(input, "retract"))
+			op := history.Op{Client: clientID, Kind: history.KindRetract, Name: *clientsName, Call: time.Now().UnixNano()}
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			ack, err := auction.Retract(ctx, reason)
+			cancel()
+			op.Return = time.Now().UnixNano()
+			if err == nil {
+				op.Ok, op.Accepted, op.Message = true, ack.Accepted, ack.Message
 			} else {
-				fmt.Println(ack3.Message)
-				log.Println(ack3.Message)
+				op.Error = err.Error()
 			}
-			
+			recorder.Add(op)
+			printAck(ack, err)
+
+		} else if splitInput[0] == "pay" {
+			// pay {reference} confirms the payment for what we won, the reference comes from the payment provider
+			reference := strings.TrimSpace(strings.TrimPrefix(input, "pay"))
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			ack, err := auction.ConfirmPayment(ctx, reference)
+			cancel()
+			printAck(ack, err)
 
 		} else if splitInput[0] == "result" {
-			result, err := auctionServer1.Result(context.Background(), &gRPC.Void{})
+			op := history.Op{Client: clientID, Kind: history.KindResult, Call: time.Now().UnixNano()}
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			result, err := auction.Result(ctx)
+			cancel()
+			op.Return = time.Now().UnixNano()
+			if err == nil {
+				op.Ok, op.Highest, op.Winner, op.Done = true, result.Amount, result.ClientName, result.BidDone
+			} else {
+				op.Error = err.Error()
+			}
+			recorder.Add(op)
 			if err != nil {
-				fmt.Printf("Server 0 is down. Trying on connection 1 \n")
-				log.Printf("Server 0 is down. Trying on connection 1")
-				result, err = auctionServer2.Result(context.Background(), &gRPC.Void{})
-				if err != nil {
-					fmt.Printf("Server 1 is down. Trying on connection 2 \n")
-					log.Printf("Server 1 is down. Trying on connection 2")
-					result, err = auctionServer3.Result(context.Background(), &gRPC.Void{})
-					if err != nil {
-						fmt.Printf("you are offcially fucked. All servers are dead \n")
-						log.Printf("you are offcially fucked. All servers are dead")
-					}
-				}
+				fmt.Printf("Could not get the result: %v \n", err)
+				logger.Warn("could not get the result", "err", err)
+				continue
 			}
-			
-			
-			if result.BidDone {
-				fmt.Printf("The bid is over and the winner is: %s \nWith a bid of: %f \n", result.ClientName, result.Amount)
-				log.Printf("The bid is over and the winner is: %s \nWith a bid of: %f", result.ClientName, result.Amount)
-			} else {
-				fmt.Printf("The current highest bid is: %s \nWith a bid of: %f \n", result.ClientName, result.Amount)
-				log.Printf("The current highest bid is: %s \nWith a bid of: %f", result.ClientName, result.Amount)
+			printOutcome(result)
+
+		} else if splitInput[0] == "watch" {
+			// prints every change to the highest bid until the client exits, so it has no deadline
+			updates, err := auction.Watch(context.Background())
+			if err != nil {
+				fmt.Printf("Could not watch the auction: %v \n", err)
+				logger.Warn("could not watch the auction", "err", err)
+				continue
 			}
+			go func() {
+				for outcome := range updates {
+					printOutcome(outcome)
+				}
+			}()
+		}
+	}
+}
+
+// writeHistory writes the bids, retractions and results to the -history file.
+func writeHistory() {
+	if *historyFile == "" {
+		return
+	}
+	if err := history.WriteFile(*historyFile, recorder.Ops()); err != nil {
+		fmt.Printf("Failed to write the history: %v \n", err)
+		logger.Error("could not write the history", "file", *historyFile, "err", err)
+	}
+}
+
+func printAck(ack *gRPC.Ack, err error) {
+	if err != nil {
+		fmt.Printf("No server took it: %v \n", err)
+		logger.Warn("no server took it", "err", err)
+		return
+	}
+	fmt.Println(ack.Message)
+	logger.Info("answer", "accepted", ack.Accepted, "message", ack.Message)
+}
+
+func printOutcome(result *gRPC.Outcome) {
+	if result.Cancelled {
+		fmt.Printf("The auction was cancelled \n")
+		logger.Info("outcome", "cancelled", true)
+	} else if result.BidDone {
+		fmt.Printf("The bid is over and the winner is: %s \nWith a bid of: %f \n", result.ClientName, result.Amount)
+		logger.Info("outcome", "done", true, "winner", result.ClientName, "amount", result.Amount)
+		printSettlement(result.Settlement)
+	} else {
+		fmt.Printf("The current highest bid is: %s \nWith a bid of: %f \n", result.ClientName, result.Amount)
+		logger.Info("outcome", "done", false, "highest", result.ClientName, "amount", result.Amount)
+	}
+}
+
+// printSettlement says who has to pay for the item after the close.
+func printSettlement(s *gRPC.SettlementState) {
+	if s == nil {
+		return
+	}
+	switch s.State {
+	case gRPC.Settlement_AWAITING_PAYMENT, gRPC.Settlement_OFFERED_TO_RUNNER_UP:
+		fmt.Printf("%s has to pay %f", s.ClientName, s.Price)
+		if s.PayBy != 0 {
+			fmt.Printf(" by %s", time.Unix(s.PayBy, 0).Format(time.DateTime))
 		}
+		fmt.Printf(" \n")
+	case gRPC.Settlement_PAID:
+		fmt.Printf("%s paid %f, the item is theirs \n", s.ClientName, s.Price)
+	case gRPC.Settlement_DEFAULTED:
+		fmt.Printf("%s did not pay and nobody is left to buy the item \n", s.ClientName)
 	}
+	logger.Info("settlement", "state", s.State.String(), "buyer", s.ClientName, "price", s.Price)
 }
 
-// sets the logger to use a log.txt file instead of the console
-func setLog() *os.File {
-	if err := os.Truncate("log_"+*clientsName+".txt", 0); err != nil {
-		fmt.Printf("Failed to truncate: %v \n", err)
-		log.Printf("Failed to truncate: %v", err)
+// loadConfig sets the flags that were not given from the environment and then from the -config file.
+func loadConfig() error {
+	if err := config.ApplyEnv(flag.CommandLine); err != nil {
+		return err
 	}
+	if *configFile == "" {
+		return nil
+	}
+	file, err := config.Load(*configFile)
+	if err != nil {
+		return err
+	}
+	return config.ApplyFile(flag.CommandLine, file.ClientFlags())
+}
 
-	f, err := os.OpenFile("log_"+*clientsName+".txt", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)	
-	if err != nil {		
-		fmt.Printf("error opening file: %v", err)		
-		log.Fatalf("error opening file: %v", err)	
-	}	
-	log.SetOutput(f)	
-	return f
+// sets up the logger. By default it logs to log_client<id>.log, which is appended to, so the
+// logs of earlier runs are kept
+func setLog() io.Closer {
+	output := *logOutput
+	if output == "" {
+		output = "log_client" + fmt.Sprint(*clientId) + ".log"
+	}
+	l, closer, err := logging.New(logging.Config{Output: output, Format: *logFormat, Level: *logLevel})
+	if err != nil {
+		fmt.Printf("error opening the log: %v \n", err)
+		os.Exit(2)
+	}
+	logger = l.With("client", *clientId, "name", *clientsName)
+	return closer
 }
 
+// sets up tracing from the -trace flags. Off by default
+func setTracing() io.Closer {
+	tp, closer, err := tracing.New(tracing.Config{
+		Exporter:    *traceExporter,
+		Endpoint:    *traceEndpoint,
+		ServiceName: "auction-client",
+		SampleRatio: *traceSample,
+	})
+	if err != nil {
+		fmt.Printf("error setting up tracing: %v \n", err)
+		os.Exit(2)
+	}
+	tracerProvider = tp
+	return closer
+}
@@ -0,0 +1,316 @@
+// Package config reads the cluster file the servers, the clients and auctionctl can be started with,
+// instead of passing every flag by hand. One file describes the whole cluster, the servers pick
+// their own part of it by their id:
+//
+//	replicas:
+//	  - address: localhost:8080
+//	    metrics: localhost:9080
+//	  - address: localhost:8081
+//	  - address: localhost:8082
+//	auction:
+//	  endTime: "18:00:00"
+//	  retractWindow: 30s
+//
+// Everything in the file can still be changed with flags and environment variables. A flag given
+// on the command line wins over its environment variable (AUCTION_ENDTIME for -endtime,
+// AUCTION_ADMIN_TOKEN for -adminToken, see EnvName), which wins over the file.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is the content of a cluster file. Everything is optional, what is left out keeps the
+// default of its flag.
+type File struct {
+	// Replicas are the servers of the cluster. The index is the id of the server.
+	Replicas []Replica `yaml:"replicas"`
+
+	Auction    Auction `yaml:"auction"`
+	AdminToken string  `yaml:"adminToken"`
+	// ClusterToken is what the replicas send each other, see auctionserver/peerauth.go.
+	ClusterToken string `yaml:"clusterToken"`
+
+	// Webhooks are where the leader sends the events of the auction.
+	Webhooks Webhooks `yaml:"webhooks"`
+
+	// TLS is what the servers serve with and dial each other with.
+	TLS     TLS     `yaml:"tls"`
+	Logging Logging `yaml:"logging"`
+	Tracing Tracing `yaml:"tracing"`
+
+	Client Client `yaml:"client"`
+}
+
+// Replica is one server.
+type Replica struct {
+	// Address is where the others reach the server, e.g. localhost:8080, [2001:db8::1]:8080
+	// or unix:/tmp/auction0.sock.
+	Address string `yaml:"address"`
+	// Listen is where the server listens, if that is not Address, e.g. 0.0.0.0:8080 or [::]:8080.
+	Listen  string `yaml:"listen"`
+	Metrics string `yaml:"metrics"`
+	// Log is where this server logs to, if not logging.output. Useful when the servers share a machine.
+	Log string `yaml:"log"`
+}
+
+// Auction is the auction the cluster runs.
+type Auction struct {
+	EndTime       string         `yaml:"endTime"` // HH:MM:SS
+	RetractWindow *time.Duration `yaml:"retractWindow"`
+	RetractCutoff *time.Duration `yaml:"retractCutoff"`
+	// PaymentTimeout is how long the winner has to pay, the same on every server
+	PaymentTimeout *time.Duration `yaml:"paymentTimeout"`
+	// Payments is the payment provider, fake or empty for none
+	Payments string `yaml:"payments"`
+	// RateLimit is how fast bids and retractions can come in, leave it out for no limit
+	RateLimit RateLimit `yaml:"rateLimit"`
+}
+
+// RateLimit is the auctionserver package's RateLimit. The rates are calls per second.
+type RateLimit struct {
+	BidderRate  *float64 `yaml:"bidderRate"`
+	BidderBurst *int     `yaml:"bidderBurst"`
+	AddrRate    *float64 `yaml:"addrRate"`
+	AddrBurst   *int     `yaml:"addrBurst"`
+}
+
+// Webhooks are the URLs the events are sent to and the key they are signed with.
+type Webhooks struct {
+	URLs   []string `yaml:"urls"`
+	Secret string   `yaml:"secret"`
+}
+
+// TLS are the files of a certificate, its key and the CA that signed the certificates of the others.
+type TLS struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	CA   string `yaml:"ca"`
+}
+
+// Logging is the logging package's Config.
+type Logging struct {
+	Output     string `yaml:"output"`
+	Format     string `yaml:"format"`
+	Level      string `yaml:"level"`
+	MaxSize    *int64 `yaml:"maxSize"`
+	MaxBackups *int   `yaml:"maxBackups"`
+}
+
+// Tracing is the tracing package's Config.
+type Tracing struct {
+	Exporter    string   `yaml:"exporter"`
+	Endpoint    string   `yaml:"endpoint"`
+	SampleRatio *float64 `yaml:"sampleRatio"`
+}
+
+// Client is what the client uses. The client reaches the servers at their Address.
+type Client struct {
+	ID      *int   `yaml:"id"`
+	Name    string `yaml:"name"`
+	History string `yaml:"history"`
+	Log     string `yaml:"log"`
+	// how long a call may take with its retries, one try on one server, and when to hedge
+	Timeout    *time.Duration `yaml:"timeout"`
+	TryTimeout *time.Duration `yaml:"tryTimeout"`
+	HedgeAfter *time.Duration `yaml:"hedgeAfter"`
+	// TLS is the client's own. If it has no CA, the CA of the servers is used.
+	TLS TLS `yaml:"tls"`
+}
+
+// Load reads and checks the file at path. Keys that are not known are an error,
+// so a misspelled setting is not silently ignored.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	// the certificates are found next to the file, wherever it is started from
+	dir := filepath.Dir(path)
+	f.TLS.resolve(dir)
+	f.Client.TLS.resolve(dir)
+	if err := f.Validate(); err != nil {
+		// one line per problem, each starting with the file like the errors of a compiler
+		return nil, fmt.Errorf("%s: %s", path, strings.ReplaceAll(err.Error(), "\n", "\n"+path+": "))
+	}
+	return &f, nil
+}
+
+// Validate checks the values of the file and says which one is wrong and why.
+func (f *File) Validate() error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	seen := make(map[string]int)
+	for i, r := range f.Replicas {
+		if r.Address == "" {
+			add("replicas[%d].address: every replica needs an address", i)
+		} else if err := CheckAddr(r.Address); err != nil {
+			add("replicas[%d].address: %v", i, err)
+		} else if other, ok := seen[r.Address]; ok {
+			add("replicas[%d].address: %s is already the address of replica %d", i, r.Address, other)
+		} else {
+			seen[r.Address] = i
+		}
+		if r.Listen != "" {
+			if err := CheckAddr(r.Listen); err != nil {
+				add("replicas[%d].listen: %v", i, err)
+			}
+		}
+		if r.Metrics != "" {
+			if err := CheckHostPort(r.Metrics); err != nil {
+				add("replicas[%d].metrics: %v", i, err)
+			}
+		}
+	}
+
+	if f.Auction.EndTime != "" {
+		if _, err := time.Parse(time.TimeOnly, f.Auction.EndTime); err != nil {
+			add("auction.endTime: %q is not a time of day like 18:30:00", f.Auction.EndTime)
+		}
+	}
+	if d := f.Auction.RetractWindow; d != nil && *d < 0 {
+		add("auction.retractWindow: can't be negative, use 0 to turn retractions off")
+	}
+	if d := f.Auction.RetractCutoff; d != nil && *d < 0 {
+		add("auction.retractCutoff: can't be negative")
+	}
+	if d := f.Auction.PaymentTimeout; d != nil && *d < 0 {
+		add("auction.paymentTimeout: can't be negative, use 0 to wait forever")
+	}
+	if p := f.Auction.Payments; p != "" && p != "fake" {
+		add("auction.payments: %q is not a payment provider, the only one built in is fake", p)
+	}
+	if r := f.Auction.RateLimit.BidderRate; r != nil && *r < 0 {
+		add("auction.rateLimit.bidderRate: can't be negative, use 0 for no limit")
+	}
+	if n := f.Auction.RateLimit.BidderBurst; n != nil && *n < 0 {
+		add("auction.rateLimit.bidderBurst: can't be negative")
+	}
+	if r := f.Auction.RateLimit.AddrRate; r != nil && *r < 0 {
+		add("auction.rateLimit.addrRate: can't be negative, use 0 for no limit")
+	}
+	if n := f.Auction.RateLimit.AddrBurst; n != nil && *n < 0 {
+		add("auction.rateLimit.addrBurst: can't be negative")
+	}
+
+	for i, u := range f.Webhooks.URLs {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			add("webhooks.urls[%d]: %q is not an http or https URL", i, u)
+		} else if strings.ContainsAny(u, " \t") {
+			add("webhooks.urls[%d]: %q has a space in it", i, u)
+		}
+	}
+
+	if d := f.Client.Timeout; d != nil && *d <= 0 {
+		add("client.timeout: must be positive")
+	}
+	if d := f.Client.TryTimeout; d != nil && *d <= 0 {
+		add("client.tryTimeout: must be positive")
+	}
+	if d := f.Client.HedgeAfter; d != nil && *d < 0 {
+		add("client.hedgeAfter: can't be negative, use 0 to turn hedging off")
+	}
+
+	if err := f.TLS.validate(); err != nil {
+		add("tls: %v", err)
+	}
+	if err := f.Client.TLS.validate(); err != nil {
+		add("client.tls: %v", err)
+	}
+
+	switch strings.ToLower(f.Logging.Format) {
+	case "", "json", "text":
+	default:
+		add("logging.format: %q is not json or text", f.Logging.Format)
+	}
+	switch strings.ToLower(f.Logging.Level) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		add("logging.level: %q is not debug, info, warn or error", f.Logging.Level)
+	}
+	if n := f.Logging.MaxBackups; n != nil && *n < 0 {
+		add("logging.maxBackups: can't be negative")
+	}
+
+	switch f.Tracing.Exporter {
+	case "", "none", "otlp", "file":
+	default:
+		add("tracing.exporter: %q is not otlp or file", f.Tracing.Exporter)
+	}
+	if r := f.Tracing.SampleRatio; r != nil && (*r < 0 || *r > 1) {
+		add("tracing.sampleRatio: %v is not between 0 and 1", *r)
+	}
+
+	if id := f.Client.ID; id != nil && *id < 0 {
+		add("client.id: can't be negative")
+	}
+	return errors.Join(errs...)
+}
+
+// CheckAddr checks that addr is an address a server can listen on and be dialed at:
+// host:port, where the host may be left out as in :8080 and an IPv6 host is in brackets
+// as in [::1]:8080, or a Unix socket as unix:/path.
+func CheckAddr(addr string) error {
+	if strings.HasPrefix(addr, "unix:") {
+		if strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//") == "" {
+			return fmt.Errorf("%q has no path", addr)
+		}
+		return nil
+	}
+	return CheckHostPort(addr)
+}
+
+// CheckHostPort checks that addr is host:port, without the Unix sockets CheckAddr allows.
+func CheckHostPort(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%q is not host:port", addr)
+	}
+	if port == "" {
+		return fmt.Errorf("%q has no port", addr)
+	}
+	return nil
+}
+
+// resolve makes the relative paths relative to dir.
+func (t *TLS) resolve(dir string) {
+	for _, file := range []*string{&t.Cert, &t.Key, &t.CA} {
+		if *file != "" && !filepath.IsAbs(*file) {
+			*file = filepath.Join(dir, *file)
+		}
+	}
+}
+
+func (t TLS) validate() error {
+	if (t.Cert == "") != (t.Key == "") {
+		return errors.New("cert and key must be given together")
+	}
+	for _, file := range []string{t.Cert, t.Key, t.CA} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	negative := -time.Second
+	tooHigh := 2.0
+	tests := []struct {
+		name string
+		file File
+		want []string // what the error says, nil for no error
+	}{
+		{"empty", File{}, nil},
+		{"a good cluster", File{
+			Replicas: []Replica{{Address: "localhost:8080", Metrics: "localhost:9080"}, {Address: "[::1]:8081"}, {Address: "unix:/tmp/auction2.sock"}},
+			Auction:  Auction{EndTime: "18:00:00", Payments: "fake"},
+			Webhooks: Webhooks{URLs: []string{"https://example.com/auction-events"}, Secret: "key"},
+		}, nil},
+		{"replicas", File{Replicas: []Replica{{}, {Address: "localhost"}, {Address: ":8080"}, {Address: ":8080", Listen: "nowhere"}}}, []string{
+			"replicas[0].address: every replica needs an address",
+			`replicas[1].address: "localhost" is not host:port`,
+			"replicas[3].address: :8080 is already the address of replica 2",
+			`replicas[3].listen: "nowhere" is not host:port`,
+		}},
+		{"auction", File{Auction: Auction{EndTime: "6pm", RetractWindow: &negative, PaymentTimeout: &negative, Payments: "stripe"}}, []string{
+			"auction.endTime",
+			"auction.retractWindow: can't be negative",
+			"auction.paymentTimeout: can't be negative",
+			`auction.payments: "stripe" is not a payment provider`,
+		}},
+		{"webhooks", File{Webhooks: Webhooks{URLs: []string{"example.com"}}}, []string{
+			`webhooks.urls[0]: "example.com" is not an http or https URL`,
+		}},
+		{"logging and tracing", File{Logging: Logging{Format: "xml", Level: "loud"}, Tracing: Tracing{Exporter: "jaeger", SampleRatio: &tooHigh}}, []string{
+			`logging.format: "xml" is not json or text`,
+			`logging.level: "loud" is not debug, info, warn or error`,
+			`tracing.exporter: "jaeger" is not otlp or file`,
+			"tracing.sampleRatio: 2 is not between 0 and 1",
+		}},
+		{"tls", File{TLS: TLS{Cert: "server.pem"}}, []string{"tls: cert and key must be given together"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.file.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want %q", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() = %q, want it to say %q", err, want)
+				}
+			}
+			if lines := strings.Count(err.Error(), "\n") + 1; lines != len(tt.want) {
+				t.Errorf("Validate() has %d problems, want %d:\n%v", lines, len(tt.want), err)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cluster.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("replicas:\n  - address: localhost:8080\nauction:\n  endTime: \"18:00:00\"\n")
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Addresses() != "localhost:8080" || f.Auction.EndTime != "18:00:00" {
+		t.Fatalf("Load() = %+v", f)
+	}
+
+	// a misspelled key is not silently left out, and the problems start with the file
+	write("auction:\n  endtime: \"18:00:00\"\n")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "endtime") {
+		t.Fatalf("Load() of a misspelled key = %v", err)
+	}
+	write("replicas:\n  - address: nowhere\n")
+	if _, err := Load(path); err == nil || !strings.HasPrefix(err.Error(), path+": replicas[0].address") {
+		t.Fatalf("Load() of a bad address = %v", err)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for name, want := range map[string]string{
+		"adminToken":     "AUCTION_ADMIN_TOKEN",
+		"tlsCA":          "AUCTION_TLS_CA",
+		"id":             "AUCTION_ID",
+		"caFile":         "AUCTION_CA_FILE",
+		"paymentTimeout": "AUCTION_PAYMENT_TIMEOUT",
+	} {
+		if got := EnvName(name); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// newFlags is a flag set like a server's, parsed from args.
+func newFlags(t *testing.T, args ...string) (*flag.FlagSet, *string, *string, *time.Duration) {
+	t.Helper()
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	endtime := fs.String("endtime", "00:00:00", "")
+	token := fs.String("adminToken", "", "")
+	window := fs.Duration("retractWindow", 30*time.Second, "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs, endtime, token, window
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("AUCTION_ADMIN_TOKEN", "from-env")
+	t.Setenv("AUCTION_ENDTIME", "12:00:00")
+	fs, endtime, token, window := newFlags(t, "-endtime", "18:00:00")
+	if err := ApplyEnv(fs); err != nil {
+		t.Fatal(err)
+	}
+	// the command line wins over the environment
+	if *endtime != "18:00:00" || *token != "from-env" || *window != 30*time.Second {
+		t.Fatalf("after ApplyEnv: endtime %s, token %s, window %v", *endtime, *token, *window)
+	}
+
+	t.Setenv("AUCTION_RETRACT_WINDOW", "soon")
+	fs, _, _, _ = newFlags(t)
+	if err := ApplyEnv(fs); err == nil || !strings.Contains(err.Error(), "AUCTION_RETRACT_WINDOW") {
+		t.Fatalf("ApplyEnv() with a bad duration = %v, want an error naming the variable", err)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	window := time.Minute
+	file := &File{AdminToken: "from-file", Auction: Auction{EndTime: "09:00:00", RetractWindow: &window}}
+	t.Setenv("AUCTION_ADMIN_TOKEN", "from-env")
+	t.Setenv("AUCTION_ENDTIME", "12:00:00")
+
+	// flag > env > file, and the file still fills in what neither gave
+	fs, endtime, token, retractWindow := newFlags(t, "-endtime", "18:00:00")
+	if err := ApplyEnv(fs); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyFile(fs, file.JoinFlags()); err != nil {
+		t.Fatal(err)
+	}
+	if *endtime != "18:00:00" {
+		t.Errorf("endtime is %s, want the flag's 18:00:00", *endtime)
+	}
+	if *token != "from-env" {
+		t.Errorf("adminToken is %s, want the environment's", *token)
+	}
+	if *retractWindow != time.Minute {
+		t.Errorf("retractWindow is %v, want the file's 1m", *retractWindow)
+	}
+}
+
+func TestServerFlags(t *testing.T) {
+	f := &File{Replicas: []Replica{{Address: "localhost:8080"}, {Address: "localhost:8081", Listen: "0.0.0.0:8081", Log: "server1.log"}}}
+	values, err := f.ServerFlags(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["peers"] != "localhost:8080 localhost:8081" || values["listen"] != "0.0.0.0:8081" || values["log"] != "server1.log" {
+		t.Fatalf("ServerFlags(1) = %v", values)
+	}
+	if _, err := f.ServerFlags(2); err == nil {
+		t.Fatalf("ServerFlags(2) of a file with 2 replicas did not fail")
+	}
+}
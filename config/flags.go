@@ -0,0 +1,227 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix starts the names of the environment variables that set the flags.
+const EnvPrefix = "AUCTION_"
+
+// EnvName is the environment variable that sets the flag name: AUCTION_ and the name in
+// upper case, with the words split by underscores. -adminToken is AUCTION_ADMIN_TOKEN,
+// -tlsCA is AUCTION_TLS_CA and -id is AUCTION_ID.
+func EnvName(name string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	runes := []rune(name)
+	for i, r := range runes {
+		// a word starts at an upper case letter after a lower case one,
+		// or at the last upper case letter before a lower case one (the F in CAFile)
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// ApplyEnv sets the flags of fs that were not given on the command line from their environment variables.
+// Call it after fs.Parse.
+func ApplyEnv(fs *flag.FlagSet) error {
+	set := given(fs)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if serr := fs.Set(f.Name, value); serr != nil {
+				err = fmt.Errorf("%s: %v", EnvName(f.Name), serr)
+			}
+		}
+	})
+	return err
+}
+
+// ApplyFile sets the flags of fs that were not set yet, on the command line or by ApplyEnv,
+// to the values from the file. The values are by flag name, see ServerFlags and ClientFlags.
+func ApplyFile(fs *flag.FlagSet, values map[string]string) error {
+	set := given(fs)
+	for name, value := range values {
+		if set[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("-%s from the config file: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// given returns the flags that have been set.
+func given(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// Addresses returns the addresses of the replicas, separated by spaces as the -peers and
+// -serverPorts flags want them.
+func (f *File) Addresses() string {
+	addrs := make([]string, len(f.Replicas))
+	for i, r := range f.Replicas {
+		addrs[i] = r.Address
+	}
+	return strings.Join(addrs, " ")
+}
+
+// ServerFlags returns the values of the server's flags for the server with the given id.
+func (f *File) ServerFlags(id int) (map[string]string, error) {
+	values := f.JoinFlags()
+
+	// what is set for this replica goes over the settings of the cluster
+	if len(f.Replicas) > 0 {
+		if id < 0 || id >= len(f.Replicas) {
+			return nil, fmt.Errorf("there is no replica %d, the config file has %d (ids 0 to %d)", id, len(f.Replicas), len(f.Replicas)-1)
+		}
+		values["peers"] = f.Addresses()
+		r := f.Replicas[id]
+		values["listen"] = r.Address
+		if r.Listen != "" {
+			values["listen"] = r.Listen
+		}
+		setString(values, "metrics", r.Metrics)
+		setString(values, "log", r.Log)
+	}
+	return values, nil
+}
+
+// JoinFlags returns the values of the server's flags for a server that is not in the file yet,
+// because it joins the cluster with -join. It only gets the settings of the whole cluster.
+func (f *File) JoinFlags() map[string]string {
+	values := make(map[string]string)
+	setString(values, "endtime", f.Auction.EndTime)
+	if f.Auction.RetractWindow != nil {
+		values["retractWindow"] = f.Auction.RetractWindow.String()
+	}
+	if f.Auction.RetractCutoff != nil {
+		values["retractCutoff"] = f.Auction.RetractCutoff.String()
+	}
+	if f.Auction.PaymentTimeout != nil {
+		values["paymentTimeout"] = f.Auction.PaymentTimeout.String()
+	}
+	setString(values, "payments", f.Auction.Payments)
+	f.Auction.RateLimit.flags(values)
+	setString(values, "adminToken", f.AdminToken)
+	setString(values, "clusterToken", f.ClusterToken)
+	if len(f.Webhooks.URLs) > 0 {
+		values["webhooks"] = strings.Join(f.Webhooks.URLs, " ")
+	}
+	setString(values, "webhookSecret", f.Webhooks.Secret)
+	f.TLS.flags(values)
+	f.Logging.flags(values)
+	f.Tracing.flags(values)
+	return values
+}
+
+// ClientFlags returns the values of the client's flags.
+func (f *File) ClientFlags() map[string]string {
+	values := make(map[string]string)
+	if len(f.Replicas) > 0 {
+		values["serverPorts"] = f.Addresses()
+	}
+	if f.Client.ID != nil {
+		values["id"] = fmt.Sprint(*f.Client.ID)
+	}
+	setString(values, "name", f.Client.Name)
+	setString(values, "history", f.Client.History)
+	if f.Client.Timeout != nil {
+		values["timeout"] = f.Client.Timeout.String()
+	}
+	if f.Client.TryTimeout != nil {
+		values["tryTimeout"] = f.Client.TryTimeout.String()
+	}
+	if f.Client.HedgeAfter != nil {
+		values["hedgeAfter"] = f.Client.HedgeAfter.String()
+	}
+	tls := f.Client.TLS
+	if tls.CA == "" {
+		tls.CA = f.TLS.CA
+	}
+	tls.flags(values)
+	// the client logs to its own file, only how it logs comes from the servers' settings
+	logging := f.Logging
+	logging.Output = f.Client.Log
+	logging.flags(values)
+	f.Tracing.flags(values)
+	return values
+}
+
+// CtlFlags returns the values of auctionctl's flags.
+func (f *File) CtlFlags() map[string]string {
+	values := make(map[string]string)
+	if len(f.Replicas) > 0 {
+		values["serverPorts"] = f.Addresses()
+	}
+	setString(values, "token", f.AdminToken)
+	tls := f.Client.TLS
+	if tls.CA == "" {
+		tls.CA = f.TLS.CA
+	}
+	tls.flags(values)
+	return values
+}
+
+func (t TLS) flags(values map[string]string) {
+	setString(values, "tlsCert", t.Cert)
+	setString(values, "tlsKey", t.Key)
+	setString(values, "tlsCA", t.CA)
+}
+
+func (l Logging) flags(values map[string]string) {
+	setString(values, "log", l.Output)
+	setString(values, "logFormat", l.Format)
+	setString(values, "logLevel", l.Level)
+	if l.MaxSize != nil {
+		values["logMaxSize"] = fmt.Sprint(*l.MaxSize)
+	}
+	if l.MaxBackups != nil {
+		values["logBackups"] = fmt.Sprint(*l.MaxBackups)
+	}
+}
+
+func (r RateLimit) flags(values map[string]string) {
+	if r.BidderRate != nil {
+		values["bidderRate"] = fmt.Sprint(*r.BidderRate)
+	}
+	if r.BidderBurst != nil {
+		values["bidderBurst"] = fmt.Sprint(*r.BidderBurst)
+	}
+	if r.AddrRate != nil {
+		values["addrRate"] = fmt.Sprint(*r.AddrRate)
+	}
+	if r.AddrBurst != nil {
+		values["addrBurst"] = fmt.Sprint(*r.AddrBurst)
+	}
+}
+
+func (t Tracing) flags(values map[string]string) {
+	setString(values, "trace", t.Exporter)
+	setString(values, "traceEndpoint", t.Endpoint)
+	if t.SampleRatio != nil {
+		values["traceSample"] = fmt.Sprint(*t.SampleRatio)
+	}
+}
+
+// setString only sets values that are in the file, so the others keep the default of their flag.
+func setString(values map[string]string, name, value string) {
+	if value != "" {
+		values[name] = value
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ServerTLS is the TLS a server serves with. Without a cert it serves without TLS and nil is returned.
+// With a CA the certificates of the callers that send one are checked against it, while callers
+// without a certificate can still connect. A verified certificate only names a bidder, for the rate
+// limit and to confirm a payment. The replicas prove who they are with the cluster token, not with it.
+func ServerTLS(cert, key, ca string) (*tls.Config, error) {
+	if cert == "" {
+		return nil, nil
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %v", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+	if ca != "" {
+		pool, err := loadCA(ca)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// ClientTLS is the TLS to dial the servers with. Without a CA the servers are dialed without TLS
+// and nil is returned. A cert is sent to the servers if there is one.
+func ClientTLS(cert, key, ca string) (*tls.Config, error) {
+	if ca == "" {
+		if cert != "" {
+			return nil, errors.New("tls: a client certificate needs the CA of the servers too")
+		}
+		return nil, nil
+	}
+	pool, err := loadCA(ca)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg, nil
+}
+
+func loadCA(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls: no certificates in %s", path)
+	}
+	return pool, nil
+}
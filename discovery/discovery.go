@@ -0,0 +1,199 @@
+// Package discovery finds the replicas of an auction cluster, so the clients don't need a
+// hardcoded list of addresses. A cluster is registered under a name with the Source its replicas
+// come from, and is then dialed as auction:///name, by auctionclient or by any gRPC client:
+//
+//	discovery.Register("prod", discovery.DNS("_auction._tcp.example.com"))
+//	c, err := auctionclient.New(auctionclient.Config{Servers: []string{discovery.Target("prod")}})
+//
+// The replicas are looked up again now and then, so the clients follow the cluster when
+// replicas are added or removed.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/config"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Member is one replica.
+type Member struct {
+	ID   int
+	Addr string
+}
+
+// Source is where the replicas of a cluster come from.
+type Source interface {
+	// Members returns the replicas right now.
+	Members(ctx context.Context) ([]Member, error)
+}
+
+// SourceFunc lets a function be a Source.
+type SourceFunc func(ctx context.Context) ([]Member, error)
+
+func (f SourceFunc) Members(ctx context.Context) ([]Member, error) {
+	return f(ctx)
+}
+
+var (
+	mutex    sync.Mutex
+	clusters = make(map[string]Source)
+)
+
+// Register makes the cluster called name use src. Registering a name again replaces its source.
+func Register(name string, src Source) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	clusters[name] = src
+}
+
+// Lookup returns the source of the cluster called name.
+func Lookup(name string) (Source, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	src, ok := clusters[name]
+	return src, ok
+}
+
+// Target is what the cluster called name is dialed as.
+func Target(name string) string {
+	return Scheme + ":///" + name
+}
+
+// ClusterName returns the name of the cluster in target, or false if target is not an auction:/// target.
+func ClusterName(target string) (string, bool) {
+	prefix := Scheme + ":///"
+	if !strings.HasPrefix(target, prefix) || len(target) == len(prefix) {
+		return "", false
+	}
+	return target[len(prefix):], true
+}
+
+// File reads the replicas from a cluster file (see the config package) every time it is asked,
+// so editing the file changes the cluster. The index in the file is the id.
+func File(path string) Source {
+	return SourceFunc(func(ctx context.Context) ([]Member, error) {
+		f, err := config.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(f.Replicas) == 0 {
+			return nil, fmt.Errorf("%s has no replicas", path)
+		}
+		members := make([]Member, len(f.Replicas))
+		for i, r := range f.Replicas {
+			members[i] = Member{ID: i, Addr: r.Address}
+		}
+		return members, nil
+	})
+}
+
+// DNS looks the replicas up as the SRV records of name, e.g. _auction._tcp.example.com.
+// SRV records have no ids, so the replicas are numbered in the order of their priority,
+// weight and target. Give the replicas the same ids when they are started.
+func DNS(name string) Source {
+	return SourceFunc(func(ctx context.Context) ([]Member, error) {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(records, func(i, j int) bool {
+			a, b := records[i], records[j]
+			if a.Priority != b.Priority {
+				return a.Priority < b.Priority
+			}
+			if a.Weight != b.Weight {
+				return a.Weight > b.Weight
+			}
+			return a.Target < b.Target
+		})
+		members := make([]Member, len(records))
+		for i, r := range records {
+			members[i] = Member{ID: i, Addr: net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))}
+		}
+		return members, nil
+	})
+}
+
+// Servers asks the given servers for the members of the cluster, the first one that answers
+// is used. Any live replica will do, so the list does not have to be complete or up to date.
+// opts are the options the servers are dialed with, the default is a plain connection without TLS.
+func Servers(addrs []string, opts ...grpc.DialOption) Source {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return SourceFunc(func(ctx context.Context) ([]Member, error) {
+		var errs []error
+		for _, addr := range addrs {
+			members, err := askMembers(ctx, addr, opts)
+			if err == nil {
+				return members, nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %v", addr, err))
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, errors.Join(errs...)
+	})
+}
+
+func askMembers(ctx context.Context, addr string, opts []grpc.DialOption) ([]Member, error) {
+	conn, err := grpc.DialContext(ctx, addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	list, err := gRPC.NewAuctionServiceClient(conn).Members(ctx, &gRPC.Void{})
+	if err != nil {
+		return nil, err
+	}
+	return FromProto(list.Members)
+}
+
+// FromProto turns the answer of the Members call into Members.
+// A replica that is still joining knows of no members, that is an error.
+func FromProto(list []*gRPC.Member) ([]Member, error) {
+	if len(list) == 0 {
+		return nil, errors.New("the server does not know the members yet")
+	}
+	members := make([]Member, len(list))
+	for i, m := range list {
+		members[i] = Member{ID: int(m.ServerID), Addr: m.Address}
+	}
+	return members, nil
+}
+
+// Parse makes a Source from a flag value:
+//
+//	file:cluster.yaml                    the replicas of a cluster file
+//	dns:_auction._tcp.example.com        the SRV records of a name
+//	members:host1:8080 host2:8080        the Members call of any of these servers
+//
+// opts are used to dial the servers for members:.
+func Parse(spec string, opts ...grpc.DialOption) (Source, error) {
+	kind, value, ok := strings.Cut(spec, ":")
+	if !ok || value == "" {
+		return nil, fmt.Errorf("discovery: %q is not file:<path>, dns:<name> or members:<addresses>", spec)
+	}
+	switch kind {
+	case "file":
+		return File(value), nil
+	case "dns":
+		return DNS(value), nil
+	case "members":
+		return Servers(strings.Fields(value), opts...), nil
+	default:
+		return nil, fmt.Errorf("discovery: %q is not file, dns or members", kind)
+	}
+}
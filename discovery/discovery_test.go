@@ -0,0 +1,189 @@
+package discovery_test
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver/auctiontest"
+	"github.com/Alex-itu/A_Distributed_Auction_System/discovery"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+func TestClusterName(t *testing.T) {
+	if name, ok := discovery.ClusterName(discovery.Target("prod")); !ok || name != "prod" {
+		t.Fatalf("ClusterName(Target(prod)) = %q, %v", name, ok)
+	}
+	for _, target := range []string{"auction:///", "localhost:8080", "dns:///example.com"} {
+		if name, ok := discovery.ClusterName(target); ok {
+			t.Errorf("ClusterName(%q) = %q, want no cluster", target, name)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, spec := range []string{"file:cluster.yaml", "dns:_auction._tcp.example.com", "members:host1:8080 host2:8080"} {
+		if _, err := discovery.Parse(spec); err != nil {
+			t.Errorf("Parse(%q): %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"cluster.yaml", "file:", "consul:auction"} {
+		if _, err := discovery.Parse(spec); err == nil {
+			t.Errorf("Parse(%q) did not fail", spec)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cluster.yaml")
+	if err := os.WriteFile(path, []byte("replicas:\n  - address: localhost:8080\n  - address: localhost:8081\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src := discovery.File(path)
+	members, err := src.Members(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []discovery.Member{{ID: 0, Addr: "localhost:8080"}, {ID: 1, Addr: "localhost:8081"}}
+	if !reflect.DeepEqual(members, want) {
+		t.Fatalf("Members() = %v, want %v", members, want)
+	}
+
+	// the file is read again every time
+	if err := os.WriteFile(path, []byte("auction:\n  endTime: \"18:00:00\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if members, err := src.Members(context.Background()); err == nil {
+		t.Fatalf("Members() of a file without replicas = %v", members)
+	}
+}
+
+func TestFromProto(t *testing.T) {
+	members, err := discovery.FromProto([]*gRPC.Member{{ServerID: 2, Address: "replica2"}})
+	if err != nil || !reflect.DeepEqual(members, []discovery.Member{{ID: 2, Addr: "replica2"}}) {
+		t.Fatalf("FromProto() = %v, %v", members, err)
+	}
+	// a replica that is still joining knows of no members
+	if _, err := discovery.FromProto(nil); err == nil {
+		t.Fatal("FromProto(nil) did not fail")
+	}
+}
+
+// fakeConn is the resolver.ClientConn of a grpc.ClientConn, it passes on the addresses the resolver sends.
+type fakeConn struct {
+	updates chan []resolver.Address
+}
+
+func (cc *fakeConn) UpdateState(state resolver.State) error {
+	cc.updates <- state.Addresses
+	return nil
+}
+
+func (cc *fakeConn) ReportError(error)             {}
+func (cc *fakeConn) NewAddress([]resolver.Address) {}
+func (cc *fakeConn) NewServiceConfig(string)       {}
+func (cc *fakeConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+func TestResolver(t *testing.T) {
+	var mutex sync.Mutex
+	members := []discovery.Member{{ID: 0, Addr: "replica0"}, {ID: 1, Addr: "replica1"}}
+	discovery.Register("resolver-test", discovery.SourceFunc(func(ctx context.Context) ([]discovery.Member, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return members, nil
+	}))
+
+	builder := resolver.Get(discovery.Scheme)
+	if builder == nil {
+		t.Fatalf("no resolver is registered for %s", discovery.Scheme)
+	}
+	if _, err := builder.Build(target(t, discovery.Target("nobody")), &fakeConn{}, resolver.BuildOptions{}); err == nil {
+		t.Fatal("Build() of a cluster that is not registered did not fail")
+	}
+	cc := &fakeConn{updates: make(chan []resolver.Address, 10)}
+	r, err := builder.Build(target(t, discovery.Target("resolver-test")), cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	next := func() map[int]string {
+		t.Helper()
+		select {
+		case addrs := <-cc.updates:
+			got := make(map[int]string)
+			for _, addr := range addrs {
+				id, ok := discovery.ID(addr)
+				if !ok {
+					t.Fatalf("%s has no id", addr.Addr)
+				}
+				got[id] = addr.Addr
+			}
+			return got
+		case <-time.After(5 * time.Second):
+			t.Fatal("the resolver sent nothing")
+			return nil
+		}
+	}
+	if got := next(); !reflect.DeepEqual(got, map[int]string{0: "replica0", 1: "replica1"}) {
+		t.Fatalf("the resolver sent %v", got)
+	}
+
+	// a replica is added, gRPC asks for the replicas again when a connection fails
+	mutex.Lock()
+	members = append(members, discovery.Member{ID: 2, Addr: "replica2"})
+	mutex.Unlock()
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	if got := next(); len(got) != 3 || got[2] != "replica2" {
+		t.Fatalf("the resolver sent %v after a replica was added", got)
+	}
+}
+
+func target(t *testing.T, s string) resolver.Target {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolver.Target{URL: *u}
+}
+
+func TestServers(t *testing.T) {
+	c := auctiontest.NewCluster(t, 3)
+	c.WaitForLeader()
+
+	// a server that is down is skipped, any replica knows them all
+	src := discovery.Servers([]string{"nowhere", c.Addrs[2]}, c.DialOptions()...)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	members, err := src.Members(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []discovery.Member{{ID: 0, Addr: c.Addrs[0]}, {ID: 1, Addr: c.Addrs[1]}, {ID: 2, Addr: c.Addrs[2]}}
+	if !reflect.DeepEqual(members, want) {
+		t.Fatalf("Members() = %v, want %v", members, want)
+	}
+
+	// and any gRPC client can dial the cluster by its name
+	discovery.Register("servers-test", src)
+	conn, err := grpc.Dial(discovery.Target("servers-test"), c.DialOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if list, err := gRPC.NewAuctionServiceClient(conn).Members(ctx, &gRPC.Void{}); err != nil || len(list.Members) != 3 {
+		t.Fatalf("Members through auction:///servers-test: %v, %v", list, err)
+	}
+}
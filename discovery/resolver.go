@@ -0,0 +1,109 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the scheme of the targets the resolver handles, auction:///name.
+const Scheme = "auction"
+
+// RefreshInterval is how often the resolver looks the replicas up again.
+// gRPC asks for a lookup right away when a connection fails, so it does not need to be short.
+var RefreshInterval = 10 * time.Second
+
+const lookupTimeout = 5 * time.Second
+
+func init() {
+	resolver.Register(builder{})
+}
+
+// idKey is the attribute of a resolved address that has the id of the replica.
+type idKey struct{}
+
+// ID returns the id of the replica a resolved address belongs to.
+func ID(addr resolver.Address) (int, bool) {
+	id, ok := addr.Attributes.Value(idKey{}).(int)
+	return id, ok
+}
+
+// builder makes a resolver for every grpc.Dial of an auction:/// target.
+type builder struct{}
+
+func (builder) Scheme() string {
+	return Scheme
+}
+
+func (builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	name := target.Endpoint()
+	src, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("discovery: no cluster called %q is registered", name)
+	}
+	r := &clusterResolver{src: src, cc: cc, now: make(chan struct{}, 1)}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.wg.Add(1)
+	go r.watch()
+	return r, nil
+}
+
+// clusterResolver looks up the replicas of one cluster every RefreshInterval,
+// or sooner when gRPC asks, and hands them to the ClientConn.
+type clusterResolver struct {
+	src    Source
+	cc     resolver.ClientConn
+	now    chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (r *clusterResolver) watch() {
+	defer r.wg.Done()
+	for {
+		r.lookup()
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.now:
+		case <-time.After(RefreshInterval):
+		}
+	}
+}
+
+func (r *clusterResolver) lookup() {
+	ctx, cancel := context.WithTimeout(r.ctx, lookupTimeout)
+	defer cancel()
+	members, err := r.src.Members(ctx)
+	if err != nil {
+		if r.ctx.Err() == nil {
+			r.cc.ReportError(err)
+		}
+		return
+	}
+	state := resolver.State{}
+	for _, m := range members {
+		state.Addresses = append(state.Addresses, resolver.Address{
+			Addr:       m.Addr,
+			Attributes: attributes.New(idKey{}, m.ID),
+		})
+	}
+	r.cc.UpdateState(state)
+}
+
+func (r *clusterResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.now <- struct{}{}:
+	default:
+	}
+}
+
+func (r *clusterResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
@@ -0,0 +1,131 @@
+package events
+
+import (
+	"time"
+
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+)
+
+// Bid is the current bid of a bidder.
+type Bid struct {
+	Amount float32
+	Time   time.Time
+}
+
+// Auction is the state of the auction, folded from its events.
+type Auction struct {
+	EndTime   time.Time
+	Names     map[int32]string // the name every bidder last bid with, also when their bid is gone
+	Bids      map[int32]Bid    // the current bid of every bidder that has one
+	Banned    map[int32]bool
+	Budgets   map[int32]float32 // the most a bidder can spend, only for the bidders that have a budget
+	Over      bool
+	Cancelled bool // called off by an admin, so there is no winner
+	Created   bool // AuctionCreated was applied
+
+	// the settlement after the close, see auctionserver/settlement.go
+	Settlement gRPC.Settlement
+	Buyer      int32          // who has to pay, the winner or a bidder after them
+	Price      float32        // what the buyer has to pay, their bid
+	BuyerSince time.Time      // when the buyer became the buyer, their time to pay starts then
+	Defaulted  map[int32]bool // the bidders that did not pay in time
+
+	extended bool
+}
+
+// NewAuction is the auction before its first event. endTime is when it ends until an event says otherwise.
+func NewAuction(endTime time.Time) *Auction {
+	return &Auction{EndTime: endTime, Names: make(map[int32]string), Bids: make(map[int32]Bid), Banned: make(map[int32]bool),
+		Budgets: make(map[int32]float32), Defaulted: make(map[int32]bool)}
+}
+
+// Fold applies the events in order to a new auction.
+func Fold(endTime time.Time, events []Event) *Auction {
+	a := NewAuction(endTime)
+	for _, e := range events {
+		a.Apply(e)
+	}
+	return a
+}
+
+// Apply changes the auction by e. The events that are not about the auction itself are left out.
+func (a *Auction) Apply(e Event) {
+	switch e.Type {
+	case AuctionCreated:
+		a.Created = true
+		// a cluster that ran before the auctions were created can have extended it already
+		if !a.extended && !e.EndTime.IsZero() {
+			a.EndTime = e.EndTime
+		}
+	case BidPlaced:
+		a.Names[e.ClientID] = e.ClientName
+		a.Bids[e.ClientID] = Bid{Amount: e.Amount, Time: e.Time}
+	case BidRetracted:
+		// the client no longer has a bid, so the highest bid is whatever is left in Bids
+		delete(a.Bids, e.ClientID)
+	case BidderBanned:
+		// a banned bidder's bid no longer counts
+		a.Banned[e.ClientID] = true
+		delete(a.Bids, e.ClientID)
+	case AuctionExtended:
+		if !e.EndTime.IsZero() {
+			a.EndTime = e.EndTime
+			a.extended = true
+		}
+	case BudgetSet:
+		if e.Amount > 0 {
+			a.Budgets[e.ClientID] = e.Amount
+		} else {
+			delete(a.Budgets, e.ClientID)
+		}
+	case AuctionClosed:
+		a.Over = true
+		// the winner has to pay, nobody does for a cancelled auction or one without bids
+		if maxid, max := a.Highest(); !a.Cancelled && maxid >= 0 {
+			a.Settlement = gRPC.Settlement_AWAITING_PAYMENT
+			a.Buyer, a.Price, a.BuyerSince = maxid, max, e.Time
+		}
+	case AuctionCancelled:
+		a.Over = true
+		a.Cancelled = true
+	case PaymentConfirmed:
+		a.Settlement = gRPC.Settlement_PAID
+	case BuyerDefaulted:
+		a.Settlement = gRPC.Settlement_DEFAULTED
+		a.Defaulted[e.ClientID] = true
+	case OfferedToNext:
+		a.Settlement = gRPC.Settlement_OFFERED_TO_RUNNER_UP
+		a.Buyer, a.Price, a.BuyerSince = e.ClientID, e.Amount, e.Time
+	}
+}
+
+// AwaitingPayment reports if somebody has to pay right now.
+func (a *Auction) AwaitingPayment() bool {
+	return a.Settlement == gRPC.Settlement_AWAITING_PAYMENT || a.Settlement == gRPC.Settlement_OFFERED_TO_RUNNER_UP
+}
+
+// RunnerUp returns the highest bidder that has not defaulted and their bid, or -1 and -1 if there is none.
+func (a *Auction) RunnerUp() (int32, float32) {
+	max := float32(-1.0)
+	maxid := int32(-1)
+	for id, bid := range a.Bids {
+		if !a.Defaulted[id] && bid.Amount > max {
+			max = bid.Amount
+			maxid = id
+		}
+	}
+	return maxid, max
+}
+
+// Highest returns the bidder with the highest bid and the bid, or -1 and -1 if there is none.
+func (a *Auction) Highest() (int32, float32) {
+	max := float32(-1.0)
+	maxid := int32(-1)
+	for id, bid := range a.Bids {
+		if bid.Amount > max {
+			max = bid.Amount
+			maxid = id
+		}
+	}
+	return maxid, max
+}
@@ -0,0 +1,149 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+)
+
+var start = time.Unix(1700000000, 0)
+
+// at is an event of a bidder, sec seconds after start
+func at(sec int, t Type, client int32, amount float32) Event {
+	return Event{Type: t, Time: start.Add(time.Duration(sec) * time.Second), ClientID: client, ClientName: "bidder", Amount: amount}
+}
+
+func TestFoldBids(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  []Event
+		winner  int32
+		highest float32
+	}{
+		{"no bids", nil, -1, -1},
+		{"the highest bid", []Event{
+			at(1, BidPlaced, 1, 10),
+			at(2, BidPlaced, 2, 20),
+			at(3, BidPlaced, 1, 15),
+		}, 2, 20},
+		{"a retracted bid no longer counts", []Event{
+			at(1, BidPlaced, 1, 10),
+			at(2, BidPlaced, 2, 20),
+			at(3, BidRetracted, 2, 0),
+		}, 1, 10},
+		{"a banned bidder no longer counts", []Event{
+			at(1, BidPlaced, 1, 10),
+			at(2, BidPlaced, 2, 20),
+			at(3, BidderBanned, 2, 0),
+		}, 1, 10},
+		{"a rejected bid changes nothing", []Event{
+			at(1, BidPlaced, 1, 10),
+			at(2, BidRejected, 2, 5),
+		}, 1, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Fold(start, tt.events)
+			if winner, highest := a.Highest(); winner != tt.winner || highest != tt.highest {
+				t.Fatalf("Highest() = %d, %v, want %d, %v", winner, highest, tt.winner, tt.highest)
+			}
+		})
+	}
+}
+
+func TestFoldEndTime(t *testing.T) {
+	created := start.Add(time.Hour)
+	extended := start.Add(2 * time.Hour)
+
+	a := Fold(start, []Event{{Type: AuctionCreated, EndTime: created}})
+	if !a.Created || !a.EndTime.Equal(created) {
+		t.Fatalf("after AuctionCreated: created %v, end time %v", a.Created, a.EndTime)
+	}
+	a = Fold(start, []Event{{Type: AuctionCreated, EndTime: created}, {Type: AuctionExtended, EndTime: extended}})
+	if !a.EndTime.Equal(extended) {
+		t.Fatalf("after AuctionExtended the end time is %v, want %v", a.EndTime, extended)
+	}
+	// a cluster that extended the auction before it was created keeps the extension
+	a = Fold(start, []Event{{Type: AuctionExtended, EndTime: extended}, {Type: AuctionCreated, EndTime: created}})
+	if !a.EndTime.Equal(extended) {
+		t.Fatalf("an AuctionCreated after the extension set the end time to %v", a.EndTime)
+	}
+}
+
+func TestFoldBudgets(t *testing.T) {
+	a := Fold(start, []Event{at(1, BudgetSet, 1, 100), at(2, BudgetSet, 2, 50)})
+	if a.Budgets[1] != 100 || a.Budgets[2] != 50 {
+		t.Fatalf("Budgets = %v", a.Budgets)
+	}
+	a.Apply(at(3, BudgetSet, 1, 0))
+	if _, ok := a.Budgets[1]; ok {
+		t.Fatalf("a budget of 0 did not take the budget away: %v", a.Budgets)
+	}
+}
+
+func TestFoldSettlement(t *testing.T) {
+	a := Fold(start, []Event{
+		at(1, BidPlaced, 1, 10),
+		at(2, BidPlaced, 2, 20),
+		at(3, BidPlaced, 3, 15),
+		at(4, AuctionClosed, 0, 0),
+	})
+	check := func(step string, settlement gRPC.Settlement, buyer int32, price float32, since int) {
+		t.Helper()
+		if a.Settlement != settlement || a.Buyer != buyer || a.Price != price || !a.BuyerSince.Equal(start.Add(time.Duration(since)*time.Second)) {
+			t.Fatalf("after %s: %v, buyer %d for %v since %v, want %v, buyer %d for %v after %ds",
+				step, a.Settlement, a.Buyer, a.Price, a.BuyerSince, settlement, buyer, price, since)
+		}
+	}
+	if !a.Over || !a.AwaitingPayment() {
+		t.Fatalf("the closed auction is over %v and awaiting payment %v", a.Over, a.AwaitingPayment())
+	}
+	check("the close", gRPC.Settlement_AWAITING_PAYMENT, 2, 20, 4)
+
+	a.Apply(at(5, BuyerDefaulted, 2, 20))
+	if a.AwaitingPayment() {
+		t.Fatalf("nobody should have to pay after the buyer defaulted")
+	}
+	check("the default", gRPC.Settlement_DEFAULTED, 2, 20, 4)
+	if next, bid := a.RunnerUp(); next != 3 || bid != 15 {
+		t.Fatalf("RunnerUp() = %d, %v, want 3, 15", next, bid)
+	}
+
+	a.Apply(at(6, OfferedToNext, 3, 15))
+	check("the offer", gRPC.Settlement_OFFERED_TO_RUNNER_UP, 3, 15, 6)
+
+	a.Apply(at(7, BuyerDefaulted, 3, 15))
+	if next, bid := a.RunnerUp(); next != 1 || bid != 10 {
+		t.Fatalf("RunnerUp() after two defaults = %d, %v, want 1, 10", next, bid)
+	}
+	a.Apply(at(8, OfferedToNext, 1, 10))
+	a.Apply(at(9, PaymentConfirmed, 1, 10))
+	check("the payment", gRPC.Settlement_PAID, 1, 10, 8)
+
+	// the winner is still the winner, the settlement is about who pays
+	if winner, _ := a.Highest(); winner != 2 {
+		t.Fatalf("Highest() = %d after the settlement, want 2", winner)
+	}
+}
+
+func TestFoldNoSettlement(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+	}{
+		{"no bids", []Event{at(1, AuctionClosed, 0, 0)}},
+		{"cancelled", []Event{at(1, BidPlaced, 1, 10), at(2, AuctionCancelled, 0, 0), at(3, AuctionClosed, 0, 0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Fold(start, tt.events)
+			if !a.Over || a.Settlement != gRPC.Settlement_NONE || a.AwaitingPayment() {
+				t.Fatalf("over %v, settlement %v, want over and no settlement", a.Over, a.Settlement)
+			}
+		})
+	}
+	if next, _ := Fold(start, nil).RunnerUp(); next != -1 {
+		t.Fatalf("RunnerUp() without bids = %d, want -1", next)
+	}
+}
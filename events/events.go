@@ -0,0 +1,112 @@
+// Package events is the auction as a stream of domain events. The audit log of the servers is the
+// event store: every record that does something to the auction is an event, and the state of the
+// auction is what folding its events in order with Auction.Apply gives.
+//
+// Read models, like a leaderboard or the history of every bidder, are projections: anything with
+// an Apply method that is given the same events, e.g. from the ReplayEvents call of the admin
+// service. They are built next to the servers and never touch the path the bids are written on.
+//
+//	board := events.NewLeaderboard()
+//	events.Project(replayed, board)
+//	for _, entry := range board.Top(10) { ... }
+package events
+
+import (
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+	gRPC "github.com/Alex-itu/A_Distributed_Auction_System/proto"
+)
+
+// Type is what happened.
+type Type string
+
+const (
+	AuctionCreated   Type = "AuctionCreated" // the first leader started the auction, EndTime is when it ends
+	BidPlaced        Type = "BidPlaced"
+	BidRejected      Type = "BidRejected" // Reason says why, it changes nothing
+	BidRetracted     Type = "BidRetracted"
+	BidderBanned     Type = "BidderBanned"
+	BudgetSet        Type = "BudgetSet" // Amount is the budget, 0 takes it away
+	AuctionExtended  Type = "AuctionExtended"
+	AuctionClosed    Type = "AuctionClosed"
+	AuctionCancelled Type = "AuctionCancelled"
+	// the settlement after the close, ClientID is the buyer and Amount the price
+	PaymentConfirmed Type = "PaymentConfirmed"
+	BuyerDefaulted   Type = "BuyerDefaulted"
+	OfferedToNext    Type = "OfferedToNext"
+)
+
+// Event is one thing that happened to the auction.
+type Event struct {
+	Seq        int64 // the audit log record the event is stored in, for a BidRejected the last record before it
+	Type       Type
+	Time       time.Time
+	ClientID   int32
+	ClientName string
+	Amount     float32
+	Reason     string    // why, or the reference of a payment
+	EndTime    time.Time // when the auction ends, for AuctionCreated and AuctionExtended
+}
+
+// kinds are the audit log records that are events. The others (config, members, delivered) are
+// about the replicas and not the auction.
+var kinds = map[string]Type{
+	audit.KindCreated:   AuctionCreated,
+	audit.KindBid:       BidPlaced,
+	audit.KindRejected:  BidRejected,
+	audit.KindRetract:   BidRetracted,
+	audit.KindBan:       BidderBanned,
+	audit.KindBudget:    BudgetSet,
+	audit.KindExtend:    AuctionExtended,
+	audit.KindClose:     AuctionClosed,
+	audit.KindCancel:    AuctionCancelled,
+	audit.KindPaid:      PaymentConfirmed,
+	audit.KindDefaulted: BuyerDefaulted,
+	audit.KindOffered:   OfferedToNext,
+}
+
+// FromRecord returns the event stored in r, or false if r is not an event.
+func FromRecord(r audit.Record) (Event, bool) {
+	t, ok := kinds[r.Kind]
+	if !ok {
+		return Event{}, false
+	}
+	e := Event{Seq: r.Seq, Type: t, Time: time.Unix(0, r.Time), ClientID: r.ClientID, ClientName: r.ClientName, Amount: r.Amount, Reason: r.Detail}
+	if t == AuctionCreated || t == AuctionExtended {
+		// the end time is what Detail holds for these two
+		e.Reason = ""
+		e.EndTime, _ = time.Parse(time.RFC3339, r.Detail)
+	}
+	return e, true
+}
+
+// ToProto is e as it is sent by ReplayEvents.
+func ToProto(e Event) *gRPC.DomainEvent {
+	pe := &gRPC.DomainEvent{Seq: e.Seq, Type: string(e.Type), Time: e.Time.UnixNano(), ClientID: e.ClientID, ClientName: e.ClientName, Amount: e.Amount, Reason: e.Reason}
+	if !e.EndTime.IsZero() {
+		pe.EndTime = e.EndTime.UnixNano()
+	}
+	return pe
+}
+
+// FromProto is the event ReplayEvents sent.
+func FromProto(pe *gRPC.DomainEvent) Event {
+	e := Event{Seq: pe.Seq, Type: Type(pe.Type), Time: time.Unix(0, pe.Time), ClientID: pe.ClientID, ClientName: pe.ClientName, Amount: pe.Amount, Reason: pe.Reason}
+	if pe.EndTime != 0 {
+		e.EndTime = time.Unix(0, pe.EndTime)
+	}
+	return e
+}
+
+// Filter picks the events a replay sends. The zero Filter picks every event.
+type Filter struct {
+	FromSeq   int64          // only the events from this record on
+	Types     map[Type]bool  // only these types, nil for all
+	ClientIDs map[int32]bool // only the events of these bidders, nil for all
+}
+
+// Match reports if e is picked.
+func (f Filter) Match(e Event) bool {
+	return e.Seq >= f.FromSeq && (f.Types == nil || f.Types[e.Type]) && (f.ClientIDs == nil || f.ClientIDs[e.ClientID])
+}
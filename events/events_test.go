@@ -0,0 +1,26 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+)
+
+func TestProtoKeepsTheEndTime(t *testing.T) {
+	// an auction started with time.Now() ends at a time with nanoseconds
+	end := start.Add(time.Hour + 123456789*time.Nanosecond)
+	r := audit.Record{Seq: 1, Kind: audit.KindCreated, Time: start.UnixNano(), Detail: end.Format(time.RFC3339Nano)}
+	e, ok := FromRecord(r)
+	if !ok || !e.EndTime.Equal(end) {
+		t.Fatalf("FromRecord() = %+v, %v, want it to end at %v", e, ok, end)
+	}
+	back := FromProto(ToProto(e))
+	if !back.EndTime.Equal(end) || !back.Time.Equal(start) {
+		t.Fatalf("after ToProto and FromProto the event starts at %v and ends at %v, want %v and %v", back.Time, back.EndTime, start, end)
+	}
+	// an event without an end time has none after the round trip either
+	if bid := FromProto(ToProto(at(1, BidPlaced, 1, 10))); !bid.EndTime.IsZero() {
+		t.Fatalf("a BidPlaced has the end time %v", bid.EndTime)
+	}
+}
@@ -0,0 +1,110 @@
+package events
+
+import (
+	"sort"
+	"sync"
+)
+
+// Projection is a read model built from the events. It gets every event once, in order.
+type Projection interface {
+	Apply(e Event)
+}
+
+// Project gives every event from ch to the projections, until ch is closed.
+func Project(ch <-chan Event, projections ...Projection) {
+	for e := range ch {
+		for _, p := range projections {
+			p.Apply(e)
+		}
+	}
+}
+
+// Entry is a bidder on the leaderboard.
+type Entry struct {
+	ClientID   int32
+	ClientName string
+	Amount     float32 // their current bid
+	Bids       int     // how many bids they made, also the ones that were taken back
+}
+
+// Leaderboard is every bidder that has a bid, highest first. It is safe to read while it is built.
+type Leaderboard struct {
+	mutex   sync.Mutex
+	entries map[int32]*Entry
+	bids    map[int32]int
+}
+
+func NewLeaderboard() *Leaderboard {
+	return &Leaderboard{entries: make(map[int32]*Entry), bids: make(map[int32]int)}
+}
+
+func (l *Leaderboard) Apply(e Event) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	switch e.Type {
+	case BidPlaced:
+		l.bids[e.ClientID]++
+		l.entries[e.ClientID] = &Entry{ClientID: e.ClientID, ClientName: e.ClientName, Amount: e.Amount}
+	case BidRetracted, BidderBanned:
+		delete(l.entries, e.ClientID)
+	case AuctionCancelled:
+		clear(l.entries)
+	}
+}
+
+// Top returns the n highest bids, or all of them if n is 0.
+func (l *Leaderboard) Top(n int) []Entry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var top []Entry
+	for id, e := range l.entries {
+		entry := *e
+		entry.Bids = l.bids[id]
+		top = append(top, entry)
+	}
+	sort.Slice(top, func(i, j int) bool { return top[i].Amount > top[j].Amount })
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// BidderHistory is every event of every bidder, e.g. their bids, rejections and payments.
+// It is safe to read while it is built.
+type BidderHistory struct {
+	mutex  sync.Mutex
+	events map[int32][]Event
+}
+
+func NewBidderHistory() *BidderHistory {
+	return &BidderHistory{events: make(map[int32][]Event)}
+}
+
+func (h *BidderHistory) Apply(e Event) {
+	switch e.Type {
+	case AuctionCreated, AuctionExtended, AuctionClosed, AuctionCancelled:
+		return // about the auction, not a bidder
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.events[e.ClientID] = append(h.events[e.ClientID], e)
+}
+
+// Of returns the events of a bidder, oldest first.
+func (h *BidderHistory) Of(clientID int32) []Event {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]Event(nil), h.events[clientID]...)
+}
+
+// Bidders returns every bidder that has events, by id.
+func (h *BidderHistory) Bidders() []int32 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var ids []int32
+	for id := range h.events {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
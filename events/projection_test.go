@@ -0,0 +1,84 @@
+package events
+
+import "testing"
+
+func TestLeaderboard(t *testing.T) {
+	events := []Event{
+		{Type: BidPlaced, ClientID: 1, ClientName: "alice", Amount: 10},
+		{Type: BidPlaced, ClientID: 2, ClientName: "bob", Amount: 20},
+		{Type: BidPlaced, ClientID: 3, ClientName: "carol", Amount: 15},
+		{Type: BidPlaced, ClientID: 1, ClientName: "alice", Amount: 25},
+		{Type: BidRejected, ClientID: 3, ClientName: "carol", Amount: 5},
+		{Type: BidPlaced, ClientID: 4, ClientName: "dave", Amount: 30},
+		{Type: BidRetracted, ClientID: 4},
+	}
+	board := NewLeaderboard()
+	for _, e := range events {
+		board.Apply(e)
+	}
+
+	top := board.Top(0)
+	want := []Entry{
+		{ClientID: 1, ClientName: "alice", Amount: 25, Bids: 2},
+		{ClientID: 2, ClientName: "bob", Amount: 20, Bids: 1},
+		{ClientID: 3, ClientName: "carol", Amount: 15, Bids: 1},
+	}
+	if len(top) != len(want) {
+		t.Fatalf("Top(0) = %+v, want %+v", top, want)
+	}
+	for i := range want {
+		if top[i] != want[i] {
+			t.Errorf("Top(0)[%d] = %+v, want %+v", i, top[i], want[i])
+		}
+	}
+	if top := board.Top(2); len(top) != 2 || top[1].ClientID != 2 {
+		t.Fatalf("Top(2) = %+v", top)
+	}
+
+	board.Apply(Event{Type: BidderBanned, ClientID: 1})
+	if top := board.Top(1); len(top) != 1 || top[0].ClientID != 2 {
+		t.Fatalf("Top(1) after the ban = %+v, want bob", top)
+	}
+	board.Apply(Event{Type: AuctionCancelled})
+	if top := board.Top(0); len(top) != 0 {
+		t.Fatalf("Top(0) after the cancel = %+v, want nobody", top)
+	}
+}
+
+func TestBidderHistory(t *testing.T) {
+	events := []Event{
+		{Seq: 1, Type: AuctionCreated},
+		{Seq: 2, Type: BidPlaced, ClientID: 1, Amount: 10},
+		{Seq: 2, Type: BidRejected, ClientID: 2, Amount: 5},
+		{Seq: 3, Type: BidPlaced, ClientID: 2, Amount: 20},
+		{Seq: 4, Type: AuctionClosed},
+		{Seq: 5, Type: PaymentConfirmed, ClientID: 2, Amount: 20},
+	}
+	ch := make(chan Event, len(events))
+	for _, e := range events {
+		ch <- e
+	}
+	close(ch)
+	history := NewBidderHistory()
+	Project(ch, history)
+
+	if ids := history.Bidders(); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("Bidders() = %v, want [1 2]", ids)
+	}
+	var types []Type
+	for _, e := range history.Of(2) {
+		types = append(types, e.Type)
+	}
+	want := []Type{BidRejected, BidPlaced, PaymentConfirmed}
+	if len(types) != len(want) {
+		t.Fatalf("Of(2) = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("Of(2) = %v, want %v", types, want)
+		}
+	}
+	if of := history.Of(3); len(of) != 0 {
+		t.Fatalf("Of(3) = %v for a bidder without events", of)
+	}
+}
@@ -0,0 +1,108 @@
+// Package history records what clients asked the auction and what they got back, so a run can be
+// checked afterwards. A history is kept as JSON lines, one operation per line, like the audit log.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// The kinds of operations.
+const (
+	KindBid     = "bid"
+	KindResult  = "result"
+	KindRetract = "retract" // the client takes back their bid, Accepted and Message are what the server said
+)
+
+// Op is one call a client made, from when it was made until it returned.
+type Op struct {
+	Client int32  `json:"client"`
+	Kind   string `json:"kind"`
+	Call   int64  `json:"call"`   // unix nanoseconds
+	Return int64  `json:"return"` // unix nanoseconds
+
+	// Ok is false if the call failed, then nobody knows if a bid went through or not.
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+
+	// for bids, and Accepted and Message for retractions
+	Name     string  `json:"name,omitempty"`
+	Amount   float32 `json:"amount,omitempty"`
+	Accepted bool    `json:"accepted,omitempty"`
+	Message  string  `json:"message,omitempty"` // what the server said, e.g. why the bid was rejected
+
+	// for results
+	Highest float32 `json:"highest,omitempty"`
+	Winner  string  `json:"winner,omitempty"`
+	Done    bool    `json:"done,omitempty"`
+}
+
+// Recorder collects the operations of several clients at once.
+type Recorder struct {
+	mutex sync.Mutex
+	ops   []Op
+}
+
+// Add records an operation that has returned.
+func (r *Recorder) Add(op Op) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.ops = append(r.ops, op)
+}
+
+// Ops returns the operations recorded so far, in the order they were called.
+func (r *Recorder) Ops() []Op {
+	r.mutex.Lock()
+	ops := append([]Op(nil), r.ops...)
+	r.mutex.Unlock()
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Call < ops[j].Call })
+	return ops
+}
+
+// WriteFile writes a history to path.
+func WriteFile(path string, ops []Op) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, op := range ops {
+		if err := enc.Encode(op); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadFile reads a history written by WriteFile.
+func ReadFile(path string) ([]Op, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ops []Op
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var op Op
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		ops = append(ops, op)
+	}
+	return ops, scanner.Err()
+}
@@ -0,0 +1,310 @@
+package history
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The checker below tries to find an order of the operations that a single, non-replicated auction
+// could have run them in, where every operation takes effect at some point between its call and its
+// return. If there is one the history is linearizable. It is the algorithm of Wing & Gong with the
+// caching from Lowe, the same one Porcupine and Knossos use.
+//
+// The auction model:
+//   - Every bidder has at most one bid, the highest bid is the highest of them.
+//   - An accepted bid must be higher than the highest bid and the auction must be open, then it is the bidder's bid.
+//   - A bid rejected as too low must not be higher than the highest bid.
+//   - An accepted retraction needs the bidder to have a bid and the auction to be open, then the bidder has none.
+//   - A retraction rejected because there is no bid needs the bidder to have none. One rejected for another
+//     reason (the window or the cutoff is over, or the auction is over) changes nothing and is not checked.
+//   - A retraction that failed is either placed like an accepted one or left out, like a failed bid.
+//   - A bid rejected for any other reason (the auction is over or cancelled, or the bidder is banned or over
+//     their budget) changes nothing and is not checked, the servers decide that from their clock and the admin records.
+//   - A bid that failed (Ok is false) may or may not have gone through, so it is either placed like an
+//     accepted bid or left out.
+//   - A result must say what the highest bid is, who made it and if the auction is closed.
+//     The auction closes by itself at some point, the checker closes it as late as the results allow.
+//   - A result that failed tells nothing and is left out.
+
+// state is the state of the sequential auction. It is never changed, step makes a new one.
+type state struct {
+	bids   []bid // sorted by client
+	closed bool
+}
+
+// bid is the current bid of one bidder.
+type bid struct {
+	client int32
+	amount float32
+	name   string
+}
+
+// the state before any bid, the servers answer Result with -1 when nobody has bid
+var initial = state{}
+
+// highest returns the highest bid and who made it, or -1 and "" if there are no bids.
+func (s state) highest() (float32, string) {
+	amount, name := float32(-1), ""
+	for _, b := range s.bids {
+		if b.amount > amount {
+			amount, name = b.amount, b.name
+		}
+	}
+	return amount, name
+}
+
+// find returns where the bid of client is in s.bids, and if it is there.
+func (s state) find(client int32) (int, bool) {
+	i := sort.Search(len(s.bids), func(i int) bool { return s.bids[i].client >= client })
+	return i, i < len(s.bids) && s.bids[i].client == client
+}
+
+// with returns s with b as the bid of its bidder.
+func (s state) with(b bid) state {
+	i, found := s.find(b.client)
+	bids := make([]bid, 0, len(s.bids)+1)
+	bids = append(bids, s.bids[:i]...)
+	bids = append(bids, b)
+	if found {
+		i++
+	}
+	bids = append(bids, s.bids[i:]...)
+	return state{bids: bids, closed: s.closed}
+}
+
+// without returns s without the bid of client.
+func (s state) without(client int32) state {
+	i, found := s.find(client)
+	if !found {
+		return s
+	}
+	bids := make([]bid, 0, len(s.bids)-1)
+	bids = append(bids, s.bids[:i]...)
+	bids = append(bids, s.bids[i+1:]...)
+	return state{bids: bids, closed: s.closed}
+}
+
+// step runs op on s and returns the new state, or false if op can't have happened in state s.
+func step(s state, op Op) (state, bool) {
+	highest, winner := s.highest()
+	switch op.Kind {
+	case KindBid:
+		if !op.Ok || op.Accepted {
+			if s.closed || op.Amount <= highest {
+				return s, false
+			}
+			return s.with(bid{client: op.Client, amount: op.Amount, name: op.Name}), true
+		}
+		if rejectedAsLow(op) {
+			return s, op.Amount <= highest
+		}
+		return s, true
+	case KindRetract:
+		_, hasBid := s.find(op.Client)
+		if !op.Ok || op.Accepted {
+			if s.closed || !hasBid {
+				return s, false
+			}
+			return s.without(op.Client), true
+		}
+		if strings.HasPrefix(op.Message, "You have no bid") {
+			return s, !hasBid
+		}
+		return s, true
+	case KindResult:
+		if op.Done {
+			s.closed = true
+		}
+		return s, s.closed == op.Done && op.Highest == highest && op.Winner == winner
+	}
+	return s, false
+}
+
+// rejectedAsLow says if a bid was rejected because it was not higher than the highest bid.
+// Histories without the messages are treated as if every rejection was for that.
+func rejectedAsLow(op Op) bool {
+	return op.Message == "" || strings.HasPrefix(op.Message, "Bid is lower")
+}
+
+// Report is what Check found.
+type Report struct {
+	Linearizable bool
+	// Order is an order the operations could have taken effect in, if the history is linearizable.
+	// Failed bids and retractions that are left out, and failed results, are not in it.
+	Order []Op
+	// Stuck is the operation the checker got furthest without being able to place, if the history is not linearizable.
+	// Placed is how many operations it had placed before it.
+	Stuck  Op
+	Placed int
+}
+
+// entry is the call or the return of an operation, in a list sorted by time.
+type entry struct {
+	id         int
+	call       bool
+	time       int64
+	match      *entry // the return of a call or the call of a return
+	prev, next *entry
+}
+
+// Check checks if the history is linearizable.
+func Check(ops []Op) Report {
+	// failed results did not change anything and tell nothing, so they are left out
+	var kept []Op
+	for _, op := range ops {
+		if op.Kind == KindResult && !op.Ok {
+			continue
+		}
+		kept = append(kept, op)
+	}
+	ops = kept
+
+	var entries []*entry
+	for i, op := range ops {
+		ret := op.Return
+		if !op.Ok {
+			// nobody knows when a failed bid or retraction took effect, it could have been any time after the call
+			ret = math.MaxInt64
+		}
+		call := &entry{id: i, call: true, time: op.Call}
+		end := &entry{id: i, time: ret, match: call}
+		call.match = end
+		entries = append(entries, call, end)
+	}
+	// calls go before returns at the same time, so the operations count as overlapping
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].time != entries[j].time {
+			return entries[i].time < entries[j].time
+		}
+		return entries[i].call && !entries[j].call
+	})
+	head := &entry{}
+	prev := head
+	for _, e := range entries {
+		prev.next = e
+		e.prev = prev
+		prev = e
+	}
+
+	type frame struct {
+		call    *entry
+		before  state
+		skipped bool // a failed bid or retraction that was left out
+	}
+	var stack []frame
+	placed := make([]bool, len(ops))
+	seen := make(map[string]bool)
+	s := initial
+	report := Report{Placed: -1}
+
+	e := head.next
+	for head.next != nil {
+		if e.call {
+			if next, ok := step(s, ops[e.id]); ok {
+				placed[e.id] = true
+				key := cacheKey(placed, next)
+				if !seen[key] {
+					seen[key] = true
+					stack = append(stack, frame{call: e, before: s})
+					s = next
+					lift(e)
+					e = head.next
+					continue
+				}
+				placed[e.id] = false
+			}
+			e = e.next
+			continue
+		}
+
+		// e is a return, so its operation had to take effect before here
+		if !ops[e.id].Ok {
+			// unless it failed, then it might never have taken effect
+			placed[e.id] = true
+			key := cacheKey(placed, s)
+			if !seen[key] {
+				seen[key] = true
+				stack = append(stack, frame{call: e.match, before: s, skipped: true})
+				lift(e.match)
+				e = head.next
+				continue
+			}
+			placed[e.id] = false
+		}
+		if len(stack) > report.Placed {
+			report.Placed = len(stack)
+			report.Stuck = ops[e.id]
+		}
+
+		// go back to the last choice and try the next operation instead
+		for {
+			if len(stack) == 0 {
+				return report
+			}
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			placed[f.call.id] = false
+			s = f.before
+			unlift(f.call)
+			if !f.skipped {
+				e = f.call.next
+				break
+			}
+			// leaving it out was the only choice at its return, so keep going back
+		}
+	}
+
+	report = Report{Linearizable: true}
+	for _, f := range stack {
+		if !f.skipped {
+			report.Order = append(report.Order, ops[f.call.id])
+		}
+	}
+	return report
+}
+
+// lift takes an operation out of the list.
+func lift(call *entry) {
+	call.prev.next = call.next
+	if call.next != nil {
+		call.next.prev = call.prev
+	}
+	ret := call.match
+	ret.prev.next = ret.next
+	if ret.next != nil {
+		ret.next.prev = ret.prev
+	}
+}
+
+// unlift puts an operation taken out by lift back in.
+func unlift(call *entry) {
+	ret := call.match
+	ret.prev.next = ret
+	if ret.next != nil {
+		ret.next.prev = ret
+	}
+	call.prev.next = call
+	if call.next != nil {
+		call.next.prev = call
+	}
+}
+
+// cacheKey identifies a set of placed operations together with the state they led to. Two ways of
+// getting to the same key can't end differently, so the second one is not tried.
+func cacheKey(placed []bool, s state) string {
+	var b strings.Builder
+	for _, p := range placed {
+		if p {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	for _, bid := range s.bids {
+		fmt.Fprintf(&b, "|%d:%v:%s", bid.client, bid.amount, bid.name)
+	}
+	fmt.Fprintf(&b, "|%v", s.closed)
+	return b.String()
+}
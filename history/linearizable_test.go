@@ -0,0 +1,167 @@
+package history
+
+import "testing"
+
+// the operations of the tests, call and ret are in made up time units
+
+func accepted(client int32, name string, amount float32, call, ret int64) Op {
+	return Op{Client: client, Kind: KindBid, Name: name, Amount: amount, Call: call, Return: ret, Ok: true, Accepted: true}
+}
+
+func tooLow(client int32, name string, amount float32, call, ret int64) Op {
+	op := accepted(client, name, amount, call, ret)
+	op.Accepted = false
+	op.Message = "Bid is lower than current highest bid: 20"
+	return op
+}
+
+func failed(op Op) Op {
+	op.Ok, op.Accepted, op.Message, op.Error = false, false, "", "deadline exceeded"
+	return op
+}
+
+func result(highest float32, winner string, call, ret int64) Op {
+	return Op{Kind: KindResult, Highest: highest, Winner: winner, Call: call, Return: ret, Ok: true}
+}
+
+func closed(highest float32, winner string, call, ret int64) Op {
+	op := result(highest, winner, call, ret)
+	op.Done = true
+	return op
+}
+
+func retract(client int32, call, ret int64) Op {
+	return Op{Client: client, Kind: KindRetract, Call: call, Return: ret, Ok: true, Accepted: true}
+}
+
+func noBid(client int32, call, ret int64) Op {
+	op := retract(client, call, ret)
+	op.Accepted = false
+	op.Message = "You have no bid to take back"
+	return op
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		ops          []Op
+		linearizable bool
+	}{
+		{"empty", nil, true},
+		{"nobody has bid", []Op{result(-1, "", 1, 2)}, true},
+		{"one after the other", []Op{
+			accepted(1, "alice", 10, 1, 2),
+			accepted(2, "bob", 20, 3, 4),
+			result(20, "bob", 5, 6),
+		}, true},
+		{"a bid rejected while a higher one was being placed", []Op{
+			accepted(1, "alice", 10, 1, 4),
+			tooLow(2, "bob", 5, 2, 3),
+			result(10, "alice", 5, 6),
+		}, true},
+		{"a result that overlaps a bid can miss it", []Op{
+			accepted(1, "alice", 10, 1, 2),
+			accepted(2, "bob", 20, 3, 6),
+			result(10, "alice", 4, 5),
+			result(20, "bob", 7, 8),
+		}, true},
+		{"a failed bid that went through", []Op{
+			failed(accepted(1, "alice", 10, 1, 2)),
+			result(10, "alice", 3, 4),
+		}, true},
+		{"a failed bid that did not go through", []Op{
+			failed(accepted(1, "alice", 10, 1, 2)),
+			result(-1, "", 3, 4),
+		}, true},
+		{"a retracted bid no longer counts", []Op{
+			accepted(1, "alice", 10, 1, 2),
+			accepted(2, "bob", 20, 3, 4),
+			retract(2, 5, 6),
+			result(10, "alice", 7, 8),
+		}, true},
+		{"a failed retraction that went through", []Op{
+			accepted(1, "alice", 10, 1, 2),
+			failed(retract(1, 3, 4)),
+			result(-1, "", 5, 6),
+		}, true},
+		{"no bid to take back", []Op{
+			noBid(1, 1, 2),
+			accepted(1, "alice", 10, 3, 4),
+		}, true},
+		{"a retraction rejected for the window is not checked", []Op{
+			{Client: 1, Kind: KindRetract, Call: 1, Return: 2, Ok: true, Message: "Your bid can only be taken back within 30s of making it"},
+			result(-1, "", 3, 4),
+		}, true},
+		{"closed", []Op{
+			accepted(1, "alice", 10, 1, 2),
+			closed(10, "alice", 3, 4),
+			{Client: 2, Kind: KindBid, Name: "bob", Amount: 20, Call: 5, Return: 6, Ok: true, Message: "The auction is over"},
+			closed(10, "alice", 7, 8),
+		}, true},
+
+		{"a stale result", []Op{
+			accepted(1, "alice", 10, 1, 2),
+			accepted(2, "bob", 20, 3, 4),
+			result(10, "alice", 5, 6),
+		}, false},
+		{"an accepted bid lower than the highest", []Op{
+			accepted(1, "alice", 20, 1, 2),
+			accepted(2, "bob", 10, 3, 4),
+		}, false},
+		{"too low when nobody had bid", []Op{
+			tooLow(1, "alice", 10, 1, 2),
+			result(-1, "", 3, 4),
+		}, false},
+		{"a result with a retracted bid", []Op{
+			accepted(1, "alice", 10, 1, 2),
+			accepted(2, "bob", 20, 3, 4),
+			retract(2, 5, 6),
+			result(20, "bob", 7, 8),
+		}, false},
+		{"a retraction without a bid", []Op{
+			retract(1, 1, 2),
+		}, false},
+		{"no bid to take back while there is one", []Op{
+			accepted(1, "alice", 10, 1, 2),
+			noBid(1, 3, 4),
+		}, false},
+		{"a bid accepted after the close", []Op{
+			closed(-1, "", 1, 2),
+			accepted(1, "alice", 10, 3, 4),
+		}, false},
+		{"open again after the close", []Op{
+			closed(-1, "", 1, 2),
+			result(-1, "", 3, 4),
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Check(tt.ops)
+			if report.Linearizable != tt.linearizable {
+				t.Fatalf("Check() says linearizable is %v, want %v (stuck at %+v)", report.Linearizable, tt.linearizable, report.Stuck)
+			}
+		})
+	}
+}
+
+func TestCheckOrder(t *testing.T) {
+	// bob's bid returned first, but alice's overlaps it and is lower, so it took effect first
+	ops := []Op{
+		accepted(1, "alice", 10, 1, 5),
+		accepted(2, "bob", 20, 2, 3),
+		result(20, "bob", 6, 7),
+	}
+	report := Check(ops)
+	if !report.Linearizable {
+		t.Fatalf("Check() = not linearizable, stuck at %+v", report.Stuck)
+	}
+	want := []string{"alice", "bob", ""}
+	if len(report.Order) != len(want) {
+		t.Fatalf("Order has %d operations, want %d", len(report.Order), len(want))
+	}
+	for i, op := range report.Order {
+		if op.Name != want[i] {
+			t.Errorf("Order[%d] is %+v, want the operation of %q", i, op, want[i])
+		}
+	}
+}
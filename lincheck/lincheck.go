@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/history"
+)
+
+// Checks that a recorded history of bids, retractions and results is linearizable, i.e. that one auction server
+// on its own could have given the same answers. The histories are written by the chaos command
+// (-history) and the client (-history), several of them are merged.
+// Run with:
+// go run ./lincheck history.jsonl
+// go run ./lincheck -v history_alice.jsonl history_bob.jsonl
+
+var verbose = flag.Bool("v", false, "Print the order the operations could have taken effect in")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Println("usage: lincheck [-v] <history> [<history> ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var ops []history.Op
+	for _, path := range flag.Args() {
+		read, err := history.ReadFile(path)
+		if err != nil {
+			fmt.Printf("%s: %v \n", path, err)
+			os.Exit(2)
+		}
+		ops = append(ops, read...)
+	}
+	if len(ops) == 0 {
+		fmt.Println("the history is empty")
+		return
+	}
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Call < ops[j].Call })
+	start := ops[0].Call
+
+	report := history.Check(ops)
+	if report.Linearizable {
+		fmt.Printf("linearizable: %d operations, %d placed in order \n", len(ops), len(report.Order))
+		if *verbose {
+			for _, op := range report.Order {
+				fmt.Printf("  %s \n", describe(op, start))
+			}
+		}
+		return
+	}
+
+	fmt.Printf("NOT linearizable: after placing %d operations nothing explains \n", report.Placed)
+	fmt.Printf("  %s \n", describe(report.Stuck, start))
+	fmt.Println("operations that overlap with it:")
+	for _, op := range ops {
+		if op != report.Stuck && op.Call <= report.Stuck.Return && (op.Return >= report.Stuck.Call || !op.Ok) {
+			fmt.Printf("  %s \n", describe(op, start))
+		}
+	}
+	os.Exit(1)
+}
+
+// describe prints an operation with its times relative to the start of the history.
+func describe(op history.Op, start int64) string {
+	at := func(t int64) string { return fmt.Sprintf("%.3fs", time.Duration(t-start).Seconds()) }
+	when := at(op.Call) + "-" + at(op.Return)
+	switch {
+	case op.Kind == history.KindBid && !op.Ok:
+		return fmt.Sprintf("%s client %d bid %v: failed (%s)", when, op.Client, op.Amount, op.Error)
+	case op.Kind == history.KindBid && op.Accepted:
+		return fmt.Sprintf("%s client %d bid %v: accepted", when, op.Client, op.Amount)
+	case op.Kind == history.KindBid:
+		return fmt.Sprintf("%s client %d bid %v: rejected (%s)", when, op.Client, op.Amount, op.Message)
+	case op.Kind == history.KindRetract && !op.Ok:
+		return fmt.Sprintf("%s client %d retract: failed (%s)", when, op.Client, op.Error)
+	case op.Kind == history.KindRetract && op.Accepted:
+		return fmt.Sprintf("%s client %d retract: accepted", when, op.Client)
+	case op.Kind == history.KindRetract:
+		return fmt.Sprintf("%s client %d retract: rejected (%s)", when, op.Client, op.Message)
+	case op.Done:
+		return fmt.Sprintf("%s client %d result: closed, %s won with %v", when, op.Client, op.Winner, op.Highest)
+	default:
+		return fmt.Sprintf("%s client %d result: %s leads with %v", when, op.Client, op.Winner, op.Highest)
+	}
+}
@@ -0,0 +1,87 @@
+// Package logging sets up the structured logs of the servers and the clients. Everything is logged
+// with log/slog, as JSON by default, to stdout, stderr or a file that is appended to and rotated when
+// it gets big, so the logs of earlier runs are kept.
+//
+// Every gRPC call carries a request id in its metadata (see the interceptors in requestid.go), so the
+// lines a client and the servers log about the same bid can be found together.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Config says where and how to log.
+type Config struct {
+	Output string // "stdout", "stderr" or the path of a file. Default stdout
+	Format string // "json" or "text". Default json
+	Level  string // "debug", "info", "warn" or "error". Default info
+
+	// MaxSize is how big a file can get, in bytes, before it is rotated. Default 10 MB, negative never rotates.
+	MaxSize int64
+	// MaxBackups is how many rotated files are kept next to it, as file.1, file.2, ... Default 3
+	MaxBackups int
+}
+
+const (
+	defaultMaxSize    = 10 * 1024 * 1024
+	defaultMaxBackups = 3
+)
+
+// New makes a logger from cfg. The closer closes the file, if it logs to one.
+func New(cfg Config) (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, nil, fmt.Errorf("logging: unknown level %q", cfg.Level)
+		}
+	}
+
+	var w io.Writer
+	var closer io.Closer = nopCloser{}
+	switch cfg.Output {
+	case "", "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		maxSize := cfg.MaxSize
+		if maxSize == 0 {
+			maxSize = defaultMaxSize
+		}
+		backups := cfg.MaxBackups
+		if backups == 0 {
+			backups = defaultMaxBackups
+		}
+		f, err := OpenFile(cfg.Output, maxSize, backups)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closer = f, f
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		closer.Close()
+		return nil, nil, fmt.Errorf("logging: unknown format %q", cfg.Format)
+	}
+	return slog.New(handler), closer, nil
+}
+
+// Discard returns a logger that throws everything away.
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDKey is the gRPC metadata key the request id is sent in.
+const RequestIDKey = "x-request-id"
+
+type requestIDKey struct{}
+
+// NewRequestID makes a random request id.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a context that carries id. The client interceptor sends it along with
+// every call made with the context, so all the tries of one bid have the same id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id in ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromContext returns logger with the request id of ctx added, if it has one.
+func FromContext(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if id := RequestID(ctx); id != "" {
+		return logger.With("request_id", id)
+	}
+	return logger
+}
+
+// UnaryClientInterceptor sends the request id of the context, or a new one, with every call.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+}
+
+// StreamClientInterceptor is UnaryClientInterceptor for streams.
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(outgoing(ctx), desc, cc, method, opts...)
+}
+
+func outgoing(ctx context.Context) context.Context {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(RequestIDKey)) > 0 {
+		return ctx
+	}
+	id := RequestID(ctx)
+	if id == "" {
+		id = NewRequestID()
+	}
+	return metadata.AppendToOutgoingContext(ctx, RequestIDKey, id)
+}
+
+// incoming returns ctx with the request id the caller sent, or a new one.
+func incoming(ctx context.Context) context.Context {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDKey); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if id == "" {
+		id = NewRequestID()
+	}
+	return WithRequestID(ctx, id)
+}
+
+// UnaryServerInterceptor puts the request id the caller sent in the context of the handler and
+// logs every call at debug level.
+func UnaryServerInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx = incoming(ctx)
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		FromContext(ctx, logger).Debug("handled call", "method", info.FullMethod, "code", status.Code(err).String(), "duration", time.Since(start))
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func StreamServerInterceptor(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := incoming(ss.Context())
+		start := time.Now()
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		FromContext(ctx, logger).Debug("handled stream", "method", info.FullMethod, "code", status.Code(err).String(), "duration", time.Since(start))
+		return err
+	}
+}
+
+// serverStream swaps the context of a stream for one with the request id.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,73 @@
+// Package payment is how the auction servers check that the buyer of an auction has paid.
+// The servers only need a Provider, which a real payment service can be plugged in as.
+// Fake is one that runs in the process, for tests and for trying the auction out. It checks nothing,
+// so it must never be used for a real auction:
+//
+//	fake := payment.NewFake()
+//	fake.Decline("card-expired")
+//	s, err := auctionserver.New(auctionserver.Config{..., Payments: fake})
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrDeclined is returned by Fake for a payment it was told to turn down.
+var ErrDeclined = errors.New("payment: declined")
+
+// Payment is what a bidder says they paid.
+type Payment struct {
+	ClientID   int32
+	ClientName string
+	Amount     float32
+	Reference  string // what the bidder got from the provider when they paid, e.g. a transaction id
+}
+
+// Provider checks payments with whoever takes the money.
+type Provider interface {
+	// Confirm returns nil if p has been paid in full. Any error turns the payment down,
+	// the bidder can try again until their time to pay is up.
+	Confirm(ctx context.Context, p Payment) error
+}
+
+// Fake is a Provider that keeps the payments in memory. It takes every payment that has a reference,
+// except the references given to Decline. It is safe to use from several goroutines.
+type Fake struct {
+	mutex     sync.Mutex
+	declined  map[string]bool
+	confirmed []Payment
+}
+
+func NewFake() *Fake {
+	return &Fake{declined: make(map[string]bool)}
+}
+
+// Decline makes the payments with this reference fail.
+func (f *Fake) Decline(reference string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.declined[reference] = true
+}
+
+func (f *Fake) Confirm(ctx context.Context, p Payment) error {
+	if p.Reference == "" {
+		return errors.New("payment: no reference given")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.declined[p.Reference] {
+		return fmt.Errorf("%w: %s", ErrDeclined, p.Reference)
+	}
+	f.confirmed = append(f.confirmed, p)
+	return nil
+}
+
+// Payments returns the payments that were confirmed, oldest first.
+func (f *Fake) Payments() []Payment {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]Payment(nil), f.confirmed...)
+}
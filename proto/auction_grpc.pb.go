@@ -21,7 +21,11 @@ const _ = grpc.SupportPackageIsVersion7
 const (
 	AuctionService_Bid_FullMethodName              = "/proto.AuctionService/Bid"
 	AuctionService_Result_FullMethodName           = "/proto.AuctionService/Result"
+	AuctionService_RetractBid_FullMethodName       = "/proto.AuctionService/RetractBid"
+	AuctionService_Watch_FullMethodName            = "/proto.AuctionService/Watch"
 	AuctionService_ConnectionStream_FullMethodName = "/proto.AuctionService/connectionStream"
+	AuctionService_Members_FullMethodName          = "/proto.AuctionService/Members"
+	AuctionService_ConfirmPayment_FullMethodName   = "/proto.AuctionService/ConfirmPayment"
 )
 
 // AuctionServiceClient is the client API for AuctionService service.
@@ -30,7 +34,11 @@ const (
 type AuctionServiceClient interface {
 	Bid(ctx context.Context, in *BidAmount, opts ...grpc.CallOption) (*Ack, error)
 	Result(ctx context.Context, in *Void, opts ...grpc.CallOption) (*Outcome, error)
+	RetractBid(ctx context.Context, in *Retraction, opts ...grpc.CallOption) (*Ack, error)
+	Watch(ctx context.Context, in *Void, opts ...grpc.CallOption) (AuctionService_WatchClient, error)
 	ConnectionStream(ctx context.Context, opts ...grpc.CallOption) (AuctionService_ConnectionStreamClient, error)
+	Members(ctx context.Context, in *Void, opts ...grpc.CallOption) (*MemberList, error)
+	ConfirmPayment(ctx context.Context, in *PaymentConfirmation, opts ...grpc.CallOption) (*Ack, error)
 }
 
 type auctionServiceClient struct {
@@ -59,8 +67,49 @@ func (c *auctionServiceClient) Result(ctx context.Context, in *Void, opts ...grp
 	return out, nil
 }
 
+func (c *auctionServiceClient) RetractBid(ctx context.Context, in *Retraction, opts ...grpc.CallOption) (*Ack, error) {
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, AuctionService_RetractBid_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionServiceClient) Watch(ctx context.Context, in *Void, opts ...grpc.CallOption) (AuctionService_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AuctionService_ServiceDesc.Streams[0], AuctionService_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &auctionServiceWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AuctionService_WatchClient interface {
+	Recv() (*Outcome, error)
+	grpc.ClientStream
+}
+
+type auctionServiceWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *auctionServiceWatchClient) Recv() (*Outcome, error) {
+	m := new(Outcome)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *auctionServiceClient) ConnectionStream(ctx context.Context, opts ...grpc.CallOption) (AuctionService_ConnectionStreamClient, error) {
-	stream, err := c.cc.NewStream(ctx, &AuctionService_ServiceDesc.Streams[0], AuctionService_ConnectionStream_FullMethodName, opts...)
+	stream, err := c.cc.NewStream(ctx, &AuctionService_ServiceDesc.Streams[1], AuctionService_ConnectionStream_FullMethodName, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -90,13 +139,35 @@ func (x *auctionServiceConnectionStreamClient) Recv() (*BackupStream, error) {
 	return m, nil
 }
 
+func (c *auctionServiceClient) Members(ctx context.Context, in *Void, opts ...grpc.CallOption) (*MemberList, error) {
+	out := new(MemberList)
+	err := c.cc.Invoke(ctx, AuctionService_Members_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionServiceClient) ConfirmPayment(ctx context.Context, in *PaymentConfirmation, opts ...grpc.CallOption) (*Ack, error) {
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, AuctionService_ConfirmPayment_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // AuctionServiceServer is the server API for AuctionService service.
 // All implementations must embed UnimplementedAuctionServiceServer
 // for forward compatibility
 type AuctionServiceServer interface {
 	Bid(context.Context, *BidAmount) (*Ack, error)
 	Result(context.Context, *Void) (*Outcome, error)
+	RetractBid(context.Context, *Retraction) (*Ack, error)
+	Watch(*Void, AuctionService_WatchServer) error
 	ConnectionStream(AuctionService_ConnectionStreamServer) error
+	Members(context.Context, *Void) (*MemberList, error)
+	ConfirmPayment(context.Context, *PaymentConfirmation) (*Ack, error)
 	mustEmbedUnimplementedAuctionServiceServer()
 }
 
@@ -110,9 +181,21 @@ func (UnimplementedAuctionServiceServer) Bid(context.Context, *BidAmount) (*Ack,
 func (UnimplementedAuctionServiceServer) Result(context.Context, *Void) (*Outcome, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Result not implemented")
 }
+func (UnimplementedAuctionServiceServer) RetractBid(context.Context, *Retraction) (*Ack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RetractBid not implemented")
+}
+func (UnimplementedAuctionServiceServer) Watch(*Void, AuctionService_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
 func (UnimplementedAuctionServiceServer) ConnectionStream(AuctionService_ConnectionStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method ConnectionStream not implemented")
 }
+func (UnimplementedAuctionServiceServer) Members(context.Context, *Void) (*MemberList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Members not implemented")
+}
+func (UnimplementedAuctionServiceServer) ConfirmPayment(context.Context, *PaymentConfirmation) (*Ack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmPayment not implemented")
+}
 func (UnimplementedAuctionServiceServer) mustEmbedUnimplementedAuctionServiceServer() {}
 
 // UnsafeAuctionServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -162,6 +245,45 @@ func _AuctionService_Result_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AuctionService_RetractBid_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Retraction)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionServiceServer).RetractBid(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionService_RetractBid_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionServiceServer).RetractBid(ctx, req.(*Retraction))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuctionService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Void)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AuctionServiceServer).Watch(m, &auctionServiceWatchServer{stream})
+}
+
+type AuctionService_WatchServer interface {
+	Send(*Outcome) error
+	grpc.ServerStream
+}
+
+type auctionServiceWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *auctionServiceWatchServer) Send(m *Outcome) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _AuctionService_ConnectionStream_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(AuctionServiceServer).ConnectionStream(&auctionServiceConnectionStreamServer{stream})
 }
@@ -188,6 +310,42 @@ func (x *auctionServiceConnectionStreamServer) Recv() (*BackupStream, error) {
 	return m, nil
 }
 
+func _AuctionService_Members_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Void)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionServiceServer).Members(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionService_Members_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionServiceServer).Members(ctx, req.(*Void))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuctionService_ConfirmPayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PaymentConfirmation)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionServiceServer).ConfirmPayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionService_ConfirmPayment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionServiceServer).ConfirmPayment(ctx, req.(*PaymentConfirmation))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // AuctionService_ServiceDesc is the grpc.ServiceDesc for AuctionService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -203,8 +361,25 @@ var AuctionService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Result",
 			Handler:    _AuctionService_Result_Handler,
 		},
+		{
+			MethodName: "RetractBid",
+			Handler:    _AuctionService_RetractBid_Handler,
+		},
+		{
+			MethodName: "Members",
+			Handler:    _AuctionService_Members_Handler,
+		},
+		{
+			MethodName: "ConfirmPayment",
+			Handler:    _AuctionService_ConfirmPayment_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _AuctionService_Watch_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "connectionStream",
 			Handler:       _AuctionService_ConnectionStream_Handler,
@@ -214,3 +389,667 @@ var AuctionService_ServiceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "proto/auction.proto",
 }
+
+const (
+	AuctionAdmin_CloseNow_FullMethodName      = "/proto.AuctionAdmin/CloseNow"
+	AuctionAdmin_ExtendEndTime_FullMethodName = "/proto.AuctionAdmin/ExtendEndTime"
+	AuctionAdmin_CancelAuction_FullMethodName = "/proto.AuctionAdmin/CancelAuction"
+	AuctionAdmin_BanBidder_FullMethodName     = "/proto.AuctionAdmin/BanBidder"
+	AuctionAdmin_SetBudget_FullMethodName     = "/proto.AuctionAdmin/SetBudget"
+	AuctionAdmin_DumpState_FullMethodName     = "/proto.AuctionAdmin/DumpState"
+	AuctionAdmin_ListReplicas_FullMethodName  = "/proto.AuctionAdmin/ListReplicas"
+	AuctionAdmin_ReplayEvents_FullMethodName  = "/proto.AuctionAdmin/ReplayEvents"
+	AuctionAdmin_AddReplica_FullMethodName    = "/proto.AuctionAdmin/AddReplica"
+	AuctionAdmin_RemoveReplica_FullMethodName = "/proto.AuctionAdmin/RemoveReplica"
+)
+
+// AuctionAdminClient is the client API for AuctionAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AuctionAdminClient interface {
+	CloseNow(ctx context.Context, in *AdminRequest, opts ...grpc.CallOption) (*AdminReply, error)
+	ExtendEndTime(ctx context.Context, in *ExtendRequest, opts ...grpc.CallOption) (*AdminReply, error)
+	CancelAuction(ctx context.Context, in *AdminRequest, opts ...grpc.CallOption) (*AdminReply, error)
+	BanBidder(ctx context.Context, in *BanRequest, opts ...grpc.CallOption) (*AdminReply, error)
+	SetBudget(ctx context.Context, in *BudgetRequest, opts ...grpc.CallOption) (*AdminReply, error)
+	DumpState(ctx context.Context, in *Void, opts ...grpc.CallOption) (*StateDump, error)
+	ListReplicas(ctx context.Context, in *Void, opts ...grpc.CallOption) (*ReplicaList, error)
+	// the committed events of the auction from the audit log, and with follow the new ones as they come.
+	// BidRejected events are not in the audit log: only the leader has them, the last 10000 in memory,
+	// and they are gone after a restart or a change of leader. A follower sends none, so a read model
+	// built from a follower (or from before a failover) has the same bids but not the same rejections.
+	ReplayEvents(ctx context.Context, in *ReplayRequest, opts ...grpc.CallOption) (AuctionAdmin_ReplayEventsClient, error)
+	// change the replicas of the cluster, one at a time. A new replica first gets the whole audit log
+	// and only counts for the majority once it has caught up.
+	AddReplica(ctx context.Context, in *MembershipRequest, opts ...grpc.CallOption) (*AdminReply, error)
+	RemoveReplica(ctx context.Context, in *MembershipRequest, opts ...grpc.CallOption) (*AdminReply, error)
+}
+
+type auctionAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAuctionAdminClient(cc grpc.ClientConnInterface) AuctionAdminClient {
+	return &auctionAdminClient{cc}
+}
+
+func (c *auctionAdminClient) CloseNow(ctx context.Context, in *AdminRequest, opts ...grpc.CallOption) (*AdminReply, error) {
+	out := new(AdminReply)
+	err := c.cc.Invoke(ctx, AuctionAdmin_CloseNow_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionAdminClient) ExtendEndTime(ctx context.Context, in *ExtendRequest, opts ...grpc.CallOption) (*AdminReply, error) {
+	out := new(AdminReply)
+	err := c.cc.Invoke(ctx, AuctionAdmin_ExtendEndTime_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionAdminClient) CancelAuction(ctx context.Context, in *AdminRequest, opts ...grpc.CallOption) (*AdminReply, error) {
+	out := new(AdminReply)
+	err := c.cc.Invoke(ctx, AuctionAdmin_CancelAuction_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionAdminClient) BanBidder(ctx context.Context, in *BanRequest, opts ...grpc.CallOption) (*AdminReply, error) {
+	out := new(AdminReply)
+	err := c.cc.Invoke(ctx, AuctionAdmin_BanBidder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionAdminClient) SetBudget(ctx context.Context, in *BudgetRequest, opts ...grpc.CallOption) (*AdminReply, error) {
+	out := new(AdminReply)
+	err := c.cc.Invoke(ctx, AuctionAdmin_SetBudget_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionAdminClient) DumpState(ctx context.Context, in *Void, opts ...grpc.CallOption) (*StateDump, error) {
+	out := new(StateDump)
+	err := c.cc.Invoke(ctx, AuctionAdmin_DumpState_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionAdminClient) ListReplicas(ctx context.Context, in *Void, opts ...grpc.CallOption) (*ReplicaList, error) {
+	out := new(ReplicaList)
+	err := c.cc.Invoke(ctx, AuctionAdmin_ListReplicas_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionAdminClient) ReplayEvents(ctx context.Context, in *ReplayRequest, opts ...grpc.CallOption) (AuctionAdmin_ReplayEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AuctionAdmin_ServiceDesc.Streams[0], AuctionAdmin_ReplayEvents_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &auctionAdminReplayEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AuctionAdmin_ReplayEventsClient interface {
+	Recv() (*DomainEvent, error)
+	grpc.ClientStream
+}
+
+type auctionAdminReplayEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *auctionAdminReplayEventsClient) Recv() (*DomainEvent, error) {
+	m := new(DomainEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *auctionAdminClient) AddReplica(ctx context.Context, in *MembershipRequest, opts ...grpc.CallOption) (*AdminReply, error) {
+	out := new(AdminReply)
+	err := c.cc.Invoke(ctx, AuctionAdmin_AddReplica_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *auctionAdminClient) RemoveReplica(ctx context.Context, in *MembershipRequest, opts ...grpc.CallOption) (*AdminReply, error) {
+	out := new(AdminReply)
+	err := c.cc.Invoke(ctx, AuctionAdmin_RemoveReplica_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AuctionAdminServer is the server API for AuctionAdmin service.
+// All implementations must embed UnimplementedAuctionAdminServer
+// for forward compatibility
+type AuctionAdminServer interface {
+	CloseNow(context.Context, *AdminRequest) (*AdminReply, error)
+	ExtendEndTime(context.Context, *ExtendRequest) (*AdminReply, error)
+	CancelAuction(context.Context, *AdminRequest) (*AdminReply, error)
+	BanBidder(context.Context, *BanRequest) (*AdminReply, error)
+	SetBudget(context.Context, *BudgetRequest) (*AdminReply, error)
+	DumpState(context.Context, *Void) (*StateDump, error)
+	ListReplicas(context.Context, *Void) (*ReplicaList, error)
+	// the committed events of the auction from the audit log, and with follow the new ones as they come.
+	// BidRejected events are not in the audit log: only the leader has them, the last 10000 in memory,
+	// and they are gone after a restart or a change of leader. A follower sends none, so a read model
+	// built from a follower (or from before a failover) has the same bids but not the same rejections.
+	ReplayEvents(*ReplayRequest, AuctionAdmin_ReplayEventsServer) error
+	// change the replicas of the cluster, one at a time. A new replica first gets the whole audit log
+	// and only counts for the majority once it has caught up.
+	AddReplica(context.Context, *MembershipRequest) (*AdminReply, error)
+	RemoveReplica(context.Context, *MembershipRequest) (*AdminReply, error)
+	mustEmbedUnimplementedAuctionAdminServer()
+}
+
+// UnimplementedAuctionAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedAuctionAdminServer struct {
+}
+
+func (UnimplementedAuctionAdminServer) CloseNow(context.Context, *AdminRequest) (*AdminReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseNow not implemented")
+}
+func (UnimplementedAuctionAdminServer) ExtendEndTime(context.Context, *ExtendRequest) (*AdminReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExtendEndTime not implemented")
+}
+func (UnimplementedAuctionAdminServer) CancelAuction(context.Context, *AdminRequest) (*AdminReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelAuction not implemented")
+}
+func (UnimplementedAuctionAdminServer) BanBidder(context.Context, *BanRequest) (*AdminReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BanBidder not implemented")
+}
+func (UnimplementedAuctionAdminServer) SetBudget(context.Context, *BudgetRequest) (*AdminReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetBudget not implemented")
+}
+func (UnimplementedAuctionAdminServer) DumpState(context.Context, *Void) (*StateDump, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DumpState not implemented")
+}
+func (UnimplementedAuctionAdminServer) ListReplicas(context.Context, *Void) (*ReplicaList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListReplicas not implemented")
+}
+func (UnimplementedAuctionAdminServer) ReplayEvents(*ReplayRequest, AuctionAdmin_ReplayEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ReplayEvents not implemented")
+}
+func (UnimplementedAuctionAdminServer) AddReplica(context.Context, *MembershipRequest) (*AdminReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddReplica not implemented")
+}
+func (UnimplementedAuctionAdminServer) RemoveReplica(context.Context, *MembershipRequest) (*AdminReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveReplica not implemented")
+}
+func (UnimplementedAuctionAdminServer) mustEmbedUnimplementedAuctionAdminServer() {}
+
+// UnsafeAuctionAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AuctionAdminServer will
+// result in compilation errors.
+type UnsafeAuctionAdminServer interface {
+	mustEmbedUnimplementedAuctionAdminServer()
+}
+
+func RegisterAuctionAdminServer(s grpc.ServiceRegistrar, srv AuctionAdminServer) {
+	s.RegisterService(&AuctionAdmin_ServiceDesc, srv)
+}
+
+func _AuctionAdmin_CloseNow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionAdminServer).CloseNow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionAdmin_CloseNow_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionAdminServer).CloseNow(ctx, req.(*AdminRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuctionAdmin_ExtendEndTime_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionAdminServer).ExtendEndTime(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionAdmin_ExtendEndTime_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionAdminServer).ExtendEndTime(ctx, req.(*ExtendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuctionAdmin_CancelAuction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AdminRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionAdminServer).CancelAuction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionAdmin_CancelAuction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionAdminServer).CancelAuction(ctx, req.(*AdminRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuctionAdmin_BanBidder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionAdminServer).BanBidder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionAdmin_BanBidder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionAdminServer).BanBidder(ctx, req.(*BanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuctionAdmin_SetBudget_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BudgetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionAdminServer).SetBudget(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionAdmin_SetBudget_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionAdminServer).SetBudget(ctx, req.(*BudgetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuctionAdmin_DumpState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Void)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionAdminServer).DumpState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionAdmin_DumpState_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionAdminServer).DumpState(ctx, req.(*Void))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuctionAdmin_ListReplicas_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Void)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionAdminServer).ListReplicas(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionAdmin_ListReplicas_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionAdminServer).ListReplicas(ctx, req.(*Void))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuctionAdmin_ReplayEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReplayRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AuctionAdminServer).ReplayEvents(m, &auctionAdminReplayEventsServer{stream})
+}
+
+type AuctionAdmin_ReplayEventsServer interface {
+	Send(*DomainEvent) error
+	grpc.ServerStream
+}
+
+type auctionAdminReplayEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *auctionAdminReplayEventsServer) Send(m *DomainEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _AuctionAdmin_AddReplica_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MembershipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionAdminServer).AddReplica(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionAdmin_AddReplica_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionAdminServer).AddReplica(ctx, req.(*MembershipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuctionAdmin_RemoveReplica_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MembershipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuctionAdminServer).RemoveReplica(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AuctionAdmin_RemoveReplica_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuctionAdminServer).RemoveReplica(ctx, req.(*MembershipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AuctionAdmin_ServiceDesc is the grpc.ServiceDesc for AuctionAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AuctionAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.AuctionAdmin",
+	HandlerType: (*AuctionAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CloseNow",
+			Handler:    _AuctionAdmin_CloseNow_Handler,
+		},
+		{
+			MethodName: "ExtendEndTime",
+			Handler:    _AuctionAdmin_ExtendEndTime_Handler,
+		},
+		{
+			MethodName: "CancelAuction",
+			Handler:    _AuctionAdmin_CancelAuction_Handler,
+		},
+		{
+			MethodName: "BanBidder",
+			Handler:    _AuctionAdmin_BanBidder_Handler,
+		},
+		{
+			MethodName: "SetBudget",
+			Handler:    _AuctionAdmin_SetBudget_Handler,
+		},
+		{
+			MethodName: "DumpState",
+			Handler:    _AuctionAdmin_DumpState_Handler,
+		},
+		{
+			MethodName: "ListReplicas",
+			Handler:    _AuctionAdmin_ListReplicas_Handler,
+		},
+		{
+			MethodName: "AddReplica",
+			Handler:    _AuctionAdmin_AddReplica_Handler,
+		},
+		{
+			MethodName: "RemoveReplica",
+			Handler:    _AuctionAdmin_RemoveReplica_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ReplayEvents",
+			Handler:       _AuctionAdmin_ReplayEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/auction.proto",
+}
+
+const (
+	ReplicationService_Append_FullMethodName      = "/proto.ReplicationService/Append"
+	ReplicationService_Ping_FullMethodName        = "/proto.ReplicationService/Ping"
+	ReplicationService_FetchLog_FullMethodName    = "/proto.ReplicationService/FetchLog"
+	ReplicationService_RequestVote_FullMethodName = "/proto.ReplicationService/RequestVote"
+)
+
+// ReplicationServiceClient is the client API for ReplicationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReplicationServiceClient interface {
+	Append(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendReply, error)
+	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingReply, error)
+	FetchLog(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchReply, error)
+	RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteReply, error)
+}
+
+type replicationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReplicationServiceClient(cc grpc.ClientConnInterface) ReplicationServiceClient {
+	return &replicationServiceClient{cc}
+}
+
+func (c *replicationServiceClient) Append(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendReply, error) {
+	out := new(AppendReply)
+	err := c.cc.Invoke(ctx, ReplicationService_Append_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationServiceClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingReply, error) {
+	out := new(PingReply)
+	err := c.cc.Invoke(ctx, ReplicationService_Ping_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationServiceClient) FetchLog(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchReply, error) {
+	out := new(FetchReply)
+	err := c.cc.Invoke(ctx, ReplicationService_FetchLog_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationServiceClient) RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteReply, error) {
+	out := new(VoteReply)
+	err := c.cc.Invoke(ctx, ReplicationService_RequestVote_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReplicationServiceServer is the server API for ReplicationService service.
+// All implementations must embed UnimplementedReplicationServiceServer
+// for forward compatibility
+type ReplicationServiceServer interface {
+	Append(context.Context, *AppendRequest) (*AppendReply, error)
+	Ping(context.Context, *PingRequest) (*PingReply, error)
+	FetchLog(context.Context, *FetchRequest) (*FetchReply, error)
+	RequestVote(context.Context, *VoteRequest) (*VoteReply, error)
+	mustEmbedUnimplementedReplicationServiceServer()
+}
+
+// UnimplementedReplicationServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedReplicationServiceServer struct {
+}
+
+func (UnimplementedReplicationServiceServer) Append(context.Context, *AppendRequest) (*AppendReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Append not implemented")
+}
+func (UnimplementedReplicationServiceServer) Ping(context.Context, *PingRequest) (*PingReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (UnimplementedReplicationServiceServer) FetchLog(context.Context, *FetchRequest) (*FetchReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchLog not implemented")
+}
+func (UnimplementedReplicationServiceServer) RequestVote(context.Context, *VoteRequest) (*VoteReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestVote not implemented")
+}
+func (UnimplementedReplicationServiceServer) mustEmbedUnimplementedReplicationServiceServer() {}
+
+// UnsafeReplicationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReplicationServiceServer will
+// result in compilation errors.
+type UnsafeReplicationServiceServer interface {
+	mustEmbedUnimplementedReplicationServiceServer()
+}
+
+func RegisterReplicationServiceServer(s grpc.ServiceRegistrar, srv ReplicationServiceServer) {
+	s.RegisterService(&ReplicationService_ServiceDesc, srv)
+}
+
+func _ReplicationService_Append_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServiceServer).Append(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReplicationService_Append_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServiceServer).Append(ctx, req.(*AppendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReplicationService_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServiceServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReplicationService_Ping_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServiceServer).Ping(ctx, req.(*PingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReplicationService_FetchLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServiceServer).FetchLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReplicationService_FetchLog_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServiceServer).FetchLog(ctx, req.(*FetchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReplicationService_RequestVote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServiceServer).RequestVote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ReplicationService_RequestVote_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServiceServer).RequestVote(ctx, req.(*VoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ReplicationService_ServiceDesc is the grpc.ServiceDesc for ReplicationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ReplicationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.ReplicationService",
+	HandlerType: (*ReplicationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Append",
+			Handler:    _ReplicationService_Append_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _ReplicationService_Ping_Handler,
+		},
+		{
+			MethodName: "FetchLog",
+			Handler:    _ReplicationService_FetchLog_Handler,
+		},
+		{
+			MethodName: "RequestVote",
+			Handler:    _ReplicationService_RequestVote_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/auction.proto",
+}
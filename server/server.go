@@ -4,178 +4,272 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
-	"net"
+	"log/slog"
 	"os"
-	"sync"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	// this has to be the same as the go.mod module,
 	// followed by the path to the folder the proto file is in.
 	// inspired by https://github.com/PatrickMatthiesen/DSYS-gRPC-template and https://articles.wesionary.team/grpc-console-chat-application-in-go-dd77a29bb5c3
-	Auction "github.com/Alex-itu/A_Distributed_Auction_System/proto"
-
-	"google.golang.org/grpc"
+	"github.com/Alex-itu/A_Distributed_Auction_System/auctionserver"
+	"github.com/Alex-itu/A_Distributed_Auction_System/config"
+	"github.com/Alex-itu/A_Distributed_Auction_System/logging"
+	"github.com/Alex-itu/A_Distributed_Auction_System/payment"
+	"github.com/Alex-itu/A_Distributed_Auction_System/tracing"
+	"github.com/Alex-itu/A_Distributed_Auction_System/webhook"
 )
 
 // Run server with:
-// go run server/server.go -port 8080 -id 0
-
-type RMserver struct {
-	Auction.UnimplementedAuctionServiceServer        //need this if it's a server
-	port                                      string // Not required but useful if your server needs to know what port it's listening to
-	Id                                        int
-
-	mutex sync.Mutex // used to lock the server to avoid race conditions.
-
-}
-
-var clientID = 0
+// go run ./server -port 8080 -id 0 -peers ":8080 :8081 :8082" -clusterToken some-secret
+// or with a config file that describes the whole cluster (see the config package):
+// go run ./server -config cluster.yaml -id 0
+// A new replica is started with -join and then added to the running cluster with auctionctl:
+// go run ./server -port 8083 -id 3 -join -clusterToken some-secret
+// go run ./auctionctl add-replica 3 localhost:8083
+// The server itself lives in the auctionserver package, this only reads the flags and starts it.
 
 // flags are used to get arguments from the terminal. Flags take a value, a default value and a description of the flag.
 // to use a flag then just add it as an argument when running the program.
+var configFile = flag.String("config", "", "A YAML file with the settings of the cluster. Flags and AUCTION_* environment variables go over it")
 var port = flag.String("port", "8080", "Server port") // set with "-port <port>" in terminal
+var listen = flag.String("listen", "", "The address to listen on: host:port over IPv4 or IPv6, e.g. 0.0.0.0:8080 or [::]:8080, or unix:/path for a Unix socket (default localhost:<port>)")
+var advertise = flag.String("advertise", "", "The address the other replicas and the clients reach this server at, if not its address in -peers. It is sent to the whole cluster")
 var serverId = flag.Int("id", 0, "Server id")
 var endtime = flag.String("endtime", "00:00:00", "The end time for the auction in HH:MM:SS")
-var server *RMserver
-
-// Maps
-var clientNames = make(map[int32]string)
-var CurrentBids = make(map[int32]float32)
-var BackupAckRecieved = make(map[int]bool)
-
-var auctionOver = false
+var retractWindow = flag.Duration("retractWindow", 30*time.Second, "How long after making a bid the client can take it back (0 turns retractions off)")
+var retractCutoff = flag.Duration("retractCutoff", 1*time.Hour, "No bids can be taken back when the auction ends within this long")
+var paymentTimeout = flag.Duration("paymentTimeout", 1*time.Hour, "How long the winner has to pay after the close before the item goes to the next bidder in line (0 waits forever)")
+var payments = flag.String("payments", "", "The payment provider that checks the buyer paid: fake takes every payment that has a reference and is only for trying the auction out (empty turns payments off, nobody can pay). Needs -tlsCert, -tlsKey and -tlsCA")
+var peerAddrs = flag.String("peers", ":8080 :8081 :8082", "The addresses of all the replicas separated by spaces. The id is the index of this server in the list")
+var join = flag.Bool("join", false, "Start outside of the cluster and wait to be added with auctionctl add-replica. -peers is not used")
+var clusterToken = flag.String("clusterToken", "", "The token the replicas send each other, the same on every replica. Only calls with it can use the replication service. Needed with other replicas")
+var adminToken = flag.String("adminToken", "", "The token auctionctl has to send to use the admin service (empty turns the admin service off)")
+var bidderRate = flag.Float64("bidderRate", 0, "How many bids and retractions a second one bidder can make (0 is no limit). The bidder is the common name of its client certificate, without a verified one it is its address")
+var bidderBurst = flag.Int("bidderBurst", 0, "How many bids and retractions one bidder can make at once (default -bidderRate rounded up)")
+var addrRate = flag.Float64("addrRate", 0, "How many bids and retractions a second can come from one address (0 is no limit)")
+var addrBurst = flag.Int("addrBurst", 0, "How many bids and retractions can come from one address at once (default -addrRate rounded up)")
+var webhooks = flag.String("webhooks", "", "The URLs the leader sends the events of the auction to, separated by spaces (empty sends nothing)")
+var webhookSecret = flag.String("webhookSecret", "", "The key the webhooks are signed with, HMAC-SHA256 in the X-Auction-Signature header. Needed with -webhooks")
+var metricsAddr = flag.String("metrics", "", "Serve Prometheus metrics over HTTP at this address, e.g. localhost:9080 (empty turns them off)")
+var drainTimeout = flag.Duration("drainTimeout", 10*time.Second, "How long to wait for the running calls and the leadership hand off on SIGINT or SIGTERM before stopping anyway")
+var logOutput = flag.String("log", "stdout", "Where to log: stdout, stderr or a file, which is appended to and rotated")
+var logFormat = flag.String("logFormat", "json", "How to log: json or text")
+var logLevel = flag.String("logLevel", "info", "The lowest level that is logged: debug, info, warn or error")
+var logMaxSize = flag.Int64("logMaxSize", 10*1024*1024, "How big the log file can get, in bytes, before it is rotated")
+var logBackups = flag.Int("logBackups", 3, "How many rotated log files are kept")
+var traceExporter = flag.String("trace", "", "Send OpenTelemetry traces to: otlp (a collector) or file (empty turns tracing off)")
+var traceEndpoint = flag.String("traceEndpoint", "", "The collector address for otlp (default localhost:4317) or the file for file (default traces.json)")
+var traceSample = flag.Float64("traceSample", 1, "The share of the traces that are kept, from 0 to 1")
+var tlsCert = flag.String("tlsCert", "", "The certificate to serve with TLS (empty serves without TLS)")
+var tlsKey = flag.String("tlsKey", "", "The key of -tlsCert")
+var tlsCA = flag.String("tlsCA", "", "The CA the certificates of the other replicas are signed by. Needed to dial them with TLS")
+var server *auctionserver.RMserver
 
 func main() {
-	f := setLog() //uncomment this line to log to a log.txt file instead of the console
-	defer f.Close()
-
 	// This parses the flags and sets the correct/given corresponding values.
 	flag.Parse()
-	fmt.Println(".:server is starting:.")
-
-	go Timeout() 
+	if err := loadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := checkFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	// launch the server
-	launchServer()
-}
+	logger, closer, err := logging.New(logging.Config{
+		Output:     *logOutput,
+		Format:     *logFormat,
+		Level:      *logLevel,
+		MaxSize:    *logMaxSize,
+		MaxBackups: *logBackups,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer closer.Close()
+	slog.SetDefault(logger)
+	logger.Info("server is starting", "replica", *serverId)
+
+	tracerProvider, tracerCloser, err := tracing.New(tracing.Config{
+		Exporter:    *traceExporter,
+		Endpoint:    *traceEndpoint,
+		ServiceName: "auction-server",
+		SampleRatio: *traceSample,
+	})
+	if err != nil {
+		logger.Error("could not set up tracing", "err", err)
+		os.Exit(2)
+	}
+	defer tracerCloser.Close()
 
-func Timeout() {
 	// theTime is the time the auction ends + the date of today (to make it possible to parse using time.Parse)
 	theTime, _ := time.Parse(time.DateTime, strings.Split(fmt.Sprint(time.Now().Add(1 * time.Hour).String()), " ")[0] + " " + *endtime)
+	// the parsed time is one hour ahead of the local clock, so move it back to get the real end time
+	endTime := theTime.Add(-1 * time.Hour).Local()
 
-	// timedif is the time difference between the time the auction ends and the current time (given in seconds)
-	timedif := theTime.Unix() - time.Now().Add(1 * time.Hour).Unix()
-
-	// Sleeps for the time difference between the time the auction ends and the current time
-	time.Sleep(time.Duration(timedif) * time.Second)
-	fmt.Println("Closing auction")
-	log.Println("Closing auction")
-
-	// Sets the auctionOver variable to true, so that the clients can't bid anymore
-	auctionOver = true
-}
-
-func launchServer() {
-	fmt.Printf("Server %d: Attempts to create listener on port %s\n", *serverId, *port)
-	log.Printf("Server %d: Attempts to create listener on port %s\n", *serverId, *port)
+	var addrs []string
+	if *peerAddrs != "" {
+		addrs = strings.Split(*peerAddrs, " ")
+	}
+	listenAddr := *listen
+	if listenAddr == "" {
+		listenAddr = "localhost:" + *port
+	}
 
-	// Create listener for the RMserver connection
-	listOnServerClient, err := net.Listen("tcp", "localhost:"+*port)
+	serverTLS, err := config.ServerTLS(*tlsCert, *tlsKey, *tlsCA)
 	if err != nil {
-		fmt.Printf("Server %d: Failed to listen on port %s: %v \n", *serverId, *port, err)
-		log.Printf("Server %d: Failed to listen on port %s: %v", *serverId, *port, err) //If it fails to listen on the port, run launchServer method again with the next value/port in ports array
-		return
+		logger.Error("could not load the certificates", "err", err)
+		os.Exit(2)
 	}
-
-	// makes gRPC server using the options
-	// you can add options here if you want or remove the options part entirely
-	var opts []grpc.ServerOption
-	grpcServer := grpc.NewServer(opts...)
-
-	// makes a new server instance using the name and port from the flags.
-	server = &RMserver{
-		port: *port,
-		Id:   *serverId,
+	peerTLS, err := config.ClientTLS(*tlsCert, *tlsKey, *tlsCA)
+	if err != nil {
+		logger.Error("could not load the certificates", "err", err)
+		os.Exit(2)
 	}
 
-	Auction.RegisterAuctionServiceServer(grpcServer, server) //Registers the server to the gRPC server.
-
-	fmt.Printf("Server %d: Listening at %v \n", *serverId, listOnServerClient.Addr())
-	log.Printf("Server %d: Listening at %v \n", *serverId, listOnServerClient.Addr())
-
-	if err := grpcServer.Serve(listOnServerClient); err != nil {
-		fmt.Printf("failed to serve %v", err)
-		log.Fatalf("failed to serve %v", err)
+	var provider payment.Provider
+	switch *payments {
+	case "fake":
+		logger.Warn("PAYMENTS ARE NOT CHECKED: -payments fake takes every payment that has a reference, never use it for a real auction")
+		provider = payment.NewFake()
+	case "":
+		logger.Warn("there is no payment provider (-payments), nobody can pay and every buyer will default")
 	}
-	// code here is unreachable because grpcServer.Serve occupies the current thread.
-}
 
-func (s *RMserver) Bid(cxt context.Context, msg *Auction.BidAmount) (*Auction.Ack, error) {
-	maxid, max := HighestBid()
-	
-	if auctionOver {
-		return &Auction.Ack{Message: "The auction is over. The winner is " + clientNames[maxid] + " with a bid of " + fmt.Sprint(max), ClientID: maxid}, nil
-	}
-	if msg.GetAmount() > max { 
-		clientNames[msg.ClientID] = msg.ClientName
-		CurrentBids[msg.ClientID] = msg.Amount
-		return &Auction.Ack{Message: "Nice job team from: server " + fmt.Sprint(*serverId),ClientID: msg.ClientID}, nil
-	} else {
-		return &Auction.Ack{Message: "Bid is lower than current highest bid: " + fmt.Sprint(max), ClientID: msg.ClientID}, nil
-	} 
-}
+	// makes a new server instance using the id and port from the flags.
+	server, err = auctionserver.New(auctionserver.Config{
+		ID:             *serverId,
+		Peers:          addrs,
+		Join:           *join,
+		ListenAddr:     listenAddr,
+		AdvertiseAddr:  *advertise,
+		EndTime:        endTime,
+		RetractWindow:  *retractWindow,
+		RetractCutoff:  *retractCutoff,
+		PaymentTimeout: *paymentTimeout,
+		Payments:       provider,
+		AdminToken:     *adminToken,
+		ClusterToken:   *clusterToken,
+		RateLimit: auctionserver.RateLimit{
+			BidderRate:  *bidderRate,
+			BidderBurst: *bidderBurst,
+			AddrRate:    *addrRate,
+			AddrBurst:   *addrBurst,
+		},
+		MetricsAddr:    *metricsAddr,
+		Webhooks:       webhook.Config{URLs: strings.Fields(*webhooks), Secret: *webhookSecret},
+		Logger:         logger,
+		TracerProvider: tracerProvider,
+		TLS:            serverTLS,
+		PeerTLS:        peerTLS,
+	})
+	if err != nil {
+		logger.Error("could not make the server", "replica", *serverId, "err", err)
+		os.Exit(1)
+	}
+	defer server.Stop()
 
-func (s *RMserver) Result(cxt context.Context, msg *Auction.Void) (*Auction.Outcome, error) {
-	maxid, max := HighestBid()	
-	if auctionOver {
-		return &Auction.Outcome{Amount: max, ClientName: clientNames[maxid], BidDone: true}, nil	
-	} else {
-		return &Auction.Outcome{Amount: max, ClientName: clientNames[maxid], BidDone: false}, nil
+	// launch the server
+	if err := server.Start(); err != nil {
+		logger.Error("could not start the server", "replica", *serverId, "err", err)
+		server.Stop()
+		os.Exit(1)
+	}
+	if *join {
+		addr := *advertise
+		if addr == "" {
+			addr = listenAddr
+		}
+		logger.Info("waiting to be added to the cluster", "command", fmt.Sprintf("auctionctl add-replica %d %s", *serverId, addr))
 	}
-}
 
-func HighestBid() (int32, float32) {
-	max := float32(-1.0)
-	maxid := int32(-1)
-	for id, CurrentBid := range CurrentBids {
-		if CurrentBid > max {
-			max = CurrentBid
-			maxid = id
+	// SIGINT (ctrl+c) or SIGTERM shut the server down gracefully, a second one stops it right away
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	served := make(chan error, 1)
+	go func() { served <- server.Wait() }()
+	select {
+	case err := <-served:
+		if err != nil {
+			logger.Error("stopped serving", "replica", *serverId, "err", err)
+			server.Stop()
+			os.Exit(1)
+		}
+	case <-signals.Done():
+		stopSignals()
+		ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Warn("could not shut down gracefully", "replica", *serverId, "err", err)
 		}
 	}
-	return maxid, max
 }
 
-// Get preferred outbound ip of this machine
-// Usefull if you have to know which ip you should dial, in a client running on an other computer
-func GetOutboundIP() net.IP {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+// loadConfig sets the flags that were not given from the environment and then from the -config file.
+func loadConfig() error {
+	if err := config.ApplyEnv(flag.CommandLine); err != nil {
+		return err
+	}
+	if *configFile == "" {
+		return nil
+	}
+	file, err := config.Load(*configFile)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer conn.Close()
-
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-
-	return localAddr.IP
+	// a new replica is not in the file yet, so it only takes the settings of the whole cluster
+	if *join {
+		return config.ApplyFile(flag.CommandLine, file.JoinFlags())
+	}
+	// the id picks this server's part of the file, so it has to come from the flag or the environment
+	values, err := file.ServerFlags(*serverId)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *configFile, err)
+	}
+	return config.ApplyFile(flag.CommandLine, values)
 }
 
-// sets the logger to use a log.txt file instead of the console
-func setLog() *os.File {
-	// Clears the log.txt file when a new server is started
-	if err := os.Truncate("log_server" + fmt.Sprint(*serverId) + ".txt", 0); err != nil {
-		fmt.Printf("Failed to truncate: %v \n", err)
-		log.Printf("Failed to truncate: %v", err)
+// checkFlags checks the flags that are only used after the server has started, so a typo
+// is found right away instead of when the auction should have ended.
+func checkFlags() error {
+	if _, err := time.Parse(time.TimeOnly, *endtime); err != nil {
+		return fmt.Errorf("-endtime: %q is not a time of day like 18:30:00", *endtime)
 	}
-
-	// This connects to the log file/changes the output of the log informaiton to the log.txt file.
-	f, err := os.OpenFile("log_server" + fmt.Sprint(*serverId) + ".txt", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		fmt.Printf("error opening file: %v", err)
-		log.Fatalf("error opening file: %v", err)
+	if *listen != "" {
+		if err := config.CheckAddr(*listen); err != nil {
+			return fmt.Errorf("-listen: %v", err)
+		}
+	}
+	if *advertise != "" {
+		if err := config.CheckAddr(*advertise); err != nil {
+			return fmt.Errorf("-advertise: %v", err)
+		}
+	}
+	if *paymentTimeout < 0 {
+		return fmt.Errorf("-paymentTimeout: can't be negative, use 0 to wait forever")
+	}
+	if *clusterToken == "" && (*join || len(strings.Fields(*peerAddrs)) > 1) {
+		return fmt.Errorf("-clusterToken is needed when there are other replicas, give all of them the same")
+	}
+	if *webhooks != "" && *webhookSecret == "" {
+		return fmt.Errorf("-webhooks needs a -webhookSecret to sign the events with")
+	}
+	if *payments != "" && *payments != "fake" {
+		return fmt.Errorf("-payments: %q is not a payment provider, the only one built in is fake", *payments)
+	}
+	if *payments != "" && (*tlsCert == "" || *tlsCA == "") {
+		return fmt.Errorf("-payments needs -tlsCert, -tlsKey and -tlsCA: the buyer confirms a payment with a client certificate the server verifies")
+	}
+	if *bidderRate < 0 || *bidderBurst < 0 || *addrRate < 0 || *addrBurst < 0 {
+		return fmt.Errorf("-bidderRate, -bidderBurst, -addrRate and -addrBurst can't be negative")
+	}
+	if *drainTimeout <= 0 {
+		return fmt.Errorf("-drainTimeout: %v is not a positive duration", *drainTimeout)
 	}
-	log.SetOutput(f)
-	return f
+	return nil
 }
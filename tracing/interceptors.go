@@ -0,0 +1,199 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/logging"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// InstrumentationName is the name the tracers of this module are made with.
+const InstrumentationName = "github.com/Alex-itu/A_Distributed_Auction_System"
+
+// the trace context is sent in the W3C traceparent header, which every collector understands
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Untraced returns a context whose calls are not traced, for the pings, heartbeats and health
+// watches that would otherwise start a new trace several times a second. It works by making the
+// calls part of a trace that is not sampled, so the server they go to does not trace them either.
+func Untraced(ctx context.Context) context.Context {
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	rand.Read(traceID[:])
+	rand.Read(spanID[:])
+	return trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+}
+
+// UnaryClientInterceptor makes a span for every call and sends the trace context with it.
+// attrs are added to every span, e.g. the id of the replica making the call.
+func UnaryClientInterceptor(tp trace.TracerProvider, attrs ...attribute.KeyValue) grpc.UnaryClientInterceptor {
+	tracer := tp.Tracer(InstrumentationName)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := startClient(ctx, tracer, method, cc.Target(), attrs)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		end(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor is UnaryClientInterceptor for streams. The span ends when the stream does.
+func StreamClientInterceptor(tp trace.TracerProvider, attrs ...attribute.KeyValue) grpc.StreamClientInterceptor {
+	tracer := tp.Tracer(InstrumentationName)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := startClient(ctx, tracer, method, cc.Target(), attrs)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			end(span, err)
+			return nil, err
+		}
+		return &clientStream{ClientStream: stream, span: span}, nil
+	}
+}
+
+func startClient(ctx context.Context, tracer trace.Tracer, method, target string, attrs []attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, spanName(method),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(methodAttrs(method)...),
+		trace.WithAttributes(attribute.String("net.peer.name", target)),
+		trace.WithAttributes(attrs...),
+	)
+	if id := logging.RequestID(ctx); id != "" {
+		span.SetAttributes(attribute.String("request_id", id))
+	}
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// clientStream ends the span when the stream ends.
+type clientStream struct {
+	grpc.ClientStream
+	span trace.Span
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		end(s.span, nil)
+	} else if err != nil {
+		end(s.span, err)
+	}
+	return err
+}
+
+// UnaryServerInterceptor continues the trace the caller sent, or starts a new one, with a span
+// for the call. attrs are added to every span, e.g. the id of the replica.
+func UnaryServerInterceptor(tp trace.TracerProvider, attrs ...attribute.KeyValue) grpc.UnaryServerInterceptor {
+	tracer := tp.Tracer(InstrumentationName)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := startServer(ctx, tracer, info.FullMethod, attrs)
+		resp, err := handler(ctx, req)
+		end(span, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func StreamServerInterceptor(tp trace.TracerProvider, attrs ...attribute.KeyValue) grpc.StreamServerInterceptor {
+	tracer := tp.Tracer(InstrumentationName)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startServer(ss.Context(), tracer, info.FullMethod, attrs)
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		end(span, err)
+		return err
+	}
+}
+
+func startServer(ctx context.Context, tracer trace.Tracer, method string, attrs []attribute.KeyValue) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = propagator.Extract(ctx, metadataCarrier(md))
+	ctx, span := tracer.Start(ctx, spanName(method),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(methodAttrs(method)...),
+		trace.WithAttributes(attrs...),
+	)
+	// the logging interceptor runs first, so the request id is there to tie the span to the logs
+	if id := logging.RequestID(ctx); id != "" {
+		span.SetAttributes(attribute.String("request_id", id))
+	}
+	return ctx, span
+}
+
+// serverStream swaps the context of a stream for one with the span.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// end records how the call went and ends the span.
+func end(span trace.Span, err error) {
+	st := status.Convert(err)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(st.Code())))
+	if err != nil {
+		span.SetStatus(otelcodes.Error, st.Message())
+	}
+	span.End()
+}
+
+// spanName turns "/proto.AuctionService/Bid" into "proto.AuctionService/Bid", as the conventions want.
+func spanName(method string) string {
+	return strings.TrimPrefix(method, "/")
+}
+
+func methodAttrs(method string) []attribute.KeyValue {
+	service, name := "unknown", spanName(method)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		service, name = name[:i], name[i+1:]
+	}
+	return []attribute.KeyValue{
+		attribute.String("rpc.system", "grpc"),
+		attribute.String("rpc.service", service),
+		attribute.String("rpc.method", name),
+	}
+}
+
+// metadataCarrier lets the propagator read and write gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,124 @@
+// Package tracing sets up OpenTelemetry tracing for the servers and the clients. The trace context
+// is sent along with every gRPC call by the interceptors in interceptors.go, so a bid can be followed
+// from the client to the servers it tried, and from the leader to every replica it sent the bid to.
+//
+// The spans are sent to an OTLP collector (e.g. Jaeger or the OpenTelemetry collector on
+// localhost:4317) or written to a file, one JSON object per span.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/logging"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Config says where to send the spans.
+type Config struct {
+	Exporter string // "otlp", "file" or "" for no tracing
+	// Endpoint is the address of the collector for otlp (default localhost:4317)
+	// and the file the spans are written to for file (default traces.json).
+	Endpoint string
+	// ServiceName is what the collector shows the spans under, e.g. "auction-server".
+	ServiceName string
+	// SampleRatio is the share of the traces that are kept, from 0 to 1. Default 1, which keeps them all.
+	// A call that is part of a trace is always kept if the trace is, so a sampled bid is complete.
+	SampleRatio float64
+}
+
+const (
+	defaultEndpoint = "localhost:4317"
+	defaultFile     = "traces.json"
+
+	// the trace file is rotated like the log files
+	fileMaxSize    = 10 * 1024 * 1024
+	fileMaxBackups = 3
+
+	shutdownTimeout = 5 * time.Second
+)
+
+// New makes a tracer provider from cfg. The closer sends the spans that have not been sent yet
+// and closes the exporter. With no exporter the provider does nothing.
+func New(cfg Config) (trace.TracerProvider, io.Closer, error) {
+	var exporter sdktrace.SpanExporter
+	var file io.Closer
+	switch cfg.Exporter {
+	case "", "none":
+		return noop.NewTracerProvider(), nopCloser{}, nil
+	case "otlp":
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = defaultEndpoint
+		}
+		// the collector is usually on the same machine, so there is no TLS
+		var err error
+		exporter, err = otlptracegrpc.New(context.Background(), otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+		if err != nil {
+			return nil, nil, fmt.Errorf("tracing: %v", err)
+		}
+	case "file":
+		path := cfg.Endpoint
+		if path == "" {
+			path = defaultFile
+		}
+		f, err := logging.OpenFile(path, fileMaxSize, fileMaxBackups)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tracing: %v", err)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("tracing: %v", err)
+		}
+		file = f
+	default:
+		return nil, nil, fmt.Errorf("tracing: unknown exporter %q", cfg.Exporter)
+	}
+
+	ratio := cfg.SampleRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = 1
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("tracing: %v", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	return tp, &shutdown{tp: tp, file: file}, nil
+}
+
+// shutdown flushes the spans before the file is closed.
+type shutdown struct {
+	tp   *sdktrace.TracerProvider
+	file io.Closer
+}
+
+func (s *shutdown) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := s.tp.Shutdown(ctx)
+	if s.file != nil {
+		if ferr := s.file.Close(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
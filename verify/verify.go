@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Alex-itu/A_Distributed_Auction_System/audit"
+	"github.com/Alex-itu/A_Distributed_Auction_System/events"
+)
+
+// Checks the audit logs written by the servers without needing the servers to run.
+// Run with:
+// go run ./verify audit_server0.log audit_server1.log audit_server2.log
+
+func main() {
+	flag.Usage = func() {
+		fmt.Println("usage: verify <audit log> [<audit log> ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ok := true
+	logs := make(map[string][]audit.Record)
+	var paths []string
+	for _, path := range flag.Args() {
+		records, err := audit.ReadFile(path)
+		if err == nil {
+			err = audit.Verify(records)
+		}
+		if err != nil {
+			fmt.Printf("%s: BROKEN: %v \n", path, err)
+			ok = false
+			continue
+		}
+		logs[path] = records
+		paths = append(paths, path)
+		fmt.Printf("%s: OK, %d records \n", path, len(records))
+	}
+
+	// The logs of different replicas can be different lengths (one of them might have been down), and
+	// the end of a log can have records the leader never got committed, which a new leader replaced.
+	// A record that a majority of the logs has is committed, so up to there every log must agree.
+	committed := committedRecords(paths, logs)
+	for _, path := range paths {
+		records := logs[path]
+		if seq := firstDifference(committed, records); seq != 0 {
+			fmt.Printf("%s differs from the committed records from record %d \n", path, seq)
+			ok = false
+		} else if len(records) > len(committed) {
+			fmt.Printf("warning: %s has %d records after record %d that most logs don't, they were not committed (yet) \n", path, len(records)-len(committed), len(committed))
+		}
+	}
+
+	if len(paths) > 0 {
+		name, amount, closed, cancelled := winner(committed)
+		fmt.Printf("%d committed records: ", len(committed))
+		if cancelled {
+			fmt.Printf("the auction was cancelled \n")
+		} else if closed {
+			fmt.Printf("the auction is closed and the winner is %s with a bid of %v \n", name, amount)
+		} else {
+			fmt.Printf("the auction is open and the highest bid is %v by %s \n", amount, name)
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// committedRecords returns the longest run of records from the start that more than half of the logs
+// have. Every record carries the hash of the one before it, so logs with the same record at a seq
+// have the same records up to it.
+func committedRecords(paths []string, logs map[string][]audit.Record) []audit.Record {
+	var committed []audit.Record
+	for seq := 1; ; seq++ {
+		count := make(map[string]int)
+		var majority []audit.Record
+		for _, path := range paths {
+			records := logs[path]
+			if len(records) < seq {
+				continue
+			}
+			hash := records[seq-1].Hash
+			count[hash]++
+			if count[hash]*2 > len(paths) {
+				majority = records
+			}
+		}
+		if majority == nil {
+			return committed
+		}
+		committed = majority[:seq]
+	}
+}
+
+// winner folds the events in the records the same way the servers do and returns the highest bid.
+func winner(records []audit.Record) (string, float32, bool, bool) {
+	var replayed []events.Event
+	for _, r := range records {
+		if e, ok := events.FromRecord(r); ok {
+			replayed = append(replayed, e)
+		}
+	}
+	auction := events.Fold(time.Time{}, replayed)
+	maxid, max := auction.Highest()
+	return auction.Names[maxid], max, auction.Over, auction.Cancelled
+}
+
+// firstDifference returns the seq of the first record that is not the same in both logs, or 0 if they agree
+// where they overlap.
+func firstDifference(a, b []audit.Record) int64 {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].Hash != b[i].Hash {
+			return a[i].Seq
+		}
+	}
+	return 0
+}
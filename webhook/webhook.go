@@ -0,0 +1,202 @@
+// Package webhook sends events to other systems as HTTP POSTs with a JSON body. Every request is
+// signed with HMAC-SHA256 over the body, so the receiver can check it came from us:
+//
+//	X-Auction-Event: bid_accepted
+//	X-Auction-Event-ID: 42-bid_accepted
+//	X-Auction-Signature: sha256=<hex of HMAC-SHA256(secret, body)>
+//
+// A receiver checks the signature with Verify. A request that fails or gets a 5xx, 408 or 429
+// is tried again, waiting longer every time. An event can arrive more than once (e.g. when the
+// sender goes down before it could note that it was delivered), but always with the same id,
+// so the receiver can drop the ones it has seen.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The headers every request has.
+const (
+	EventHeader     = "X-Auction-Event"
+	EventIDHeader   = "X-Auction-Event-ID"
+	SignatureHeader = "X-Auction-Signature"
+)
+
+// Config is where and how to send the events. URLs and Secret are required.
+type Config struct {
+	URLs   []string // every event is sent to all of them
+	Secret string   // the key of the signatures, a receiver can't trust an event that is not signed
+
+	Retries       int           // how many more times to try a request that failed. Default 5
+	RetryDelay    time.Duration // how long to wait before the first retry, doubled every time. Default 200ms
+	MaxRetryDelay time.Duration // the longest wait between two tries. Default 5s
+	Timeout       time.Duration // how long one request may take. Default 5s
+
+	// Client sends the requests. Default an http.Client with Timeout.
+	Client *http.Client
+	// Logger is where the failed tries are logged to. Default slog.Default()
+	Logger *slog.Logger
+}
+
+// Event is one thing that happened. Data is sent as the "data" field of the body.
+type Event struct {
+	ID   string      `json:"id"` // the same every time the event is sent
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Sender sends events. It is safe to use from several goroutines.
+type Sender struct {
+	cfg Config
+}
+
+func New(cfg Config) (*Sender, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("webhook: no URLs given")
+	}
+	for _, u := range cfg.URLs {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return nil, fmt.Errorf("webhook: %q is not an http or https URL", u)
+		}
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("webhook: no Secret given, the receivers could not check the events came from us")
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = 5
+	}
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = 200 * time.Millisecond
+	}
+	if cfg.MaxRetryDelay == 0 {
+		cfg.MaxRetryDelay = 5 * time.Second
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.Timeout}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Sender{cfg: cfg}, nil
+}
+
+// URLs returns the URLs the events are sent to.
+func (s *Sender) URLs() []string {
+	return append([]string(nil), s.cfg.URLs...)
+}
+
+// Send sends ev to every URL and returns once each of them took it or its retries ran out.
+// The error says which URLs did not take it.
+func (s *Sender) Send(ctx context.Context, ev Event) error {
+	var errs []error
+	for _, url := range s.cfg.URLs {
+		if err := s.SendTo(ctx, url, ev); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SendTo sends ev to one of the URLs and returns once it took it or the retries ran out.
+func (s *Sender) SendTo(ctx context.Context, url string, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("webhook: could not encode event %s: %v", ev.ID, err)
+	}
+	return s.deliver(ctx, url, ev, body)
+}
+
+// deliver posts body to url until it is taken, the retries run out or ctx is done.
+func (s *Sender) deliver(ctx context.Context, url string, ev Event, body []byte) error {
+	var err error
+	for try := 0; try <= s.cfg.Retries; try++ {
+		if try > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(s.backoff(try)):
+			}
+		}
+		var retry bool
+		retry, err = s.post(ctx, url, ev, body)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return err
+		}
+		s.cfg.Logger.Warn("webhook failed, trying again", "url", url, "event", ev.ID, "try", try+1, "err", err)
+	}
+	return err
+}
+
+// post makes one request. retry says if another try could go through.
+func (s *Sender) post(ctx context.Context, url string, ev Event, body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, ev.Type)
+	req.Header.Set(EventIDHeader, ev.ID)
+	req.Header.Set(SignatureHeader, Sign(s.cfg.Secret, body))
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024)) // so the connection can be used again
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("webhook: %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook: %s", resp.Status)
+	}
+}
+
+// backoff is how long to wait before a retry: RetryDelay doubled every try up to MaxRetryDelay,
+// with up to half of it taken off at random.
+func (s *Sender) backoff(try int) time.Duration {
+	delay := s.cfg.RetryDelay
+	for i := 1; i < try && delay < s.cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > s.cfg.MaxRetryDelay {
+		delay = s.cfg.MaxRetryDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Sign returns the signature header of body: "sha256=" and the hex of its HMAC-SHA256 with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports if signature is the signature header of body with secret.
+// It takes the same time whatever the signature is, so it can't be guessed byte by byte.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}